@@ -1,47 +1,142 @@
 package client
 
 import (
+	"errors"
 	"log/slog"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Size of the buffer every client reads commands through
+const ReadBufferSize = 1024
+
+// States of a replica attached to this server, like SLAVE_STATE_* in Redis
+const (
+	ReplicaWaitBgsave = "wait_bgsave" // The RDB is being transferred
+	ReplicaOnline     = "online"      // Receives the replication stream
 )
 
+/*
+* A WAIT or WAITAOF the client is blocked on. The command handler fills in what to wait for, the
+* server the offsets to reach at the time the client got blocked
+ */
+type WaitRequest struct {
+	NumReplicas int
+	// WAITAOF only: the writes must be fsynced to the AOF of the master (NumLocal) and the replicas
+	NumLocal int
+	Aof      bool
+	// Zero blocks forever
+	Timeout time.Duration
+
+	// master_repl_offset the replicas have to acknowledge
+	Offset int64
+	// Bytes of the local AOF that have to be fsynced
+	AofOffset int64
+}
+
+// Ids are handed out incrementally and never reused
+var nextClientId atomic.Int64
+
+// A complete command read from a client
 type ClientMsg struct {
-	Conn net.Conn
-	Msg  []byte
+	Conn    net.Conn
+	Client  *Client
+	Command []string
 }
 
 type Client struct {
+	// Unique id of the connection, used by CLIENT ID and tracking redirection
+	Id int64
+	// RESP protocol version spoken by the client, switched with HELLO
+	Protocol int
+	Name     string
+
+	// Set when the client is a replica: the port it listens on (REPLCONF listening-port), its
+	// state once it sent PSYNC and the last offset it acknowledged with REPLCONF ACK
+	ReplicaListeningPort string
+	ReplicaState         string
+	ReplicaAckOffset     int64
+	ReplicaAckTime       time.Time
+	// Offset the replica has fsynced to its AOF, sent with REPLCONF ACK <offset> FACK <aofoffset>
+	ReplicaAofAckOffset int64
+	// Bytes of the replication stream held for the replica until it loaded the RDB
+	ReplicaPending int64
+
+	// Set while the client is blocked by WAIT or WAITAOF
+	Wait *WaitRequest
+
+	// Set on the connection of a replica to its master, the only client that writes to a read only replica
+	Master bool
+	// Set by READONLY: a cluster replica serves reads of its master's slots instead of redirecting
+	ReadOnly bool
+	// Set by ASKING: the next command is served for a slot this node is importing
+	Asking bool
+
 	conn      net.Conn
 	msgChan   chan ClientMsg
-	closeChan chan net.Conn
+	closeChan chan *Client
+	mu        sync.Mutex
 }
 
-func NewClient(conn net.Conn, msgChan chan ClientMsg, closeChan chan net.Conn) *Client {
+func NewClient(conn net.Conn, msgChan chan ClientMsg, closeChan chan *Client) *Client {
 	return &Client{
+		Id:        nextClientId.Add(1),
+		Protocol:  2,
 		conn:      conn,
 		msgChan:   msgChan,
 		closeChan: closeChan,
 	}
 }
 
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
 /*
-* Responsble for reading oncomming messages from client and sending them back to the server
+* Writes a serialized response to the client. Messages can be pushed to a client from
+* several places (pub/sub, tracking invalidations) so writes are serialized
+ */
+func (c *Client) Write(response string) error {
+	defer c.mu.Unlock()
+
+	c.mu.Lock()
+	_, err := c.conn.Write([]byte(response))
+	return err
+}
+
+/*
+* Responsble for reading oncomming commands from client and sending them back to the server. The
+* connection is read as a stream, a command split over several reads is sent once it is complete
  */
 func (c *Client) ReadLoop() {
+	reader := resp.NewReaderSize(c.conn, ReadBufferSize)
 	for {
-		buff := make([]byte, 1024)
-		n, err := c.conn.Read(buff)
+		command, _, err := reader.ReadCommand()
 		if err != nil {
 			slog.Error("error reading from connection", "err", err)
-			// TODO: Send message back to server to close connection
+			// Like Redis the client is told about a malformed command before being disconnected
+			if errors.Is(err, resp.ErrProtocol) {
+				c.Write(resp.RESPSerializeError("ERR Protocol error: " + strings.TrimPrefix(err.Error(), resp.ErrProtocol.Error()+": ")))
+			}
+			// Let the server clean up any state held for the connection before closing it
+			c.closeChan <- c
 			return
 		}
+		// An empty array is not a command
+		if len(command) == 0 {
+			continue
+		}
 
-		command := buff[:n]
 		// Send command back to server
 		c.msgChan <- ClientMsg{
-			msg:  command,
-			conn: c.conn,
+			Command: command,
+			Conn:    c.conn,
+			Client:  c,
 		}
 	}
 }
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+func newTestClient() (*Client, net.Conn, chan ClientMsg, chan *Client) {
+	server, conn := net.Pipe()
+	msgChan, closeChan := make(chan ClientMsg), make(chan *Client, 1)
+	c := NewClient(server, msgChan, closeChan)
+	go c.ReadLoop()
+	return c, conn, msgChan, closeChan
+}
+
+func TestReadLoopLargeCommand(t *testing.T) {
+	_, conn, msgChan, _ := newTestClient()
+	defer conn.Close()
+
+	// Larger than the read buffer and written in several pieces, followed by a pipelined command
+	value := strings.Repeat("v", 3*ReadBufferSize)
+	raw := resp.RESPSerializeRESPArray([]string{"SET", "key", value}) + resp.RESPSerializeRESPArray([]string{"GET", "key"})
+	go func() {
+		for len(raw) > 0 {
+			n := min(len(raw), 700)
+			conn.Write([]byte(raw[:n]))
+			raw = raw[n:]
+		}
+	}()
+
+	msg := <-msgChan
+	if len(msg.Command) != 3 || msg.Command[0] != "SET" || msg.Command[2] != value {
+		t.Fatalf("Expected SET with a %d byte value, got %d arguments", len(value), len(msg.Command))
+	}
+	if msg = <-msgChan; strings.Join(msg.Command, " ") != "GET key" {
+		t.Fatalf("Expected GET key, got %q", msg.Command)
+	}
+}
+
+func TestReadLoopProtocolError(t *testing.T) {
+	c, conn, _, closeChan := newTestClient()
+	defer conn.Close()
+
+	go conn.Write([]byte("*1\r\n$3\r\nfoobar\r\n"))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(reply, "-ERR Protocol error") {
+		t.Fatalf("Expected a protocol error, got %q (err %v)", reply, err)
+	}
+	if closed := <-closeChan; closed != c {
+		t.Fatalf("Expected the client to be closed")
+	}
+}
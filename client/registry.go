@@ -0,0 +1,54 @@
+package client
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry keeps track of the connected clients by id
+type Registry struct {
+	clients map[int64]*Client
+	mu      sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		clients: map[int64]*Client{},
+		mu:      sync.Mutex{},
+	}
+}
+
+func (r *Registry) Add(c *Client) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	r.clients[c.Id] = c
+}
+
+func (r *Registry) Remove(c *Client) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	delete(r.clients, c.Id)
+}
+
+func (r *Registry) Get(id int64) (*Client, bool) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	c, ok := r.clients[id]
+	return c, ok
+}
+
+// Returns every connected client ordered by id
+func (r *Registry) All() []*Client {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Id < clients[j].Id })
+	return clients
+}
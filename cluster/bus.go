@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Messages a link buffers before it is considered stuck and dropped
+const linkBufferSize = 256
+
+/*
+* A connection of the cluster bus. Every node keeps an outbound link with each node it knows, it
+* sends PINGs and requests over it and reads the replies. The links other nodes open to it are
+* inbound links, it only answers the messages it reads from them
+ */
+type link struct {
+	// Node the outbound link was opened to, nil for inbound links
+	node *Node
+	// nil while an outbound link connects
+	conn    net.Conn
+	created time.Time
+	out     chan string
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newLink(node *Node, conn net.Conn) *link {
+	return &link{
+		node:    node,
+		conn:    conn,
+		created: time.Now(),
+		out:     make(chan string, linkBufferSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Queues a message without blocking the event loop. A link that stopped draining is closed
+func (l *link) send(m *message) {
+	select {
+	case l.out <- m.encode():
+	default:
+		l.close()
+	}
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		close(l.done)
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	})
+}
+
+// IP of the other end of the link
+func (l *link) remoteIP() string {
+	ip, _, _ := net.SplitHostPort(l.conn.RemoteAddr().String())
+	return ip
+}
+
+// IP this node was reached at over the link
+func (l *link) localIP() string {
+	ip, _, _ := net.SplitHostPort(l.conn.LocalAddr().String())
+	return ip
+}
+
+type eventKind int
+
+const (
+	eventConnected eventKind = iota
+	eventMessage
+	eventClosed
+)
+
+/*
+* Something that happened on a link of the cluster bus. The goroutines of the links hand events
+* to the event loop of the server, which applies them to the cluster with HandleEvent
+ */
+type Event struct {
+	kind eventKind
+	link *link
+	// eventConnected: the connection of an outbound link
+	conn net.Conn
+	msg  *message
+	err  error
+}
+
+// Events of the cluster bus, to be passed to HandleEvent by the event loop
+func (c *Cluster) Events() <-chan Event {
+	return c.events
+}
+
+// Starts accepting the links of other nodes on the bus port
+func (c *Cluster) Listen() error {
+	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", c.myself.BusPort))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				slog.Error("Error accepting cluster bus connection", "err", err)
+				continue
+			}
+			c.startLink(newLink(nil, conn))
+		}
+	}()
+	return nil
+}
+
+// Opens the outbound link with the node. The node is only sent messages once it is connected
+func (c *Cluster) connect(n *Node) {
+	l := newLink(n, nil)
+	n.link = l
+	// A node that can not be reached at all ends up failing like one that stopped answering
+	if n.pingSent.IsZero() {
+		n.pingSent = time.Now()
+	}
+
+	go func() {
+		addr := net.JoinHostPort(n.IP, strconv.Itoa(n.BusPort))
+		conn, err := net.DialTimeout("tcp", addr, c.nodeTimeout)
+		if err != nil {
+			c.events <- Event{kind: eventClosed, link: l, err: err}
+			return
+		}
+		c.events <- Event{kind: eventConnected, link: l, conn: conn}
+	}()
+}
+
+// Starts the goroutines reading and writing the connection of the link
+func (c *Cluster) startLink(l *link) {
+	go func() {
+		for {
+			select {
+			case data := <-l.out:
+				l.conn.SetWriteDeadline(time.Now().Add(c.nodeTimeout))
+				if _, err := l.conn.Write([]byte(data)); err != nil {
+					l.close()
+					return
+				}
+			case <-l.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		reader := resp.NewReader(l.conn)
+		for {
+			fields, _, err := reader.ReadCommand()
+			var msg *message
+			if err == nil {
+				msg, err = decodeMessage(fields)
+			}
+			if err != nil {
+				l.close()
+				c.events <- Event{kind: eventClosed, link: l, err: err}
+				return
+			}
+			c.events <- Event{kind: eventMessage, link: l, msg: msg}
+		}
+	}()
+}
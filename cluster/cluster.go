@@ -0,0 +1,540 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States of the cluster as seen by a node
+const (
+	StateOk   = "ok"
+	StateFail = "fail"
+)
+
+var (
+	ErrSlotUnbound = errors.New("CLUSTERDOWN Hash slot not served")
+	ErrDown        = errors.New("CLUSTERDOWN The cluster is down")
+	ErrTryAgain    = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+)
+
+/*
+* A command for keys this node does not serve: MOVED when the slot belongs to another node,
+* ASK when the keys are being migrated to another node and the client should only ask it for
+* this command
+ */
+type Redirect struct {
+	Ask  bool
+	Slot int
+	Node *Node
+}
+
+func (r *Redirect) Error() string {
+	kind := "MOVED"
+	if r.Ask {
+		kind = "ASK"
+	}
+	return fmt.Sprintf("%s %d %s", kind, r.Slot, r.Node.Addr())
+}
+
+// Default cluster-node-timeout
+const DefaultNodeTimeout = 15 * time.Second
+
+// What the cluster needs from the server this node runs
+type Host interface {
+	ReplicationOffset() int64
+	// Makes the server replicate the master, or a master again when it is nil
+	SetMaster(master *Node)
+	// Drops the keys of a slot another node took over
+	DeleteKeysInSlot(slot int)
+	CountKeysInSlot(slot int) int
+}
+
+// Host of a cluster that is not attached to a server
+type noHost struct{}
+
+func (noHost) ReplicationOffset() int64 { return 0 }
+func (noHost) SetMaster(*Node)          {}
+func (noHost) DeleteKeysInSlot(int)     {}
+func (noHost) CountKeysInSlot(int) int  { return 0 }
+
+/*
+* The cluster as seen by this node: the nodes it knows and which of them serves every hash slot.
+* Only touched by the event loop of the server
+ */
+type Cluster struct {
+	myself *Node
+	nodes  map[string]*Node
+	slots  [SlotCount]*Node
+	// Slots this node is moving to another node, and slots it is receiving from another node
+	migrating [SlotCount]*Node
+	importing [SlotCount]*Node
+	state     string
+	// Highest config epoch seen in the cluster
+	currentEpoch uint64
+	// Epoch this master last voted in
+	lastVoteEpoch uint64
+
+	host        Host
+	nodeTimeout time.Duration
+	// nodes.conf, empty for a configuration that is not persisted
+	configFile string
+	todoSave   bool
+
+	// Election of this replica after its master failed: when it starts, whether the votes were
+	// requested, for which epoch, and the masters that voted
+	failoverAuthTime  time.Time
+	failoverAuthSent  bool
+	failoverAuthEpoch uint64
+	failoverAuthVotes map[string]bool
+
+	events   chan Event
+	sent     int
+	received int
+}
+
+// Creates a cluster made of this node alone, a master without slots
+func New(port int) *Cluster {
+	myself := newNode(newNodeID(), "", port, port+BusPortOffset, flagMyself|flagMaster)
+	c := &Cluster{
+		myself:            myself,
+		nodes:             map[string]*Node{myself.ID: myself},
+		host:              noHost{},
+		nodeTimeout:       DefaultNodeTimeout,
+		failoverAuthVotes: map[string]bool{},
+		events:            make(chan Event),
+	}
+	c.updateState()
+	return c
+}
+
+func (c *Cluster) SetHost(host Host) {
+	c.host = host
+}
+
+// Time a node may not answer before it is considered failing
+func (c *Cluster) SetNodeTimeout(timeout time.Duration) {
+	c.nodeTimeout = timeout
+}
+
+func (c *Cluster) Myself() *Node {
+	return c.myself
+}
+
+func (c *Cluster) State() string {
+	return c.state
+}
+
+func (c *Cluster) CurrentEpoch() uint64 {
+	return c.currentEpoch
+}
+
+// Known nodes ordered by id, so replies list them in a stable order
+func (c *Cluster) Nodes() []*Node {
+	nodes := []*Node{}
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	slices.SortFunc(nodes, func(a *Node, b *Node) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return nodes
+}
+
+// Replicas of a master, ordered by id
+func (c *Cluster) Replicas(master *Node) []*Node {
+	replicas := []*Node{}
+	for _, n := range c.Nodes() {
+		if n.master == master {
+			replicas = append(replicas, n)
+		}
+	}
+	return replicas
+}
+
+// The node with the id, nil if it is unknown
+func (c *Cluster) Node(id string) *Node {
+	return c.nodes[id]
+}
+
+// Number of masters that currently report the node as failing
+func (c *Cluster) FailureReports(n *Node) int {
+	reports := 0
+	for _, reported := range n.failReports {
+		if time.Since(reported) <= 2*c.nodeTimeout {
+			reports++
+		}
+	}
+	return reports
+}
+
+func (c *Cluster) SlotOwner(slot int) *Node {
+	return c.slots[slot]
+}
+
+// Parses a slot number as given to the CLUSTER commands
+func ParseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+/*
+* Assigns the slots to this node. Nothing is assigned if one of them already has an owner or is
+* given twice
+ */
+func (c *Cluster) AddSlots(slots []int) error {
+	seen := map[int]bool{}
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return fmt.Errorf("Slot %d is already busy", slot)
+		}
+		if seen[slot] {
+			return fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+	}
+	c.updateState()
+	c.todoSave = true
+	return nil
+}
+
+// Unassigns the slots, whatever node they belong to
+func (c *Cluster) DelSlots(slots []int) error {
+	seen := map[int]bool{}
+	for _, slot := range slots {
+		if c.slots[slot] == nil {
+			return fmt.Errorf("Slot %d is already unassigned", slot)
+		}
+		if seen[slot] {
+			return fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+
+	for _, slot := range slots {
+		c.slots[slot] = nil
+	}
+	c.updateState()
+	c.todoSave = true
+	return nil
+}
+
+/*
+* Adds the node at ip:port to the cluster. It is known under a random id in the handshake state
+* until it replies over the cluster bus with its real id
+ */
+func (c *Cluster) Meet(ip string, port int, busPort int) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || port <= 0 || port > 65535 || busPort <= 0 || busPort > 65535 {
+		return fmt.Errorf("Invalid node address specified: %s:%d", ip, port)
+	}
+	ip = parsed.String()
+
+	for _, n := range c.nodes {
+		if n.flags&flagHandshake != 0 && n.IP == ip && n.Port == port && n.BusPort == busPort {
+			return nil
+		}
+	}
+	n := newNode(newNodeID(), ip, port, busPort, flagHandshake|flagMeet)
+	c.nodes[n.ID] = n
+	return nil
+}
+
+/*
+* Makes this node a replica of a master. A master can only become a replica while it serves no
+* slots
+ */
+func (c *Cluster) Replicate(id string) error {
+	master := c.nodes[id]
+	switch {
+	case master == nil:
+		return fmt.Errorf("Unknown node %s", id)
+	case master == c.myself:
+		return fmt.Errorf("Can't replicate myself")
+	case !master.IsMaster():
+		return fmt.Errorf("I can only replicate a master, not a replica.")
+	case c.myself.IsMaster() && len(c.SlotRanges(c.myself)) > 0:
+		return fmt.Errorf("To set a master the node must be empty and without assigned slots.")
+	}
+	if c.myself.master != master {
+		c.setMyMaster(master)
+	}
+	return nil
+}
+
+// A command to route: the slot of its keys and how the client sent it
+type Query struct {
+	Keys     []string
+	Slot     int
+	Write    bool
+	ReadOnly bool
+	// The client sent ASKING before the command
+	Asking bool
+	// MIGRATE, which moves the keys of a slot being migrated
+	Migrate bool
+	// Whether this node has the key, only asked while the slot is being migrated
+	Exists func(key string) bool
+}
+
+/*
+* Tells whether this node serves the command. While a slot is migrated its keys are served by
+* the node they are on: the node migrating it redirects the keys it no longer has with ASK, and
+* the node importing it serves them to clients that sent ASKING. Reads from a replica are served
+* locally when the client asked for it with READONLY and the replica follows the slot's master
+ */
+func (c *Cluster) Route(q Query) error {
+	owner := c.slots[q.Slot]
+	if owner == nil {
+		return ErrSlotUnbound
+	}
+	if c.state != StateOk {
+		return ErrDown
+	}
+
+	migrating := owner == c.myself && c.migrating[q.Slot] != nil
+	importing := c.importing[q.Slot] != nil
+	if (migrating || importing) && q.Migrate {
+		return nil
+	}
+	missing := 0
+	if migrating || importing {
+		for _, key := range q.Keys {
+			if !q.Exists(key) {
+				missing++
+			}
+		}
+	}
+	if migrating && missing > 0 {
+		if missing < len(q.Keys) {
+			return ErrTryAgain
+		}
+		return &Redirect{Ask: true, Slot: q.Slot, Node: c.migrating[q.Slot]}
+	}
+	if importing && q.Asking {
+		if len(q.Keys) > 1 && missing > 0 {
+			return ErrTryAgain
+		}
+		return nil
+	}
+
+	if owner == c.myself {
+		return nil
+	}
+	if !q.Write && q.ReadOnly && c.myself.master == owner {
+		return nil
+	}
+	return &Redirect{Slot: q.Slot, Node: owner}
+}
+
+/*
+* The cluster is ok once every slot is served by a node that is not failing and this node reaches
+* the majority of the masters, a node in the minority of a partition stops serving
+ */
+func (c *Cluster) updateState() {
+	state := StateOk
+	for _, owner := range c.slots {
+		if owner == nil || owner.flags&flagFail != 0 {
+			state = StateFail
+			break
+		}
+	}
+
+	reachable := 0
+	for _, n := range c.nodes {
+		if n.IsMaster() && len(c.SlotRanges(n)) > 0 && !n.Failing() {
+			reachable++
+		}
+	}
+	if reachable < c.quorum() {
+		state = StateFail
+	}
+
+	if state != c.state && c.state != "" {
+		slog.Info("Cluster state changed", "state", state)
+	}
+	c.state = state
+}
+
+// Ranges of consecutive slots served by the node, as [first, last] pairs
+func (c *Cluster) SlotRanges(n *Node) [][2]int {
+	ranges := [][2]int{}
+	for slot := 0; slot < SlotCount; slot++ {
+		if c.slots[slot] != n {
+			continue
+		}
+		if len(ranges) > 0 && ranges[len(ranges)-1][1] == slot-1 {
+			ranges[len(ranges)-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// CLUSTER NODES: one line per known node
+func (c *Cluster) Describe() string {
+	description := ""
+	for _, n := range c.Nodes() {
+		description += c.DescribeNode(n) + "\n"
+	}
+	return description
+}
+
+/*
+* The line of CLUSTER NODES describing the node. The line of this node also lists the slots it is
+* migrating, [slot->-id], and importing, [slot-<-id]
+ */
+func (c *Cluster) DescribeNode(n *Node) string {
+	line := n.describe(c.SlotRanges(n))
+	if n != c.myself {
+		return line
+	}
+	for slot := 0; slot < SlotCount; slot++ {
+		if c.migrating[slot] != nil {
+			line += fmt.Sprintf(" [%d->-%s]", slot, c.migrating[slot].ID)
+		}
+		if c.importing[slot] != nil {
+			line += fmt.Sprintf(" [%d-<-%s]", slot, c.importing[slot].ID)
+		}
+	}
+	return line
+}
+
+// Fields of CLUSTER INFO
+func (c *Cluster) Info() [][2]string {
+	assigned, pfail, fail := 0, 0, 0
+	for _, owner := range c.slots {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if owner.flags&flagFail != 0 {
+			fail++
+		} else if owner.flags&flagPFail != 0 {
+			pfail++
+		}
+	}
+
+	myEpoch := c.myself.ConfigEpoch
+	if c.myself.master != nil {
+		myEpoch = c.myself.master.ConfigEpoch
+	}
+	return [][2]string{
+		{"cluster_state", c.state},
+		{"cluster_slots_assigned", strconv.Itoa(assigned)},
+		{"cluster_slots_ok", strconv.Itoa(assigned - pfail - fail)},
+		{"cluster_slots_pfail", strconv.Itoa(pfail)},
+		{"cluster_slots_fail", strconv.Itoa(fail)},
+		{"cluster_known_nodes", strconv.Itoa(len(c.nodes))},
+		{"cluster_size", strconv.Itoa(c.size())},
+		{"cluster_current_epoch", strconv.FormatUint(c.currentEpoch, 10)},
+		{"cluster_my_epoch", strconv.FormatUint(myEpoch, 10)},
+		{"cluster_stats_messages_sent", strconv.Itoa(c.sent)},
+		{"cluster_stats_messages_received", strconv.Itoa(c.received)},
+	}
+}
+
+/*
+* CLUSTER SETSLOT: moving a slot from a node to another. The source marks the slot MIGRATING to
+* the destination and the destination marks it IMPORTING from the source, then the keys are moved
+* with MIGRATE and both are told the new owner with NODE. STABLE cancels a migration
+ */
+func (c *Cluster) SetSlot(slot int, state string, id string) error {
+	if c.myself.IsReplica() {
+		return fmt.Errorf("Please use SETSLOT only with masters.")
+	}
+	if state == "STABLE" {
+		c.migrating[slot], c.importing[slot] = nil, nil
+		c.todoSave = true
+		return nil
+	}
+
+	n := c.nodes[id]
+	switch state {
+	case "MIGRATING":
+		if c.slots[slot] != c.myself {
+			return fmt.Errorf("I'm not the owner of hash slot %d", slot)
+		}
+		if n == nil {
+			return fmt.Errorf("I don't know about node %s", id)
+		}
+		if !n.IsMaster() {
+			return fmt.Errorf("Target node is not a master")
+		}
+		c.migrating[slot] = n
+	case "IMPORTING":
+		if c.slots[slot] == c.myself {
+			return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+		}
+		if n == nil {
+			return fmt.Errorf("I don't know about node %s", id)
+		}
+		if !n.IsMaster() {
+			return fmt.Errorf("Target node is not a master")
+		}
+		c.importing[slot] = n
+	default:
+		return c.assignSlot(slot, n, id)
+	}
+	c.todoSave = true
+	return nil
+}
+
+/*
+* SETSLOT NODE: the slot is served by the node from now on. The node importing it claims it under
+* a new config epoch, so the rest of the cluster picks the new owner over the old one without an
+* election
+ */
+func (c *Cluster) assignSlot(slot int, n *Node, id string) error {
+	if n == nil {
+		return fmt.Errorf("Unknown node %s", id)
+	}
+	if n.IsReplica() {
+		return fmt.Errorf("Can't assign hashslot %d to a replica", slot)
+	}
+	if c.slots[slot] == c.myself && n != c.myself && c.host.CountKeysInSlot(slot) > 0 {
+		return fmt.Errorf("Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+	}
+
+	if c.migrating[slot] != nil && c.host.CountKeysInSlot(slot) == 0 {
+		c.migrating[slot] = nil
+	}
+	c.slots[slot] = n
+	if n == c.myself && c.importing[slot] != nil {
+		c.importing[slot] = nil
+		c.bumpEpochWithoutConsensus()
+		for _, other := range c.nodes {
+			if other != c.myself && other.flags&flagHandshake == 0 && other.link != nil && other.link.conn != nil {
+				c.sendPing(other.link, msgPong)
+			}
+		}
+	}
+	c.updateState()
+	c.todoSave = true
+	return nil
+}
+
+// Moves this master to a new epoch unless it already has the greatest one
+func (c *Cluster) bumpEpochWithoutConsensus() {
+	maxEpoch := c.currentEpoch
+	for _, n := range c.nodes {
+		maxEpoch = max(maxEpoch, n.ConfigEpoch)
+	}
+	if c.myself.ConfigEpoch == 0 || c.myself.ConfigEpoch != maxEpoch {
+		c.currentEpoch = maxEpoch + 1
+		c.myself.ConfigEpoch = c.currentEpoch
+		slog.Info("New configEpoch set after importing a slot", "epoch", c.myself.ConfigEpoch)
+	}
+}
@@ -0,0 +1,208 @@
+package cluster
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+func allSlots() []int {
+	slots := []int{}
+	for slot := 0; slot < SlotCount; slot++ {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+func TestAddSlots(t *testing.T) {
+	c := New(7000)
+	if c.State() != StateFail {
+		t.Errorf("Expected a cluster without slots to be down, got %s", c.State())
+	}
+
+	if err := c.AddSlots([]int{1, 2, 1}); err == nil || err.Error() != "Slot 1 specified multiple times" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if c.SlotOwner(2) != nil {
+		t.Errorf("Expected no slot to be assigned after an error")
+	}
+
+	if err := c.AddSlots(allSlots()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.State() != StateOk {
+		t.Errorf("Expected the cluster to be ok once every slot is served, got %s", c.State())
+	}
+	if err := c.AddSlots([]int{5}); err == nil || err.Error() != "Slot 5 is already busy" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := c.DelSlots([]int{5, 6, 7, 100}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ranges := c.SlotRanges(c.Myself())
+	expected := [][2]int{{0, 4}, {8, 99}, {101, SlotCount - 1}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Unexpected ranges: %v", ranges)
+	}
+	for i := range ranges {
+		if ranges[i] != expected[i] {
+			t.Errorf("Unexpected ranges: %v", ranges)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	c := New(7000)
+	other := newNode(newNodeID(), "127.0.0.1", 7001, 17001, flagMaster)
+	c.nodes[other.ID] = other
+
+	if err := c.Route(Query{Slot: 10}); !errors.Is(err, ErrSlotUnbound) {
+		t.Errorf("Expected an unbound slot, got %v", err)
+	}
+
+	c.AddSlots(allSlots()[:100])
+	for slot := 100; slot < SlotCount; slot++ {
+		c.slots[slot] = other
+	}
+	c.updateState()
+
+	if err := c.Route(Query{Slot: 10, Write: true}); err != nil {
+		t.Errorf("Expected the slot to be served locally, got %v", err)
+	}
+	err := c.Route(Query{Slot: 3999, Write: true})
+	if err == nil || err.Error() != "MOVED 3999 127.0.0.1:7001" {
+		t.Errorf("Expected a MOVED redirection, got %v", err)
+	}
+
+	// Keys of a slot being migrated are served by the node that has them
+	c.migrating[10], c.importing[3999] = other, other
+	exists := func(key string) bool { return key == "here" }
+	if err := c.Route(Query{Keys: []string{"here"}, Slot: 10, Exists: exists}); err != nil {
+		t.Errorf("Expected the key to be served locally, got %v", err)
+	}
+	err = c.Route(Query{Keys: []string{"gone"}, Slot: 10, Exists: exists})
+	if err == nil || err.Error() != "ASK 10 127.0.0.1:7001" {
+		t.Errorf("Expected an ASK redirection, got %v", err)
+	}
+	if err := c.Route(Query{Keys: []string{"here", "gone"}, Slot: 10, Exists: exists}); !errors.Is(err, ErrTryAgain) {
+		t.Errorf("Expected TRYAGAIN, got %v", err)
+	}
+	if err := c.Route(Query{Keys: []string{"gone"}, Slot: 3999, Asking: true, Exists: exists}); err != nil {
+		t.Errorf("Expected the importing slot to be served after ASKING, got %v", err)
+	}
+	if err := c.Route(Query{Keys: []string{"gone"}, Slot: 3999, Exists: exists}); err == nil {
+		t.Errorf("Expected the importing slot to be redirected without ASKING")
+	}
+	c.migrating[10], c.importing[3999] = nil, nil
+
+	// A replica serves reads of its master to READONLY clients
+	c.myself.flags, c.myself.master = flagMyself|flagReplica, other
+	if err := c.Route(Query{Slot: 3999, ReadOnly: true}); err != nil {
+		t.Errorf("Expected the read to be served by the replica, got %v", err)
+	}
+	if err := c.Route(Query{Slot: 3999, Write: true, ReadOnly: true}); err == nil {
+		t.Errorf("Expected the write to be redirected")
+	}
+
+	other.flags |= flagFail
+	c.updateState()
+	if err := c.Route(Query{Slot: 10}); !errors.Is(err, ErrDown) {
+		t.Errorf("Expected the cluster to be down, got %v", err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	c := New(7000)
+	c.AddSlots([]int{0, 1, 2, 10})
+	c.Meet("127.0.0.1", 7001, 17001)
+
+	lines := strings.Split(strings.TrimSuffix(c.Describe(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 nodes, got %q", lines)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if fields[0] == c.Myself().ID {
+			if strings.Join(fields[1:], " ") != ":7000@17000 myself,master - 0 0 0 connected 0-2 10" {
+				t.Errorf("Unexpected description of myself: %s", line)
+			}
+		} else if strings.Join(fields[1:], " ") != "127.0.0.1:7001@17001 handshake - 0 0 0 disconnected" {
+			t.Errorf("Unexpected description of the met node: %s", line)
+		}
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	c := New(7000)
+	c.AddSlots([]int{0, 5, 16383})
+	c.Meet("127.0.0.1", 7001, 17001)
+	other := newNode(newNodeID(), "127.0.0.1", 7002, 17002, flagMaster|flagPFail)
+	c.nodes[other.ID] = other
+
+	m := c.header(msgPing)
+	m.gossip = []gossip{{id: other.ID, ip: other.IP, port: other.Port, busPort: other.BusPort, flags: other.flags, pongReceived: 42}}
+	fields, _, err := resp.NewReader(strings.NewReader(m.encode())).ReadCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	decoded, err := decodeMessage(fields)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if decoded.sender != c.myself.ID || decoded.port != 7000 || decoded.masterID != "-" || decoded.flags != flagMaster {
+		t.Errorf("Unexpected header: %+v", decoded)
+	}
+	for _, slot := range []int{0, 5, 16383} {
+		if !hasSlot(decoded.slots, slot) {
+			t.Errorf("Expected slot %d to be claimed", slot)
+		}
+	}
+	if hasSlot(decoded.slots, 1) {
+		t.Errorf("Expected slot 1 not to be claimed")
+	}
+	if len(decoded.gossip) != 1 || decoded.gossip[0] != m.gossip[0] {
+		t.Errorf("Unexpected gossip: %+v", decoded.gossip)
+	}
+
+	if _, err := decodeMessage(fields[:len(fields)-1]); err == nil {
+		t.Errorf("Expected a truncated message to be rejected")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nodes.conf")
+	c, err := Open(file, 7000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	master := newNode(newNodeID(), "127.0.0.1", 7001, 17001, flagMaster)
+	master.ConfigEpoch = 3
+	c.nodes[master.ID] = master
+	for slot := 100; slot < SlotCount; slot++ {
+		c.slots[slot] = master
+	}
+	c.currentEpoch, c.lastVoteEpoch = 4, 3
+	if err := c.Replicate(master.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.saveIfNeeded()
+
+	loaded, err := Open(file, 7000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if loaded.Myself().ID != c.Myself().ID || loaded.Myself().Master().ID != master.ID {
+		t.Errorf("Unexpected myself: %s", loaded.DescribeNode(loaded.Myself()))
+	}
+	if loaded.CurrentEpoch() != 4 || loaded.lastVoteEpoch != 3 {
+		t.Errorf("Unexpected epochs: %d %d", loaded.CurrentEpoch(), loaded.lastVoteEpoch)
+	}
+	owner := loaded.SlotOwner(100)
+	if owner == nil || owner.ID != master.ID || owner.ConfigEpoch != 3 || loaded.SlotOwner(99) != nil {
+		t.Errorf("Unexpected slots: %v", loaded.SlotRanges(owner))
+	}
+}
@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+* Opens the cluster configuration of this node from its nodes.conf, or creates the file for a new
+* node alone in its cluster. The file is rewritten whenever the configuration changes, so a node
+* that restarts rejoins its cluster with the same id, slots and epochs
+ */
+func Open(configFile string, port int) (*Cluster, error) {
+	c := New(port)
+	c.configFile = configFile
+
+	data, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return c, c.saveConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.loadConfig(string(data), port); err != nil {
+		return nil, fmt.Errorf("%s: %w", configFile, err)
+	}
+	slog.Info("Loaded the cluster configuration", "file", configFile, "myself", c.myself.ID, "nodes", len(c.nodes))
+	return c, nil
+}
+
+// Parses the content of nodes.conf, as written by saveConfig
+func (c *Cluster) loadConfig(data string, port int) error {
+	c.nodes = map[string]*Node{}
+	c.myself = nil
+	masters := map[*Node]string{}
+	// Slots being migrated to or imported from a node, by node id
+	migrating, importing := map[int]string{}, map[int]string{}
+
+	for i, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for j := 1; j+1 < len(fields); j += 2 {
+				epoch, err := strconv.ParseUint(fields[j+1], 10, 64)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid %s", i+1, fields[j])
+				}
+				switch fields[j] {
+				case "currentEpoch":
+					c.currentEpoch = epoch
+				case "lastVoteEpoch":
+					c.lastVoteEpoch = epoch
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return fmt.Errorf("line %d: expected at least 8 fields", i+1)
+		}
+
+		ip, nodePort, busPort, err := parseNodeAddr(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		configEpoch, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid config epoch %q", i+1, fields[6])
+		}
+		n := newNode(fields[0], ip, nodePort, busPort, parseFlags(fields[2]))
+		n.ConfigEpoch = configEpoch
+		if n.flags&flagFail != 0 {
+			n.failTime = time.Now()
+		}
+		if n.IsMyself() {
+			// The node may have been restarted on another port
+			n.Port, n.BusPort = port, port+BusPortOffset
+			c.myself = n
+		}
+		c.nodes[n.ID] = n
+		if fields[3] != "-" {
+			masters[n] = fields[3]
+		}
+
+		for _, r := range fields[8:] {
+			if strings.HasPrefix(r, "[") {
+				slotField, id, found := strings.Cut(strings.Trim(r, "[]"), "->-")
+				states := migrating
+				if !found {
+					slotField, id, found = strings.Cut(strings.Trim(r, "[]"), "-<-")
+					states = importing
+				}
+				slot, err := ParseSlot(slotField)
+				if !found || err != nil {
+					return fmt.Errorf("line %d: invalid migrating slot %q", i+1, r)
+				}
+				states[slot] = id
+				continue
+			}
+
+			first, last, found := strings.Cut(r, "-")
+			if !found {
+				last = first
+			}
+			start, err := ParseSlot(first)
+			end, endErr := ParseSlot(last)
+			if err != nil || endErr != nil || end < start {
+				return fmt.Errorf("line %d: invalid slot range %q", i+1, r)
+			}
+			for slot := start; slot <= end; slot++ {
+				c.slots[slot] = n
+			}
+		}
+	}
+	if c.myself == nil {
+		return fmt.Errorf("myself node not found")
+	}
+
+	for n, id := range masters {
+		master := c.nodes[id]
+		if master == nil {
+			master = newNode(id, "", 0, 0, flagMaster|flagNoAddr)
+			c.nodes[id] = master
+		}
+		n.master = master
+	}
+	for slot, id := range migrating {
+		c.migrating[slot] = c.nodes[id]
+	}
+	for slot, id := range importing {
+		c.importing[slot] = c.nodes[id]
+	}
+	c.updateState()
+	return nil
+}
+
+// Parses an address of nodes.conf, ip:port@cport
+func parseNodeAddr(addr string) (string, int, int, error) {
+	hostPort, busPortField, _ := strings.Cut(addr, "@")
+	sep := strings.LastIndex(hostPort, ":")
+	if sep < 0 {
+		return "", 0, 0, fmt.Errorf("invalid address %q", addr)
+	}
+	port, err := strconv.Atoi(hostPort[sep+1:])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid address %q", addr)
+	}
+	busPort := port + BusPortOffset
+	if busPortField != "" {
+		if busPort, err = strconv.Atoi(busPortField); err != nil {
+			return "", 0, 0, fmt.Errorf("invalid address %q", addr)
+		}
+	}
+	return hostPort[:sep], port, busPort, nil
+}
+
+/*
+* Writes nodes.conf: every node as CLUSTER NODES lists it, nodes still in the handshake aside,
+* then the epochs of this node. The file is replaced at once so a crash never leaves half of it
+ */
+func (c *Cluster) saveConfig() error {
+	if c.configFile == "" {
+		return nil
+	}
+
+	content := ""
+	for _, n := range c.Nodes() {
+		if n.flags&flagHandshake != 0 {
+			continue
+		}
+		content += c.DescribeNode(n) + "\n"
+	}
+	content += fmt.Sprintf("vars currentEpoch %d lastVoteEpoch %d\n", c.currentEpoch, c.lastVoteEpoch)
+
+	tmp := c.configFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.configFile)
+}
+
+// Saves the configuration if something changed it since it was last saved
+func (c *Cluster) saveIfNeeded() {
+	if !c.todoSave {
+		return
+	}
+	c.todoSave = false
+	if err := c.saveConfig(); err != nil {
+		slog.Error("Could not save the cluster configuration", "file", c.configFile, "err", err)
+	}
+}
@@ -0,0 +1,147 @@
+package cluster
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Number of masters serving slots, the size of the cluster
+func (c *Cluster) size() int {
+	size := 0
+	for _, n := range c.nodes {
+		if n.IsMaster() && len(c.SlotRanges(n)) > 0 {
+			size++
+		}
+	}
+	return size
+}
+
+// Masters that have to agree to mark a node FAIL or to elect a replica
+func (c *Cluster) quorum() int {
+	return c.size()/2 + 1
+}
+
+// Time a replica waits for the votes of an election, elections are at least twice that apart
+func (c *Cluster) authTimeout() time.Duration {
+	return max(2*c.nodeTimeout, 2*time.Second)
+}
+
+/*
+* Rank of this replica among the replicas of its master: the number of them with a greater
+* replication offset. The best replica starts its election first
+ */
+func (c *Cluster) replicaRank() int {
+	offset := c.host.ReplicationOffset()
+	rank := 0
+	for _, n := range c.nodes {
+		if n != c.myself && n.master == c.myself.master && n.replOffset > offset {
+			rank++
+		}
+	}
+	return rank
+}
+
+/*
+* A replica of a FAIL master serving slots asks the masters to elect it. The election starts after
+* a random delay, longer for replicas that received less data, so the best replica usually asks
+* first. The replica moves to a new epoch and asks for the votes of the masters, once the majority
+* of them voted for it, it takes over the slots of its master under that epoch
+ */
+func (c *Cluster) handleReplicaFailover() {
+	master := c.myself.master
+	if c.myself.IsMaster() || master == nil || master.flags&flagFail == 0 || len(c.SlotRanges(master)) == 0 {
+		return
+	}
+
+	now := time.Now()
+	timeout := c.authTimeout()
+	if now.Sub(c.failoverAuthTime) > 2*timeout {
+		rank := c.replicaRank()
+		delay := 500*time.Millisecond + time.Duration(rand.Int63n(int64(500*time.Millisecond))) + time.Duration(rank)*time.Second
+		c.failoverAuthTime = now.Add(delay)
+		c.failoverAuthSent = false
+		c.failoverAuthVotes = map[string]bool{}
+		slog.Info("Start of election delayed", "delay", delay, "rank", rank, "offset", c.host.ReplicationOffset())
+		return
+	}
+	// Waiting for the election to start, or it timed out and the next one starts later
+	if now.Before(c.failoverAuthTime) || now.Sub(c.failoverAuthTime) > timeout {
+		return
+	}
+
+	if !c.failoverAuthSent {
+		c.currentEpoch++
+		c.failoverAuthEpoch = c.currentEpoch
+		c.failoverAuthSent = true
+		c.todoSave = true
+		slog.Info("Starting a failover election", "epoch", c.currentEpoch)
+		c.broadcast(c.header(msgAuthRequest))
+		return
+	}
+
+	if len(c.failoverAuthVotes) >= c.quorum() {
+		c.promoteMyself()
+	}
+}
+
+// The replica won the election, it serves the slots of its master from now on
+func (c *Cluster) promoteMyself() {
+	old := c.myself.master
+	slog.Info("Failover election won, promoting myself to master", "epoch", c.failoverAuthEpoch)
+
+	c.setNodeAsMaster(c.myself)
+	for slot, owner := range c.slots {
+		if owner == old {
+			c.slots[slot] = c.myself
+		}
+	}
+	c.myself.ConfigEpoch = c.failoverAuthEpoch
+	c.host.SetMaster(nil)
+	c.updateState()
+
+	// Tell every node about the new configuration right away
+	for _, n := range c.nodes {
+		if n != c.myself && n.flags&flagHandshake == 0 && n.link != nil && n.link.conn != nil {
+			c.sendPing(n.link, msgPong)
+		}
+	}
+}
+
+/*
+* A master votes for a replica asking to fail over its master if the master is FAIL and every slot
+* the replica claims is not served under a newer epoch. It votes once per epoch, and once per
+* failed master in twice cluster-node-timeout
+ */
+func (c *Cluster) voteIfNeeded(n *Node, m *message) {
+	master := n.master
+	if c.myself.IsReplica() || len(c.SlotRanges(c.myself)) == 0 {
+		return
+	}
+	if m.currentEpoch < c.currentEpoch || c.lastVoteEpoch == c.currentEpoch {
+		return
+	}
+	if !n.IsReplica() || master == nil || master.flags&flagFail == 0 {
+		slog.Info("Failover auth denied, its master is not failing", "node", n.ID)
+		return
+	}
+	if time.Since(master.votedTime) < 2*c.nodeTimeout {
+		slog.Info("Failover auth denied, voted for a replica of this master recently", "node", n.ID)
+		return
+	}
+	for slot := 0; slot < SlotCount; slot++ {
+		owner := c.slots[slot]
+		if hasSlot(m.slots, slot) && owner != nil && owner.ConfigEpoch > m.configEpoch {
+			slog.Info("Failover auth denied, slot served under a newer epoch", "node", n.ID, "slot", slot)
+			return
+		}
+	}
+
+	c.lastVoteEpoch = c.currentEpoch
+	master.votedTime = time.Now()
+	c.todoSave = true
+	if n.link != nil && n.link.conn != nil {
+		c.sendMessage(n.link, c.header(msgAuthAck))
+	}
+	slog.Info("Failover auth granted", "node", n.ID, "epoch", c.currentEpoch)
+}
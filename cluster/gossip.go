@@ -0,0 +1,522 @@
+package cluster
+
+import (
+	"bytes"
+	"log/slog"
+	"time"
+)
+
+// Time between two PINGs to the same node
+const pingInterval = time.Second
+
+// Flags a node tells others about, the rest only make sense to the node itself
+const gossipedFlags = flagMaster | flagReplica | flagPFail | flagFail | flagNoAddr
+
+/*
+* Applies an event of the cluster bus: a link that connected or closed, or a message read from
+* a link
+ */
+func (c *Cluster) HandleEvent(ev Event) {
+	l := ev.link
+	switch ev.kind {
+	case eventConnected:
+		// The node was forgotten or its link dropped while connecting
+		if l.node.link != l {
+			ev.conn.Close()
+			return
+		}
+		l.conn = ev.conn
+		c.startLink(l)
+		if l.node.flags&flagMeet != 0 {
+			l.node.flags &^= flagMeet
+			c.sendPing(l, msgMeet)
+		} else {
+			c.sendPing(l, msgPing)
+		}
+	case eventClosed:
+		l.close()
+		if l.node != nil && l.node.link == l {
+			l.node.link = nil
+		}
+	case eventMessage:
+		c.received++
+		c.process(l, ev.msg)
+	}
+	c.saveIfNeeded()
+}
+
+/*
+* Runs every 100 milliseconds: connects to the nodes without a link, PINGs the nodes, and flags
+* the nodes that did not answer for cluster-node-timeout as possibly failing
+ */
+func (c *Cluster) Cron() {
+	now := time.Now()
+	handshakeTimeout := max(c.nodeTimeout, time.Second)
+
+	for _, n := range c.nodes {
+		if n == c.myself || n.flags&flagNoAddr != 0 {
+			continue
+		}
+		if n.flags&flagHandshake != 0 && now.Sub(n.created) > handshakeTimeout {
+			slog.Info("Handshake timed out", "node", n.ID, "addr", n.Addr())
+			c.deleteNode(n)
+			continue
+		}
+		if n.link == nil {
+			c.connect(n)
+			continue
+		}
+		if n.link.conn == nil {
+			continue
+		}
+
+		// A PONG that takes this long may be stuck on a broken connection, try a new one
+		if !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.nodeTimeout/2 && now.Sub(n.link.created) > c.nodeTimeout/2 {
+			n.link.close()
+			n.link = nil
+			continue
+		}
+		if n.pingSent.IsZero() && now.Sub(n.pongReceived) >= pingInterval {
+			c.sendPing(n.link, msgPing)
+		}
+	}
+
+	for _, n := range c.nodes {
+		if n == c.myself || n.flags&(flagHandshake|flagNoAddr) != 0 || n.Failing() {
+			continue
+		}
+		if !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.nodeTimeout {
+			slog.Warn("*** NODE possibly failing", "node", n.ID, "addr", n.Addr())
+			n.flags |= flagPFail
+		}
+	}
+
+	c.handleReplicaFailover()
+	c.updateState()
+	c.saveIfNeeded()
+}
+
+/*
+* The header of a message from this node. A replica announces the epoch and the slots of its
+* master
+ */
+func (c *Cluster) header(kind string) *message {
+	master, masterID := c.myself, "-"
+	if c.myself.master != nil {
+		master, masterID = c.myself.master, c.myself.master.ID
+	}
+	return &message{
+		kind:         kind,
+		sender:       c.myself.ID,
+		port:         c.myself.Port,
+		busPort:      c.myself.BusPort,
+		flags:        c.myself.flags & gossipedFlags,
+		masterID:     masterID,
+		currentEpoch: c.currentEpoch,
+		configEpoch:  master.ConfigEpoch,
+		offset:       c.host.ReplicationOffset(),
+		slots:        c.slotsBitmap(master),
+	}
+}
+
+func (c *Cluster) sendMessage(l *link, m *message) {
+	c.sent++
+	l.send(m)
+}
+
+// Sends the message to every node this node is connected to
+func (c *Cluster) broadcast(m *message) {
+	for _, n := range c.nodes {
+		if n == c.myself || n.flags&flagHandshake != 0 || n.link == nil || n.link.conn == nil {
+			continue
+		}
+		c.sendMessage(n.link, m)
+	}
+}
+
+// Sends a PING, PONG or MEET, with what this node knows about the other nodes
+func (c *Cluster) sendPing(l *link, kind string) {
+	if kind != msgPong && l.node.pingSent.IsZero() {
+		l.node.pingSent = time.Now()
+	}
+
+	m := c.header(kind)
+	for _, n := range c.nodes {
+		if n == c.myself || n == l.node || n.flags&(flagHandshake|flagNoAddr) != 0 {
+			continue
+		}
+		m.gossip = append(m.gossip, gossip{
+			id:           n.ID,
+			ip:           n.IP,
+			port:         n.Port,
+			busPort:      n.BusPort,
+			flags:        n.flags & gossipedFlags,
+			pingSent:     unixMilli(n.pingSent),
+			pongReceived: unixMilli(n.pongReceived),
+		})
+	}
+	c.sendMessage(l, m)
+}
+
+// Applies a message read from a link
+func (c *Cluster) process(l *link, m *message) {
+	sender := c.nodes[m.sender]
+	if sender != nil && sender.flags&flagHandshake != 0 {
+		sender = nil
+	}
+	if sender != nil {
+		if m.currentEpoch > c.currentEpoch {
+			c.currentEpoch = m.currentEpoch
+			c.todoSave = true
+		}
+		if m.configEpoch > sender.ConfigEpoch {
+			sender.ConfigEpoch = m.configEpoch
+			c.todoSave = true
+		}
+		sender.replOffset = m.offset
+	}
+
+	switch m.kind {
+	case msgPing, msgMeet, msgPong:
+		c.processPing(l, sender, m)
+	case msgFail:
+		failing := c.nodes[m.target]
+		if sender == nil || failing == nil || failing == c.myself || failing.flags&flagFail != 0 {
+			return
+		}
+		slog.Info("FAIL message received", "from", sender.ID, "about", failing.ID)
+		failing.flags = failing.flags&^flagPFail | flagFail
+		failing.failTime = time.Now()
+		c.updateState()
+		c.todoSave = true
+	case msgUpdate:
+		n := c.nodes[m.target]
+		if sender == nil || n == nil || n.ConfigEpoch >= m.targetEpoch {
+			return
+		}
+		if n.IsReplica() {
+			c.setNodeAsMaster(n)
+		}
+		n.ConfigEpoch = m.targetEpoch
+		c.updateSlotsWith(n, m.targetEpoch, m.targetSlots)
+	case msgAuthRequest:
+		if sender != nil {
+			c.voteIfNeeded(sender, m)
+		}
+	case msgAuthAck:
+		if sender != nil && sender.IsMaster() && len(c.SlotRanges(sender)) > 0 && m.currentEpoch >= c.failoverAuthEpoch {
+			c.failoverAuthVotes[sender.ID] = true
+		}
+	}
+}
+
+/*
+* PING, PONG and MEET. A PONG on an outbound link completes the handshake with the node, and
+* every one of them updates the role, the slots and the view of the other nodes of a known sender
+ */
+func (c *Cluster) processPing(l *link, sender *Node, m *message) {
+	if m.kind != msgPong {
+		// Other nodes reach this node at the address a MEET came to
+		if (m.kind == msgMeet || c.myself.IP == "") && l.localIP() != c.myself.IP {
+			c.myself.IP = l.localIP()
+			slog.Info("IP address for this node updated", "ip", c.myself.IP)
+			c.todoSave = true
+		}
+		if sender == nil && m.kind == msgMeet {
+			n := newNode(newNodeID(), l.remoteIP(), m.port, m.busPort, flagHandshake)
+			c.nodes[n.ID] = n
+		}
+		if sender != nil {
+			c.updateAddress(sender, l.remoteIP(), m.port, m.busPort)
+		}
+		c.sendPing(l, msgPong)
+	}
+
+	if l.node != nil && l.node.flags&flagHandshake != 0 {
+		if sender != nil {
+			// Met twice, the node is already known under its real id
+			c.updateAddress(sender, l.node.IP, m.port, m.busPort)
+			c.deleteNode(l.node)
+			return
+		}
+		slog.Info("Handshake completed", "node", m.sender, "addr", l.node.Addr())
+		c.renameNode(l.node, m.sender)
+		l.node.flags = l.node.flags&^flagHandshake | m.flags&(flagMaster|flagReplica)
+		sender = l.node
+		c.todoSave = true
+	} else if l.node != nil && l.node.ID != m.sender {
+		// Another node answers at this address, the one known here is gone from it
+		slog.Info("Node answered with another id, forgetting its address", "node", l.node.ID, "id", m.sender)
+		l.node.flags |= flagNoAddr
+		l.node.IP = ""
+		l.close()
+		l.node.link = nil
+		c.todoSave = true
+		return
+	}
+
+	if l.node != nil && m.kind == msgPong {
+		l.node.pongReceived = time.Now()
+		l.node.pingSent = time.Time{}
+		if l.node.flags&flagPFail != 0 {
+			l.node.flags &^= flagPFail
+		} else if l.node.flags&flagFail != 0 {
+			c.clearFailureIfNeeded(l.node)
+		}
+	}
+
+	if sender == nil {
+		return
+	}
+
+	if m.masterID == "-" {
+		if sender.IsReplica() {
+			c.setNodeAsMaster(sender)
+		}
+	} else if master := c.nodes[m.masterID]; master != nil && sender.master != master {
+		if sender.IsMaster() {
+			for slot, owner := range c.slots {
+				if owner == sender {
+					c.slots[slot] = nil
+				}
+			}
+		}
+		sender.flags = sender.flags&^flagMaster | flagReplica
+		sender.master = master
+		c.todoSave = true
+	}
+
+	if sender.IsMaster() {
+		if !bytes.Equal(m.slots, c.slotsBitmap(sender)) {
+			c.updateSlotsWith(sender, m.configEpoch, m.slots)
+		}
+
+		// The sender claims slots that were taken over since, tell it who serves them now
+		for slot := 0; slot < SlotCount; slot++ {
+			owner := c.slots[slot]
+			if !hasSlot(m.slots, slot) || owner == nil || owner == sender || owner.ConfigEpoch <= m.configEpoch {
+				continue
+			}
+			if sender.link != nil && sender.link.conn != nil {
+				update := c.header(msgUpdate)
+				update.target, update.targetEpoch, update.targetSlots = owner.ID, owner.ConfigEpoch, c.slotsBitmap(owner)
+				c.sendMessage(sender.link, update)
+			}
+			break
+		}
+
+		if c.myself.IsMaster() && m.configEpoch == c.myself.ConfigEpoch {
+			c.handleConfigEpochCollision(sender)
+		}
+	}
+
+	c.processGossip(sender, m)
+}
+
+/*
+* What the sender knows about other nodes: masters report the nodes they can not reach, and
+* nodes this node does not know yet are greeted
+ */
+func (c *Cluster) processGossip(sender *Node, m *message) {
+	for _, g := range m.gossip {
+		n := c.nodes[g.id]
+		if n == nil {
+			if g.flags&flagNoAddr == 0 && g.ip != "" {
+				c.Meet(g.ip, g.port, g.busPort)
+			}
+			continue
+		}
+		if n == c.myself {
+			continue
+		}
+
+		if sender.IsMaster() {
+			if g.flags&(flagPFail|flagFail) != 0 {
+				n.failReports[sender.ID] = time.Now()
+				c.markFailingIfNeeded(n)
+			} else {
+				delete(n.failReports, sender.ID)
+			}
+		}
+
+		// A node this node lost may have moved, the others know where
+		if n.Failing() && g.flags&(flagPFail|flagFail) == 0 && g.ip != "" {
+			c.updateAddress(n, g.ip, g.port, g.busPort)
+		}
+	}
+}
+
+/*
+* Marks the node FAIL once the majority of the masters serving slots reports it failing, this
+* node included, and tells every other node
+ */
+func (c *Cluster) markFailingIfNeeded(n *Node) {
+	if n.flags&flagPFail == 0 || n.flags&flagFail != 0 {
+		return
+	}
+
+	reports := 0
+	for id, reported := range n.failReports {
+		if time.Since(reported) > 2*c.nodeTimeout {
+			delete(n.failReports, id)
+			continue
+		}
+		reports++
+	}
+	if c.myself.IsMaster() {
+		reports++
+	}
+	if reports < c.quorum() {
+		return
+	}
+
+	slog.Warn("Marking node as failing (quorum reached)", "node", n.ID, "reports", reports)
+	n.flags = n.flags&^flagPFail | flagFail
+	n.failTime = time.Now()
+
+	fail := c.header(msgFail)
+	fail.target = n.ID
+	c.broadcast(fail)
+	c.updateState()
+	c.todoSave = true
+}
+
+/*
+* A FAIL node that answers again is cleared if it has no slots to fail over. A master with slots
+* is given some time for a replica to take over first
+ */
+func (c *Cluster) clearFailureIfNeeded(n *Node) {
+	if n.IsReplica() || len(c.SlotRanges(n)) == 0 || time.Since(n.failTime) > 2*c.nodeTimeout {
+		slog.Info("Clear FAIL state for node, it is reachable again", "node", n.ID)
+		n.flags &^= flagFail
+		c.updateState()
+		c.todoSave = true
+	}
+}
+
+/*
+* Two masters with the same config epoch can not tell which one owns a slot they both claim. The
+* one with the smaller id moves to a new epoch
+ */
+func (c *Cluster) handleConfigEpochCollision(sender *Node) {
+	if sender.ID <= c.myself.ID {
+		return
+	}
+	c.currentEpoch++
+	c.myself.ConfigEpoch = c.currentEpoch
+	c.todoSave = true
+	slog.Info("Config epoch collision resolved, configEpoch set", "node", sender.ID, "epoch", c.myself.ConfigEpoch)
+}
+
+/*
+* Gives the slots claimed by the node to it where its config epoch is newer than the one of their
+* current owner. When the master of this node lost all its slots this way, its slots were failed
+* over and this node follows the new master. Otherwise the keys of the slots this node lost are
+* dropped
+ */
+func (c *Cluster) updateSlotsWith(n *Node, epoch uint64, slots []byte) {
+	current := c.myself
+	if c.myself.master != nil {
+		current = c.myself.master
+	}
+
+	var newMaster *Node
+	lost := []int{}
+	changed := false
+	for slot := 0; slot < SlotCount; slot++ {
+		owner := c.slots[slot]
+		if !hasSlot(slots, slot) || owner == n || (owner != nil && owner.ConfigEpoch >= epoch) {
+			continue
+		}
+		// The slot is given to this node with CLUSTER SETSLOT once its keys are moved
+		if c.importing[slot] != nil {
+			continue
+		}
+		if owner == c.myself {
+			lost = append(lost, slot)
+		}
+		if owner != nil && owner == current {
+			newMaster = n
+		}
+		c.slots[slot] = n
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	c.updateState()
+	c.todoSave = true
+
+	if newMaster != nil && len(c.SlotRanges(current)) == 0 {
+		slog.Info("Configuration change detected, reconfiguring myself as a replica", "master", newMaster.ID)
+		c.setMyMaster(newMaster)
+		return
+	}
+	for _, slot := range lost {
+		c.host.DeleteKeysInSlot(slot)
+	}
+}
+
+func (c *Cluster) setNodeAsMaster(n *Node) {
+	n.flags = n.flags&^flagReplica | flagMaster
+	n.master = nil
+	c.todoSave = true
+}
+
+// Makes this node a replica of the master, the server then replicates it
+func (c *Cluster) setMyMaster(master *Node) {
+	c.myself.flags = c.myself.flags&^flagMaster | flagReplica
+	c.myself.master = master
+	c.failoverAuthTime = time.Time{}
+	c.todoSave = true
+	c.host.SetMaster(master)
+}
+
+// Moves the address of the node, its link is reopened to the new address
+func (c *Cluster) updateAddress(n *Node, ip string, port int, busPort int) {
+	if n == c.myself || (n.IP == ip && n.Port == port && n.BusPort == busPort) {
+		return
+	}
+	slog.Info("Address updated for node", "node", n.ID, "ip", ip, "port", port)
+	n.IP, n.Port, n.BusPort = ip, port, busPort
+	n.flags &^= flagNoAddr
+	if n.link != nil {
+		n.link.close()
+		n.link = nil
+	}
+	c.todoSave = true
+}
+
+func (c *Cluster) renameNode(n *Node, id string) {
+	delete(c.nodes, n.ID)
+	n.ID = id
+	c.nodes[id] = n
+}
+
+// Forgets the node, its slots become unassigned
+func (c *Cluster) deleteNode(n *Node) {
+	if n.link != nil {
+		n.link.close()
+		n.link = nil
+	}
+	delete(c.nodes, n.ID)
+	for slot := range c.slots {
+		if c.slots[slot] == n {
+			c.slots[slot] = nil
+		}
+		if c.migrating[slot] == n {
+			c.migrating[slot] = nil
+		}
+		if c.importing[slot] == n {
+			c.importing[slot] = nil
+		}
+	}
+	for _, other := range c.nodes {
+		delete(other.failReports, n.ID)
+		if other.master == n {
+			other.master = nil
+		}
+	}
+	c.updateState()
+	c.todoSave = true
+}
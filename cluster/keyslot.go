@@ -0,0 +1,56 @@
+package cluster
+
+// SlotCount is the number of hash slots the keyspace is divided into
+const SlotCount = 16384
+
+// crc16Table is the lookup table for the CRC16-CCITT (XMODEM) polynomial 0x1021,
+// which is the checksum Redis uses to map keys to hash slots
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+/*
+* Returns the hash slot a key belongs to. If the key contains a non empty hash tag
+* (the substring between the first "{" and the next "}") only the tag is hashed, so
+* related keys like "{user1}.name" and "{user1}.email" end up in the same slot
+ */
+func KeySlot(key string) int {
+	for start := 0; start < len(key); start++ {
+		if key[start] != '{' {
+			continue
+		}
+		for end := start + 1; end < len(key); end++ {
+			if key[end] != '}' {
+				continue
+			}
+			// An empty tag "{}" means the whole key is hashed
+			if end > start+1 {
+				key = key[start+1 : end]
+			}
+			return int(crc16([]byte(key))) & (SlotCount - 1)
+		}
+		break
+	}
+
+	return int(crc16([]byte(key))) & (SlotCount - 1)
+}
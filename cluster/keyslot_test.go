@@ -0,0 +1,28 @@
+package cluster
+
+import "testing"
+
+func TestCrc16(t *testing.T) {
+	// Reference value from the Redis cluster specification
+	if got := crc16([]byte("123456789")); got != 0x31C3 {
+		t.Errorf("crc16 was incorrect. Expected: 0x31C3, Received: %#x", got)
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	tests := map[string]int{
+		"foo":                  12182,
+		"bar":                  5061,
+		"{user1000}.following": KeySlot("user1000"),
+		"{user1000}.followers": KeySlot("user1000"),
+		"foo{}{bar}":           KeySlot("foo{}{bar}"),
+		"foo{{bar}}zap":        KeySlot("{bar"),
+		"foo{bar}{zap}":        KeySlot("bar"),
+	}
+
+	for key, expected := range tests {
+		if got := KeySlot(key); got != expected {
+			t.Errorf("KeySlot(%q) was incorrect. Expected: %d, Received: %d", key, expected, got)
+		}
+	}
+}
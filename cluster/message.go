@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Types of the messages of the cluster bus
+const (
+	msgPing        = "PING"
+	msgPong        = "PONG"
+	msgMeet        = "MEET"
+	msgFail        = "FAIL"
+	msgUpdate      = "UPDATE"
+	msgAuthRequest = "FAILOVER_AUTH_REQUEST"
+	msgAuthAck     = "FAILOVER_AUTH_ACK"
+)
+
+// Fields of the header every message starts with
+const headerFields = 10
+
+// Fields of a gossip entry
+const gossipFields = 7
+
+// What the sender of a PING, PONG or MEET knows about another node
+type gossip struct {
+	id           string
+	ip           string
+	port         int
+	busPort      int
+	flags        nodeFlag
+	pingSent     int64
+	pongReceived int64
+}
+
+/*
+* A message of the cluster bus. Every message carries the state of its sender: its role, its
+* epochs and the slots of its master. A replica announces the config epoch and the slots of its
+* master, so they reach the cluster even while the master is down
+ */
+type message struct {
+	kind         string
+	sender       string
+	port         int
+	busPort      int
+	flags        nodeFlag
+	masterID     string
+	currentEpoch uint64
+	configEpoch  uint64
+	offset       int64
+	slots        []byte
+
+	// PING, PONG and MEET: other nodes the sender knows
+	gossip []gossip
+
+	// FAIL and UPDATE: the node the message is about. UPDATE: its config epoch and its slots
+	target      string
+	targetEpoch uint64
+	targetSlots []byte
+}
+
+/*
+* Encodes the message as a RESP array of bulk strings, so binary fields like the slot bitmaps
+* are sent as they are
+ */
+func (m *message) encode() string {
+	fields := []string{
+		m.kind,
+		m.sender,
+		strconv.Itoa(m.port),
+		strconv.Itoa(m.busPort),
+		strconv.Itoa(int(m.flags)),
+		m.masterID,
+		strconv.FormatUint(m.currentEpoch, 10),
+		strconv.FormatUint(m.configEpoch, 10),
+		strconv.FormatInt(m.offset, 10),
+		string(m.slots),
+	}
+
+	switch m.kind {
+	case msgPing, msgPong, msgMeet:
+		fields = append(fields, strconv.Itoa(len(m.gossip)))
+		for _, g := range m.gossip {
+			fields = append(fields,
+				g.id,
+				g.ip,
+				strconv.Itoa(g.port),
+				strconv.Itoa(g.busPort),
+				strconv.Itoa(int(g.flags)),
+				strconv.FormatInt(g.pingSent, 10),
+				strconv.FormatInt(g.pongReceived, 10),
+			)
+		}
+	case msgFail:
+		fields = append(fields, m.target)
+	case msgUpdate:
+		fields = append(fields, m.target, strconv.FormatUint(m.targetEpoch, 10), string(m.targetSlots))
+	}
+	return resp.RESPSerializeRESPArray(fields)
+}
+
+// Reads the fields of a message in order, keeping the first error
+type fieldReader struct {
+	fields []string
+	err    error
+}
+
+func (r *fieldReader) next() string {
+	if len(r.fields) == 0 {
+		if r.err == nil {
+			r.err = fmt.Errorf("message too short")
+		}
+		return ""
+	}
+	field := r.fields[0]
+	r.fields = r.fields[1:]
+	return field
+}
+
+func (r *fieldReader) int() int {
+	field := r.next()
+	n, err := strconv.Atoi(field)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("invalid integer %q", field)
+	}
+	return n
+}
+
+func (r *fieldReader) int64() int64 {
+	field := r.next()
+	n, err := strconv.ParseInt(field, 10, 64)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("invalid integer %q", field)
+	}
+	return n
+}
+
+func (r *fieldReader) uint64() uint64 {
+	field := r.next()
+	n, err := strconv.ParseUint(field, 10, 64)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("invalid epoch %q", field)
+	}
+	return n
+}
+
+func (r *fieldReader) bitmap() []byte {
+	field := r.next()
+	if len(field) != SlotCount/8 && r.err == nil {
+		r.err = fmt.Errorf("invalid slots bitmap of %d bytes", len(field))
+	}
+	return []byte(field)
+}
+
+// Decodes a message read from the bus
+func decodeMessage(fields []string) (*message, error) {
+	r := &fieldReader{fields: fields}
+	m := &message{
+		kind:         r.next(),
+		sender:       r.next(),
+		port:         r.int(),
+		busPort:      r.int(),
+		flags:        nodeFlag(r.int()),
+		masterID:     r.next(),
+		currentEpoch: r.uint64(),
+		configEpoch:  r.uint64(),
+		offset:       r.int64(),
+		slots:        r.bitmap(),
+	}
+
+	switch m.kind {
+	case msgPing, msgPong, msgMeet:
+		count := r.int()
+		if r.err == nil && len(r.fields) != count*gossipFields {
+			return nil, fmt.Errorf("expected %d gossip entries", count)
+		}
+		for i := 0; i < count && r.err == nil; i++ {
+			m.gossip = append(m.gossip, gossip{
+				id:           r.next(),
+				ip:           r.next(),
+				port:         r.int(),
+				busPort:      r.int(),
+				flags:        nodeFlag(r.int()),
+				pingSent:     r.int64(),
+				pongReceived: r.int64(),
+			})
+		}
+	case msgFail:
+		m.target = r.next()
+	case msgUpdate:
+		m.target = r.next()
+		m.targetEpoch = r.uint64()
+		m.targetSlots = r.bitmap()
+	case msgAuthRequest, msgAuthAck:
+	default:
+		return nil, fmt.Errorf("unknown message type %q", m.kind)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
+
+// Bitmap of the slots a node serves, as messages carry them
+func (c *Cluster) slotsBitmap(n *Node) []byte {
+	bitmap := make([]byte, SlotCount/8)
+	for slot, owner := range c.slots {
+		if owner != nil && owner == n {
+			bitmap[slot/8] |= 1 << (slot % 8)
+		}
+	}
+	return bitmap
+}
+
+func hasSlot(bitmap []byte, slot int) bool {
+	return bitmap[slot/8]&(1<<(slot%8)) != 0
+}
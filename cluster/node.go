@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The cluster bus of a node listens on its port plus this offset, like Redis
+const BusPortOffset = 10000
+
+type nodeFlag int
+
+const (
+	flagMyself    nodeFlag = 1 << iota // The node this server runs
+	flagMaster                         // Serves slots
+	flagReplica                        // Replicates a master
+	flagPFail                          // Not reachable from this node
+	flagFail                           // Agreed failing by the majority of masters
+	flagHandshake                      // Met but not greeted yet, its id is a placeholder
+	flagNoAddr                         // Address unknown
+	flagMeet                           // Greeted with a MEET to join the cluster
+)
+
+// Flag names, in the order CLUSTER NODES lists them
+var flagNames = []struct {
+	flag nodeFlag
+	name string
+}{
+	{flagMyself, "myself"},
+	{flagMaster, "master"},
+	{flagReplica, "slave"},
+	{flagPFail, "fail?"},
+	{flagFail, "fail"},
+	{flagHandshake, "handshake"},
+	{flagNoAddr, "noaddr"},
+}
+
+// A node of the cluster as this server knows it
+type Node struct {
+	ID      string
+	IP      string
+	Port    int
+	BusPort int
+	flags   nodeFlag
+	// Master of a replica, nil for masters
+	master *Node
+	// Epoch of the configuration the node claims its slots with
+	ConfigEpoch uint64
+
+	// Last PING sent that was not answered yet and last PONG received
+	pingSent     time.Time
+	pongReceived time.Time
+	// Outbound link of the cluster bus, nil until this node connects to it
+	link *link
+	// When this node learned about the node, handshakes that take too long are dropped
+	created time.Time
+
+	// Masters that reported the node as failing and when they last did
+	failReports map[string]time.Time
+	// When the node was marked FAIL, and when this master last voted for the failover of a replica of it
+	failTime  time.Time
+	votedTime time.Time
+	// Replication offset the node last announced
+	replOffset int64
+}
+
+// Node ids are 40 random hex characters, like replication ids
+func newNodeID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func newNode(id string, ip string, port int, busPort int, flags nodeFlag) *Node {
+	return &Node{
+		ID:          id,
+		IP:          ip,
+		Port:        port,
+		BusPort:     busPort,
+		flags:       flags,
+		created:     time.Now(),
+		failReports: map[string]time.Time{},
+	}
+}
+
+func (n *Node) IsMyself() bool {
+	return n.flags&flagMyself != 0
+}
+
+func (n *Node) IsMaster() bool {
+	return n.flags&flagMaster != 0
+}
+
+func (n *Node) IsReplica() bool {
+	return n.flags&flagReplica != 0
+}
+
+// Whether the node is considered failing, by this node only or by the cluster
+func (n *Node) Failing() bool {
+	return n.flags&(flagPFail|flagFail) != 0
+}
+
+func (n *Node) Master() *Node {
+	return n.master
+}
+
+// Address clients reach the node at
+func (n *Node) Addr() string {
+	return net.JoinHostPort(n.IP, strconv.Itoa(n.Port))
+}
+
+func (n *Node) Flags() string {
+	names := []string{}
+	for _, f := range flagNames {
+		if n.flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "noflags"
+	}
+	return strings.Join(names, ",")
+}
+
+// Parses the flags of a line of nodes.conf. Failures only suspected by the node are not kept
+func parseFlags(names string) nodeFlag {
+	var flags nodeFlag
+	for _, name := range strings.Split(names, ",") {
+		if name == "fail?" {
+			continue
+		}
+		for _, f := range flagNames {
+			if f.name == name {
+				flags |= f.flag
+			}
+		}
+	}
+	return flags
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+/*
+* Describes the node as a line of CLUSTER NODES:
+* <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+ */
+func (n *Node) describe(slots [][2]int) string {
+	masterID := "-"
+	if n.master != nil {
+		masterID = n.master.ID
+	}
+	linkState := "disconnected"
+	if n.IsMyself() || (n.link != nil && n.link.conn != nil) {
+		linkState = "connected"
+	}
+
+	fields := []string{
+		n.ID,
+		fmt.Sprintf("%s:%d@%d", n.IP, n.Port, n.BusPort),
+		n.Flags(),
+		masterID,
+		strconv.FormatInt(unixMilli(n.pingSent), 10),
+		strconv.FormatInt(unixMilli(n.pongReceived), 10),
+		strconv.FormatUint(n.ConfigEpoch, 10),
+		linkState,
+	}
+	for _, r := range slots {
+		if r[0] == r[1] {
+			fields = append(fields, strconv.Itoa(r[0]))
+		} else {
+			fields = append(fields, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	pers "github.com/jason-gill00/redis-from-scratch/persistence"
+)
+
+var fix = flag.Bool("fix", false, "Truncate the file to its last valid command")
+
+/*
+* Checks an append only file and optionally repairs it, like redis-check-aof:
+*
+*	redis-check-aof [--fix] <file.aof|file.manifest>
+*
+* Given a manifest every file of a multi part AOF is checked. A file that ends in the middle of
+* a command can be fixed by cutting off the incomplete command, for a multi part AOF only the
+* last file can be fixed since a crash only truncates the file being appended to
+ */
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: redis-check-aof [--fix] <file.aof|file.manifest>")
+		os.Exit(1)
+	}
+
+	paths := []string{flag.Arg(0)}
+	if strings.HasSuffix(flag.Arg(0), ".manifest") {
+		var err error
+		if paths, err = pers.AofManifestFiles(flag.Arg(0)); err != nil {
+			fmt.Printf("Invalid AOF manifest %s: %s\n", flag.Arg(0), err.Error())
+			os.Exit(1)
+		}
+	}
+
+	for i, path := range paths {
+		checkAof(path, i == len(paths)-1)
+	}
+}
+
+func checkAof(path string, last bool) {
+	check, err := pers.CheckAof(path)
+	if err != nil && !errors.Is(err, pers.ErrAofTruncated) {
+		fmt.Printf("AOF %s is not valid: %s\n", path, err.Error())
+		// Nothing valid to keep, truncating would wipe the whole file
+		if check.ValidTo == 0 {
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+		path, check.Size, check.ValidTo, check.Commands, check.Size-check.ValidTo)
+	if err == nil {
+		fmt.Printf("AOF %s is valid\n", path)
+		return
+	}
+
+	if !*fix {
+		fmt.Printf("AOF %s is not valid. Use the --fix option to try fixing it.\n", path)
+		os.Exit(1)
+	}
+	if !last {
+		fmt.Printf("AOF %s is not the last file of the AOF and can not be fixed\n", path)
+		os.Exit(1)
+	}
+	if err := os.Truncate(path, check.ValidTo); err != nil {
+		fmt.Printf("Failed to truncate AOF %s: %s\n", path, err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully truncated AOF %s to %d bytes\n", path, check.ValidTo)
+}
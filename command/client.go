@@ -0,0 +1,211 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+	"github.com/jason-gill00/redis-from-scratch/tracking"
+)
+
+const (
+	CLIENT = "CLIENT"
+	HELLO  = "HELLO"
+)
+
+/*
+* CLIENT ID | SETNAME | GETNAME | TRACKING | CACHING | GETREDIR | TRACKINGINFO
+ */
+func clientCommandHandler(command []string, c *client.Client, server *Server) string {
+	subcommand := strings.ToUpper(command[1])
+	args := command[2:]
+
+	switch subcommand {
+	case "ID":
+		return resp.RESPSerializeInteger(int(c.Id))
+	case "SETNAME":
+		if len(args) != 1 {
+			return wrongNumberOfArguments("client|setname")
+		}
+		if strings.ContainsAny(args[0], " \n") {
+			return resp.RESPSerializeError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.Name = args[0]
+		return resp.RESPSerializeSimpleString("OK")
+	case "GETNAME":
+		if c.Name == "" {
+			return resp.RESPNil
+		}
+		return resp.RESPSerializeBulkString(c.Name)
+	case "TRACKING":
+		return clientTrackingHandler(args, c, server)
+	case "CACHING":
+		if len(args) != 1 {
+			return wrongNumberOfArguments("client|caching")
+		}
+		yes := strings.ToUpper(args[0]) == "YES"
+		if !yes && strings.ToUpper(args[0]) != "NO" {
+			return resp.RESPSerializeError("ERR syntax error")
+		}
+		if err := server.Tracker.Caching(c, yes); err != nil {
+			return resp.RESPSerializeError(err.Error())
+		}
+		return resp.RESPSerializeSimpleString("OK")
+	case "GETREDIR":
+		options, ok := server.Tracker.Info(c)
+		if !ok {
+			return resp.RESPSerializeInteger(-1)
+		}
+		return resp.RESPSerializeInteger(int(options.Redirect))
+	case "TRACKINGINFO":
+		return clientTrackingInfoHandler(c, server)
+	default:
+		return resp.RESPSerializeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", command[1]))
+	}
+}
+
+/*
+* CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+ */
+func clientTrackingHandler(args []string, c *client.Client, server *Server) string {
+	if len(args) == 0 {
+		return wrongNumberOfArguments("client|tracking")
+	}
+
+	options := tracking.Options{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return resp.RESPSerializeError("ERR syntax error")
+			}
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return resp.RESPSerializeError(notIntegerError)
+			}
+			options.Redirect = id
+			i++
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return resp.RESPSerializeError("ERR syntax error")
+			}
+			options.Prefixes = append(options.Prefixes, args[i+1])
+			i++
+		case "BCAST":
+			options.Bcast = true
+		case "OPTIN":
+			options.OptIn = true
+		case "OPTOUT":
+			options.OptOut = true
+		case "NOLOOP":
+			options.NoLoop = true
+		default:
+			return resp.RESPSerializeError("ERR syntax error")
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ON":
+		if err := server.Tracker.Enable(c, options); err != nil {
+			return resp.RESPSerializeError(err.Error())
+		}
+	case "OFF":
+		server.Tracker.Disable(c)
+	default:
+		return resp.RESPSerializeError("ERR syntax error")
+	}
+
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+func clientTrackingInfoHandler(c *client.Client, server *Server) string {
+	options, ok := server.Tracker.Info(c)
+
+	flags := []string{}
+	prefixes := []string{}
+	redirect := -1
+	if !ok {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		redirect = int(options.Redirect)
+		if options.Bcast {
+			flags = append(flags, "bcast")
+			prefixes = options.Prefixes
+		}
+		if options.OptIn {
+			flags = append(flags, "optin")
+		}
+		if options.OptOut {
+			flags = append(flags, "optout")
+		}
+		if options.NoLoop {
+			flags = append(flags, "noloop")
+		}
+		if options.Redirect != 0 {
+			if _, ok := server.Clients.Get(options.Redirect); !ok {
+				flags = append(flags, "broken_redirect")
+			}
+		}
+	}
+
+	return resp.RESPSerializeRawArray([]string{
+		resp.RESPSerializeBulkString("flags"), resp.RESPSerializeRESPArray(flags),
+		resp.RESPSerializeBulkString("redirect"), resp.RESPSerializeInteger(redirect),
+		resp.RESPSerializeBulkString("prefixes"), resp.RESPSerializeRESPArray(prefixes),
+	})
+}
+
+/*
+* HELLO [protover [SETNAME clientname]]. Switches the protocol spoken on the connection
+* and replies with information about the server
+ */
+func helloCommandHandler(command []string, c *client.Client, server *Server) string {
+	protocol := c.Protocol
+	if len(command) > 1 {
+		version, err := strconv.Atoi(command[1])
+		if err != nil {
+			return resp.RESPSerializeError("ERR Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			return resp.RESPSerializeError("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+	}
+
+	for i := 2; i < len(command); i++ {
+		if strings.ToUpper(command[i]) == "SETNAME" && i+1 < len(command) {
+			c.Name = command[i+1]
+			i++
+			continue
+		}
+		return resp.RESPSerializeError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", command[i]))
+	}
+	c.Protocol = protocol
+
+	role := "master"
+	if server.ReplicationConfig["replicaof"] != "" {
+		role = "replica"
+	}
+	// Cluster aware clients tell from mode whether to follow redirections and fetch the slot map
+	mode := "standalone"
+	if server.Cluster != nil {
+		mode = "cluster"
+	}
+
+	elements := []string{
+		resp.RESPSerializeBulkString("server"), resp.RESPSerializeBulkString("redis"),
+		resp.RESPSerializeBulkString("version"), resp.RESPSerializeBulkString("7.2.0"),
+		resp.RESPSerializeBulkString("proto"), resp.RESPSerializeInteger(protocol),
+		resp.RESPSerializeBulkString("id"), resp.RESPSerializeInteger(int(c.Id)),
+		resp.RESPSerializeBulkString("mode"), resp.RESPSerializeBulkString(mode),
+		resp.RESPSerializeBulkString("role"), resp.RESPSerializeBulkString(role),
+		resp.RESPSerializeBulkString("modules"), resp.RESPSerializeRawArray([]string{}),
+	}
+	if protocol >= 3 {
+		return resp.RESPSerializeMap(elements)
+	}
+	return resp.RESPSerializeRawArray(elements)
+}
@@ -0,0 +1,368 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/cluster"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	CLUSTER   = "CLUSTER"
+	READONLY  = "READONLY"
+	READWRITE = "READWRITE"
+	ASKING    = "ASKING"
+)
+
+const clusterDisabledError = "ERR This instance has cluster support disabled"
+
+// Number of arguments of the CLUSTER subcommands, negative for a minimum, the command name included
+var clusterArity = map[string]int{
+	"INFO":                  2,
+	"MYID":                  2,
+	"NODES":                 2,
+	"SLOTS":                 2,
+	"SHARDS":                2,
+	"KEYSLOT":               3,
+	"COUNTKEYSINSLOT":       3,
+	"GETKEYSINSLOT":         4,
+	"ADDSLOTS":              -3,
+	"ADDSLOTSRANGE":         -4,
+	"DELSLOTS":              -3,
+	"DELSLOTSRANGE":         -4,
+	"MEET":                  -4,
+	"REPLICATE":             3,
+	"REPLICAS":              3,
+	"SLAVES":                3,
+	"COUNT-FAILURE-REPORTS": 3,
+	"SETSLOT":               -4,
+}
+
+/*
+* Redirects a command whose keys this node does not serve. The keys, taken from the key
+* positions of the command registry, must all hash to the same slot. The master link and the
+* AOF replay always run their commands. Keys of a slot being migrated are served by the node that
+* has them, the others are redirected with ASK
+ */
+func checkClusterRedirection(command []string, c *client.Client, server *Server) string {
+	if server.Cluster == nil || c.Master {
+		return ""
+	}
+	keys := commandKeys(command)
+	if len(keys) == 0 {
+		return ""
+	}
+	if !sameSlot(keys) {
+		return resp.RESPSerializeError(crossSlotError)
+	}
+
+	name := strings.ToUpper(command[0])
+	query := cluster.Query{
+		Keys:     keys,
+		Slot:     cluster.KeySlot(keys[0]),
+		Write:    IsWrite(command),
+		ReadOnly: c.ReadOnly,
+		Asking:   c.Asking || name == RESTORE_ASKING,
+		Migrate:  name == MIGRATE,
+		Exists: func(key string) bool {
+			_, ok := server.Store.Type(key)
+			return ok
+		},
+	}
+	if err := server.Cluster.Route(query); err != nil {
+		return resp.RESPSerializeError(err.Error())
+	}
+	return ""
+}
+
+/*
+* CLUSTER <subcommand>: inspecting the cluster and the slots, and building it by assigning slots
+* to nodes and introducing nodes to each other
+ */
+func clusterCommandHandler(command []string, c *client.Client, server *Server) string {
+	if server.Cluster == nil {
+		return resp.RESPSerializeError(clusterDisabledError)
+	}
+	sub := strings.ToUpper(command[1])
+	arity, ok := clusterArity[sub]
+	if !ok {
+		return resp.RESPSerializeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", command[1]))
+	}
+	if (arity > 0 && len(command) != arity) || len(command) < -arity {
+		return wrongNumberOfArguments(CLUSTER + "|" + sub)
+	}
+
+	cl := server.Cluster
+	args := command[2:]
+	switch sub {
+	case "INFO":
+		info := ""
+		for _, field := range cl.Info() {
+			info += fmt.Sprintf("%s:%s\r\n", field[0], field[1])
+		}
+		return resp.RESPSerializeBulkString(info)
+	case "MYID":
+		return resp.RESPSerializeBulkString(cl.Myself().ID)
+	case "NODES":
+		return resp.RESPSerializeBulkString(cl.Describe())
+	case "SLOTS":
+		return clusterSlots(c, server)
+	case "SHARDS":
+		return clusterShards(c, server)
+	case "KEYSLOT":
+		return resp.RESPSerializeInteger(cluster.KeySlot(args[0]))
+	case "COUNTKEYSINSLOT":
+		slot, err := cluster.ParseSlot(args[0])
+		if err != nil {
+			return resp.RESPSerializeError("ERR Invalid slot")
+		}
+		return resp.RESPSerializeInteger(server.Store.CountKeysInSlot(slot))
+	case "GETKEYSINSLOT":
+		slot, err := cluster.ParseSlot(args[0])
+		count, countErr := strconv.Atoi(args[1])
+		if err != nil || countErr != nil || count < 0 {
+			return resp.RESPSerializeError("ERR Invalid slot or number of keys")
+		}
+		return resp.RESPSerializeRESPArray(server.Store.KeysInSlot(slot, count))
+	case "ADDSLOTS", "DELSLOTS", "ADDSLOTSRANGE", "DELSLOTSRANGE":
+		return clusterSlotsChange(sub, args, cl)
+	case "REPLICATE":
+		return clusterReplicate(args[0], server)
+	case "REPLICAS", "SLAVES":
+		master := cl.Node(args[0])
+		if master == nil {
+			return resp.RESPSerializeError("ERR Unknown node " + args[0])
+		}
+		if !master.IsMaster() {
+			return resp.RESPSerializeError("ERR The specified node is not a master")
+		}
+		replicas := []string{}
+		for _, n := range cl.Replicas(master) {
+			replicas = append(replicas, cl.DescribeNode(n))
+		}
+		return resp.RESPSerializeRESPArray(replicas)
+	case "COUNT-FAILURE-REPORTS":
+		n := cl.Node(args[0])
+		if n == nil {
+			return resp.RESPSerializeError("ERR Unknown node " + args[0])
+		}
+		return resp.RESPSerializeInteger(cl.FailureReports(n))
+	case "SETSLOT":
+		return clusterSetSlot(args, cl)
+	default:
+		return clusterMeet(args, cl)
+	}
+}
+
+/*
+* CLUSTER REPLICATE node-id: this node replicates the master from now on, and takes over its slots
+* if it fails. A master has to be empty to become a replica
+ */
+func clusterReplicate(id string, server *Server) string {
+	cl := server.Cluster
+	if cl.Myself().IsMaster() && server.Store.MemoryStats().Keys > 0 {
+		return resp.RESPSerializeError("ERR To set a master the node must be empty and without assigned slots.")
+	}
+	if err := cl.Replicate(id); err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// CLUSTER SETSLOT slot MIGRATING|IMPORTING|NODE node-id, or CLUSTER SETSLOT slot STABLE
+func clusterSetSlot(args []string, cl *cluster.Cluster) string {
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+	state, id := strings.ToUpper(args[1]), ""
+	switch {
+	case state == "STABLE" && len(args) == 2:
+	case (state == "MIGRATING" || state == "IMPORTING" || state == "NODE") && len(args) == 3:
+		id = args[2]
+	default:
+		return resp.RESPSerializeError("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+
+	if err := cl.SetSlot(slot, state, id); err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// CLUSTER ADDSLOTS|DELSLOTS slot [slot ...] and their RANGE variants taking start-slot end-slot pairs
+func clusterSlotsChange(sub string, args []string, cl *cluster.Cluster) string {
+	isRange := strings.HasSuffix(sub, "RANGE")
+	if isRange && len(args)%2 != 0 {
+		return wrongNumberOfArguments(CLUSTER + "|" + sub)
+	}
+
+	slots := []int{}
+	for i := 0; i < len(args); i++ {
+		slot, err := cluster.ParseSlot(args[i])
+		if err != nil {
+			return resp.RESPSerializeError("ERR " + err.Error())
+		}
+		if !isRange {
+			slots = append(slots, slot)
+			continue
+		}
+
+		i++
+		end, err := cluster.ParseSlot(args[i])
+		if err != nil {
+			return resp.RESPSerializeError("ERR " + err.Error())
+		}
+		if end < slot {
+			return resp.RESPSerializeError(fmt.Sprintf("ERR start slot number %d is greater than end slot number %d", slot, end))
+		}
+		for s := slot; s <= end; s++ {
+			slots = append(slots, s)
+		}
+	}
+
+	var err error
+	if strings.HasPrefix(sub, "ADD") {
+		err = cl.AddSlots(slots)
+	} else {
+		err = cl.DelSlots(slots)
+	}
+	if err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// CLUSTER MEET ip port [cluster-bus-port]
+func clusterMeet(args []string, cl *cluster.Cluster) string {
+	if len(args) > 3 {
+		return wrongNumberOfArguments(CLUSTER + "|MEET")
+	}
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		return resp.RESPSerializeError(fmt.Sprintf("ERR Invalid base port specified: %s", args[1]))
+	}
+	busPort := port + cluster.BusPortOffset
+	if len(args) == 3 {
+		if busPort, err = strconv.Atoi(args[2]); err != nil {
+			return resp.RESPSerializeError(fmt.Sprintf("ERR Invalid bus port specified: %s", args[2]))
+		}
+	}
+
+	if err := cl.Meet(args[0], port, busPort); err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+/*
+* IP clients reach a node at. This node may not know its own address until another node tells
+* it, it then answers with the address the client connected to
+ */
+func nodeIP(n *cluster.Node, c *client.Client) string {
+	if n.IP != "" || c.Conn() == nil {
+		return n.IP
+	}
+	ip, _, _ := net.SplitHostPort(c.Conn().LocalAddr().String())
+	return ip
+}
+
+// CLUSTER SLOTS: every range of slots with the master serving it followed by its replicas
+func clusterSlots(c *client.Client, server *Server) string {
+	cl := server.Cluster
+	ranges := []string{}
+	for _, master := range cl.Nodes() {
+		for _, r := range cl.SlotRanges(master) {
+			elements := []string{resp.RESPSerializeInteger(r[0]), resp.RESPSerializeInteger(r[1])}
+			for _, n := range append([]*cluster.Node{master}, cl.Replicas(master)...) {
+				if n.Failing() && n != master {
+					continue
+				}
+				elements = append(elements, resp.RESPSerializeRawArray([]string{
+					resp.RESPSerializeBulkString(nodeIP(n, c)),
+					resp.RESPSerializeInteger(n.Port),
+					resp.RESPSerializeBulkString(n.ID),
+				}))
+			}
+			ranges = append(ranges, resp.RESPSerializeRawArray(elements))
+		}
+	}
+	return resp.RESPSerializeRawArray(ranges)
+}
+
+// CLUSTER SHARDS: every master with its slot ranges, and its nodes with their role and health
+func clusterShards(c *client.Client, server *Server) string {
+	cl := server.Cluster
+	shards := []string{}
+	for _, master := range cl.Nodes() {
+		if !master.IsMaster() {
+			continue
+		}
+
+		slots := []string{}
+		for _, r := range cl.SlotRanges(master) {
+			slots = append(slots, resp.RESPSerializeInteger(r[0]), resp.RESPSerializeInteger(r[1]))
+		}
+		nodes := []string{}
+		for _, n := range append([]*cluster.Node{master}, cl.Replicas(master)...) {
+			nodes = append(nodes, shardNode(n, c, server))
+		}
+		shards = append(shards, resp.RESPSerializeRawArray([]string{
+			resp.RESPSerializeBulkString("slots"), resp.RESPSerializeRawArray(slots),
+			resp.RESPSerializeBulkString("nodes"), resp.RESPSerializeRawArray(nodes),
+		}))
+	}
+	return resp.RESPSerializeRawArray(shards)
+}
+
+func shardNode(n *cluster.Node, c *client.Client, server *Server) string {
+	role := "master"
+	if n.IsReplica() {
+		role = "replica"
+	}
+	health := "online"
+	if n.Failing() {
+		health = "failed"
+	}
+	// Only the offset of this node is known
+	offset := 0
+	if n.IsMyself() {
+		offset = int(server.Replication.Offset())
+	}
+
+	ip := nodeIP(n, c)
+	return resp.RESPSerializeRawArray([]string{
+		resp.RESPSerializeBulkString("id"), resp.RESPSerializeBulkString(n.ID),
+		resp.RESPSerializeBulkString("port"), resp.RESPSerializeInteger(n.Port),
+		resp.RESPSerializeBulkString("ip"), resp.RESPSerializeBulkString(ip),
+		resp.RESPSerializeBulkString("endpoint"), resp.RESPSerializeBulkString(ip),
+		resp.RESPSerializeBulkString("role"), resp.RESPSerializeBulkString(role),
+		resp.RESPSerializeBulkString("replication-offset"), resp.RESPSerializeInteger(offset),
+		resp.RESPSerializeBulkString("health"), resp.RESPSerializeBulkString(health),
+	})
+}
+
+/*
+* READONLY lets the client read keys of the master of this replica instead of being redirected
+* to it, READWRITE turns it back off
+ */
+func readOnlyCommandHandler(command []string, c *client.Client, server *Server) string {
+	if server.Cluster == nil {
+		return resp.RESPSerializeError(clusterDisabledError)
+	}
+	c.ReadOnly = strings.ToUpper(command[0]) == READONLY
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// ASKING lets the next command of the client access a slot this node is importing
+func askingCommandHandler(c *client.Client, server *Server) string {
+	if server.Cluster == nil {
+		return resp.RESPSerializeError(clusterDisabledError)
+	}
+	c.Asking = true
+	return resp.RESPSerializeSimpleString("OK")
+}
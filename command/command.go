@@ -7,100 +7,222 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jason-gill00/redis-from-scratch/client"
 	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/replication"
 	"github.com/jason-gill00/redis-from-scratch/resp"
 )
 
 const (
-	GET      = "GET"
-	SET      = "SET"
-	PING     = "PING"
-	ECHO     = "ECHO"
-	CONFIG   = "CONFIG"
-	KEY      = "KEYS"
-	INFO     = "INFO"
-	REPLCONF = "REPLCONF"
-	PSYNC    = "PSYNC"
+	GET       = "GET"
+	SET       = "SET"
+	PING      = "PING"
+	ECHO      = "ECHO"
+	SELECT    = "SELECT"
+	CONFIG    = "CONFIG"
+	KEY       = "KEYS"
+	INFO      = "INFO"
+	REPLCONF  = "REPLCONF"
+	PSYNC     = "PSYNC"
+	DEL       = "DEL"
+	EXPIRE    = "EXPIRE"
+	PEXPIRE   = "PEXPIRE"
+	EXPIREAT  = "EXPIREAT"
+	PEXPIREAT = "PEXPIREAT"
+	TYPE      = "TYPE"
+)
+
+const (
+	crossSlotError  = "CROSSSLOT Keys in request don't hash to the same slot"
+	notIntegerError = "ERR value is not an integer or out of range"
 )
 
 /*
 * Takes in deserialized command array (["SET", "KEY", "VALUE"])
 * and returns response that the connected client would expect
  */
-func CacheCommandHandler(command []string, store *persistence.Store, config map[string]string, replicationConfig map[string]string) (string, error) {
+func CacheCommandHandler(command []string, c *client.Client, server *Server) (string, error) {
 	if len(command) == 0 {
 		return "", fmt.Errorf("no command found")
 	}
+	server.propagation, server.rewritten = nil, false
+	// ASKING only applies to the command that follows it
+	if strings.ToUpper(command[0]) != ASKING {
+		defer func() { c.Asking = false }()
+	}
+
+	if errResponse := checkArity(command); errResponse != "" {
+		return errResponse, nil
+	}
+
+	if errResponse := checkClusterRedirection(command, c, server); errResponse != "" {
+		return errResponse, nil
+	}
+
+	if errResponse := checkSubscribedContext(command, c, server.PubSub); errResponse != "" {
+		return errResponse, nil
+	}
+
+	if errResponse := checkReadOnlyReplica(command, c, server); errResponse != "" {
+		return errResponse, nil
+	}
+
+	if errResponse := checkGoodReplicas(command, c, server); errResponse != "" {
+		return errResponse, nil
+	}
+
+	// Free memory before running the command. Replicas ignore maxmemory, the master evicts for them.
+	// Commands of the master and of the AOF replay are applied whatever the memory, like while loading
+	if !c.Master && server.ReplicationConfig["replicaof"] == "" && server.Store.MaxMemory() > 0 {
+		if err := server.Store.PerformEvictions(); err != nil && isDenyOOM(command) {
+			return resp.RESPSerializeError(err.Error()), nil
+		}
+	}
+
+	server.Tracker.BeforeCommand(c)
+	response, err := executeCommand(command, c, server)
+
+	// Remember the keys read by tracking clients. CLIENT CACHING applies to the command after it
+	var readKeys []string
+	if err == nil && isReadOnly(command) {
+		readKeys = commandKeys(command)
+	}
+	isClientCaching := strings.ToUpper(command[0]) == CLIENT && strings.ToUpper(command[1]) == "CACHING"
+	server.Tracker.AfterCommand(c, readKeys, !isClientCaching)
+
+	return response, err
+}
+
+func executeCommand(command []string, c *client.Client, server *Server) (string, error) {
+	if response, ok := pubSubCommandHandler(command, c, server.PubSub); ok {
+		return response, nil
+	}
+
+	store, config := server.Store, server.Config
 
 	switch strings.ToUpper(command[0]) {
 	case PING:
 		return pingCommandHandler(), nil
 	case ECHO:
 		return echoCommandHandler(command), nil
+	case SELECT:
+		return selectCommandHandler(command), nil
 	case GET:
 		return getCommandHandler(command, store), nil
 	case SET:
-		return setCommandHandler(command, store), nil
+		return setCommandHandler(command, server), nil
+	case DEL:
+		return delCommandHandler(command, server), nil
+	case EXPIRE:
+		return expireCommandHandler(command, server, time.Second), nil
+	case PEXPIRE:
+		return expireCommandHandler(command, server, time.Millisecond), nil
+	case EXPIREAT:
+		return expireAtCommandHandler(command, server, time.Second), nil
+	case PEXPIREAT:
+		return expireAtCommandHandler(command, server, time.Millisecond), nil
+	case TYPE:
+		return typeCommandHandler(command, store), nil
 	case CONFIG:
-		return configCommandHandler(command, config)
+		return configCommandHandler(command, server), nil
 	case KEY:
 		return keyCommandHandler(command, config)
 	case INFO:
-		return infoCommandHandler(command, replicationConfig)
+		return infoCommandHandler(command, server)
 	case REPLCONF:
-		return replConfCommandHandler(command, replicationConfig), nil
+		return replConfCommandHandler(command, c, server), nil
 	case PSYNC:
-		return psyncCommandHandler(command), nil
+		return psyncCommandHandler(command, server), nil
+	case CLIENT:
+		return clientCommandHandler(command, c, server), nil
+	case HELLO:
+		return helloCommandHandler(command, c, server), nil
+	case OBJECT:
+		return objectCommandHandler(command, server), nil
+	case MEMORY:
+		return memoryCommandHandler(command, server), nil
+	case SAVE:
+		return saveCommandHandler(server), nil
+	case BGSAVE:
+		return bgsaveCommandHandler(server), nil
+	case LASTSAVE:
+		return lastsaveCommandHandler(server), nil
+	case BGREWRITEAOF:
+		return bgrewriteaofCommandHandler(server), nil
+	case WAIT:
+		return waitCommandHandler(command, c, server), nil
+	case WAITAOF:
+		return waitaofCommandHandler(command, c, server), nil
+	case REPLICAOF, SLAVEOF:
+		return replicaofCommandHandler(command, server), nil
+	case ROLE:
+		return roleCommandHandler(server), nil
+	case CLUSTER:
+		return clusterCommandHandler(command, c, server), nil
+	case READONLY, READWRITE:
+		return readOnlyCommandHandler(command, c, server), nil
+	case ASKING:
+		return askingCommandHandler(c, server), nil
+	case DUMP:
+		return dumpCommandHandler(command, server), nil
+	case RESTORE, RESTORE_ASKING:
+		return restoreCommandHandler(command, server), nil
+	case MIGRATE:
+		return migrateCommandHandler(command, server), nil
 	default:
 		return "", nil
 	}
 }
 
-func psyncCommandHandler(command []string) string {
-	// TODO: implement replication id
-	replicationId := "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-	fmt.Println("PSYNC command received")
-	if command[1] == "?" && command[2] == "-1" {
-		fmt.Println("PSYNC command received returning fullresync")
-		return resp.RESPSerializeSimpleString(fmt.Sprintf("FULLRESYNC %s 0", replicationId))
-	}
-
-	fmt.Println("returning nothing")
-
-	return ""
+func wrongNumberOfArguments(command string) string {
+	return resp.RESPSerializeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
 }
 
-func replConfCommandHandler(command []string, replicationConfig map[string]string) string {
-	fmt.Println("REPLCONF command received: ", command[1])
-	fmt.Println("REPLCONF command received: ", command)
-	if strings.ToLower(command[1]) == "getack" {
-		offset := replicationConfig["slave_repl_offset"]
-		fmt.Println("REPLCONF GETACK command received: ", offset)
-		return resp.RESPSerializeRESPArray([]string{"REPLCONF", "ACK", offset})
+/*
+* PSYNC replid offset. Replies +CONTINUE followed by the backlog bytes the replica is missing, or
+* +FULLRESYNC with the offset the replica starts at once it loaded a snapshot of the dataset
+ */
+func psyncCommandHandler(command []string, server *Server) string {
+	// A replica serves the history of its master, it has none while it is not connected
+	if server.ReplicationConfig["replicaof"] != "" && server.MasterLink.State() != replication.LinkConnected {
+		return resp.RESPSerializeError("NOMASTERLINK Can't SYNC while not connected with my master")
 	}
-
-	return resp.RESPSerializeSimpleString("OK")
+	response, _ := server.Replication.Psync(command[1], command[2])
+	return response
 }
 
-func infoCommandHandler(command []string, replicationConfig map[string]string) (string, error) {
-	fmt.Println("INFO command received: ", command)
-	fmt.Println(command)
-	if len(command) == 1 {
-		return "", fmt.Errorf("no command found")
-	}
-	if command[1] != "replication" {
-		return "", fmt.Errorf("invalid info command: %s", command[1])
-	}
-
-	// TODO: Implement replication id and offset
-	replicationId := "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-	offset := "0"
-
-	if replicationConfig["replicaof"] != "" {
-		return resp.RESPSerializeBulkString("role:slave"), nil
+/*
+* REPLCONF options sent during the handshake and acknowledgments of the replication stream.
+* GETACK is sent by the master to a replica, ACK by a replica to its master and gets no reply
+ */
+func replConfCommandHandler(command []string, c *client.Client, server *Server) string {
+	switch strings.ToLower(command[1]) {
+	case "getack":
+		offset := strconv.FormatInt(server.Replication.Offset(), 10)
+		return resp.RESPSerializeRESPArray([]string{"REPLCONF", "ACK", offset})
+	case "ack":
+		// REPLCONF ACK <offset> [FACK <aofoffset>]
+		if len(command) < 3 || c.ReplicaState == "" {
+			return ""
+		}
+		if offset, err := strconv.ParseInt(command[2], 10, 64); err == nil {
+			c.ReplicaAckOffset = max(c.ReplicaAckOffset, offset)
+			c.ReplicaAckTime = time.Now()
+		}
+		if len(command) >= 5 && strings.ToLower(command[3]) == "fack" {
+			if aofOffset, err := strconv.ParseInt(command[4], 10, 64); err == nil {
+				c.ReplicaAofAckOffset = max(c.ReplicaAofAckOffset, aofOffset)
+			}
+		}
+		return ""
+	case "listening-port":
+		if len(command) < 3 {
+			return wrongNumberOfArguments(command[0])
+		}
+		c.ReplicaListeningPort = command[2]
 	}
 
-	return resp.RESPSerializeBulkString(fmt.Sprintf("role:master\nmaster_replid:%s\nmaster_repl_offset:%s", replicationId, offset)), nil
+	return resp.RESPSerializeSimpleString("OK")
 }
 
 func keyCommandHandler(command []string, config map[string]string) (string, error) {
@@ -109,7 +231,7 @@ func keyCommandHandler(command []string, config map[string]string) (string, erro
 
 	}
 
-	parsedRdb, err := persistence.ParseRdbFile(config["dir"] + "/" + config["dbFileName"])
+	parsedRdb, err := persistence.ParseRdbFile(config["dir"] + "/" + config["dbfilename"])
 	if err != nil {
 		return "", err
 	}
@@ -123,17 +245,46 @@ func keyCommandHandler(command []string, config map[string]string) (string, erro
 
 }
 
-func configCommandHandler(command []string, config map[string]string) (string, error) {
-	if command[1] != GET {
-		return "", fmt.Errorf("invalid config command: %s", command[1])
+// CONFIG GET parameter or CONFIG SET parameter value [parameter value ...]
+func configCommandHandler(command []string, server *Server) string {
+	switch strings.ToUpper(command[1]) {
+	case GET:
+		if len(command) < 3 {
+			return wrongNumberOfArguments("config|get")
+		}
+		configParam := strings.ToLower(command[2])
+		if val, ok := server.Config[configParam]; ok {
+			return resp.RESPSerializeRESPArray([]string{configParam, val})
+		}
+		return resp.RESPNil
+	case SET:
+		return configSetCommandHandler(command, server)
+	default:
+		return resp.RESPSerializeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", command[1]))
 	}
-	configParam := command[2]
+}
 
-	if val, ok := config[configParam]; ok {
-		return resp.RESPSerializeRESPArray([]string{configParam, val}), nil
+/*
+* CONFIG SET parameter value [parameter value ...]. Only parameters the server knows about can be set
+ */
+func configSetCommandHandler(command []string, server *Server) string {
+	args := command[2:]
+	if len(args) == 0 || len(args)%2 != 0 {
+		return wrongNumberOfArguments("config|set")
 	}
 
-	return resp.RESPNil, nil
+	for i := 0; i < len(args); i += 2 {
+		param, val := strings.ToLower(args[i]), args[i+1]
+		if _, ok := server.Config[param]; !ok {
+			return resp.RESPSerializeError(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i]))
+		}
+
+		if err := server.ApplyConfig(param, val); err != nil {
+			return resp.RESPSerializeError(fmt.Sprintf("ERR Invalid argument '%s' for CONFIG SET '%s' - %s", val, param, err.Error()))
+		}
+	}
+
+	return resp.RESPSerializeSimpleString("OK")
 }
 
 func pingCommandHandler() string {
@@ -145,19 +296,106 @@ func echoCommandHandler(command []string) string {
 	return resp.RESPSerializeSimpleString(command[1])
 }
 
-func setCommandHandler(command []string, store *persistence.Store) string {
+// SELECT index. There is a single database, so MIGRATE to another one fails on the target
+func selectCommandHandler(command []string) string {
+	index, err := strconv.Atoi(command[1])
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+	if index != 0 {
+		return resp.RESPSerializeError("ERR DB index is out of range")
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+/*
+* SET key value [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds].
+* A relative expiration is propagated as PXAT so replicas and the AOF expire the key at the same time
+ */
+func setCommandHandler(command []string, server *Server) string {
 	key, value := command[1], command[2]
 	expiration := getExpiration(command)
 
-	store.Set(key, []byte(value), expiration)
+	server.Store.Set(key, []byte(value), expiration)
+	if expiration != nil {
+		server.propagateAs([]string{SET, key, value, "PXAT", strconv.FormatInt(expiration.UnixMilli(), 10)})
+	}
 
 	return resp.RESPSerializeSimpleString("OK")
 
 }
 
+// DEL key [key ...]. Nothing is propagated when none of the keys existed
+func delCommandHandler(command []string, server *Server) string {
+	if len(command) < 2 {
+		return wrongNumberOfArguments(DEL)
+	}
+
+	deleted := server.Store.Delete(command[1:]...)
+	if deleted == 0 {
+		server.propagateAs()
+	}
+	return resp.RESPSerializeInteger(deleted)
+}
+
+/*
+* EXPIRE key seconds / PEXPIRE key milliseconds. Returns 1 if the timeout was set and 0 if the key does not exist
+ */
+func expireCommandHandler(command []string, server *Server, unit time.Duration) string {
+	if len(command) != 3 {
+		return wrongNumberOfArguments(command[0])
+	}
+
+	ttl, err := strconv.ParseInt(command[2], 10, 64)
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+
+	return setExpiration(command[1], time.Now().Add(time.Duration(ttl)*unit), server)
+}
+
+/*
+* EXPIREAT key unix-time-seconds / PEXPIREAT key unix-time-milliseconds. Unlike EXPIRE the expiration
+* does not depend on when the command runs, which is why the AOF stores expirations this way
+ */
+func expireAtCommandHandler(command []string, server *Server, unit time.Duration) string {
+	if len(command) != 3 {
+		return wrongNumberOfArguments(command[0])
+	}
+
+	at, err := strconv.ParseInt(command[2], 10, 64)
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+
+	return setExpiration(command[1], time.UnixMilli(at*int64(unit/time.Millisecond)), server)
+}
+
+/*
+* Every form of EXPIRE is propagated as PEXPIREAT, or as DEL when the expiration is in the past
+* and the key was deleted right away
+ */
+func setExpiration(key string, expiration time.Time, server *Server) string {
+	if !server.Store.Expire(key, expiration) {
+		server.propagateAs()
+		return resp.RESPSerializeInteger(0)
+	}
+
+	if time.Now().Before(expiration) {
+		server.propagateAs([]string{PEXPIREAT, key, strconv.FormatInt(expiration.UnixMilli(), 10)})
+	} else {
+		server.propagateAs([]string{DEL, key})
+	}
+	return resp.RESPSerializeInteger(1)
+}
+
 func getCommandHandler(command []string, store *persistence.Store) string {
 	key := command[1]
 
+	if t, ok := store.Type(key); ok && t != persistence.TypeString {
+		return resp.RESPSerializeError(persistence.ErrWrongType.Error())
+	}
+
 	if val, ok := store.Get(key); ok {
 		return resp.RESPSerializeSimpleString(string(val))
 	}
@@ -166,29 +404,39 @@ func getCommandHandler(command []string, store *persistence.Store) string {
 	return resp.RESPNil
 }
 
-// getExpiration checks for an expiration value in the command array.
-// Returns the expiration time and a boolean indicating if an expiration was found.
-func getExpiration(command []string) *time.Time {
-	for i, cmd := range command {
-		if strings.ToUpper(cmd) == "PX" {
-			// Ensure there's a value after "PX"
-			if i+1 >= len(command) {
-				slog.Info("Encountered PX but no expiration value", "info", command)
-				return nil
-			}
+func typeCommandHandler(command []string, store *persistence.Store) string {
+	t, ok := store.Type(command[1])
+	if !ok {
+		return resp.RESPSerializeSimpleString("none")
+	}
+	return resp.RESPSerializeSimpleString(t.String())
+}
 
-			// Convert the next argument to an integer (milliseconds)
-			exp, err := strconv.Atoi(command[i+1])
-			if err != nil {
-				slog.Info("Invalid expiration value:", "info", command[i+1])
-				return nil
-			}
+/*
+* Reads the expiration option of SET: EX seconds, PX milliseconds, EXAT unix-time-seconds or
+* PXAT unix-time-milliseconds. Returns nil if there is none or it is invalid
+ */
+func getExpiration(command []string) *time.Time {
+	for i := 3; i+1 < len(command); i++ {
+		n, err := strconv.ParseInt(command[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
 
-			// Convert milliseconds to duration and calculate future time
-			duration := time.Duration(exp) * time.Millisecond
-			expiration := time.Now().Add(duration)
-			return &expiration
+		var expiration time.Time
+		switch strings.ToUpper(command[i]) {
+		case "EX":
+			expiration = time.Now().Add(time.Duration(n) * time.Second)
+		case "PX":
+			expiration = time.Now().Add(time.Duration(n) * time.Millisecond)
+		case "EXAT":
+			expiration = time.Unix(n, 0)
+		case "PXAT":
+			expiration = time.UnixMilli(n)
+		default:
+			continue
 		}
+		return &expiration
 	}
 
 	// No expiration found
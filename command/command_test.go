@@ -0,0 +1,58 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+func newTestServer() *Server {
+	config := map[string]string{
+		"dir":                   "",
+		"dbfilename":            "dump.rdb",
+		"maxmemory":             "0",
+		"replica-read-only":     "yes",
+		"min-replicas-to-write": "0",
+		"min-replicas-max-lag":  "10",
+	}
+	return NewServer(persistence.NewStore(), config, map[string]string{"replicaof": ""})
+}
+
+// Runs a command as a client would, returning the reply
+func run(t *testing.T, server *Server, c *client.Client, command ...string) string {
+	t.Helper()
+	response, err := CacheCommandHandler(command, c, server)
+	if err != nil {
+		t.Fatalf("%q failed: %v", command, err)
+	}
+	return response
+}
+
+func TestConfigCommand(t *testing.T) {
+	tests := []struct {
+		command  []string
+		response string
+	}{
+		{[]string{"CONFIG", "GET", "maxmemory"}, resp.RESPSerializeRESPArray([]string{"maxmemory", "0"})},
+		{[]string{"config", "get", "MaxMemory"}, resp.RESPSerializeRESPArray([]string{"maxmemory", "0"})},
+		{[]string{"CONFIG", "GET", "nosuch"}, resp.RESPNil},
+		{[]string{"CONFIG", "GET"}, resp.RESPSerializeError("ERR wrong number of arguments for 'config|get' command")},
+		{[]string{"config", "set", "maxmemory", "1mb"}, resp.RESPSerializeSimpleString("OK")},
+		{[]string{"CONFIG", "SET", "maxmemory"}, resp.RESPSerializeError("ERR wrong number of arguments for 'config|set' command")},
+		{[]string{"CONFIG", "RESETSTAT"}, resp.RESPSerializeError("ERR unknown subcommand 'RESETSTAT'. Try CONFIG HELP.")},
+		{[]string{"CONFIG"}, resp.RESPSerializeError("ERR wrong number of arguments for 'config' command")},
+	}
+
+	server := newTestServer()
+	c := client.NewClient(nil, nil, nil)
+	for _, test := range tests {
+		if response := run(t, server, c, test.command...); response != test.response {
+			t.Errorf("%q: expected %q, got %q", test.command, test.response, response)
+		}
+	}
+	if response := run(t, server, c, "CONFIG", "GET", "maxmemory"); response != resp.RESPSerializeRESPArray([]string{"maxmemory", "1048576"}) {
+		t.Errorf("Expected maxmemory to be set, got %q", response)
+	}
+}
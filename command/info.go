@@ -0,0 +1,148 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Sections returned by INFO when no section (or "all") is requested, in order
+var infoSections = []string{"memory", "persistence", "replication", "cluster"}
+
+/*
+* INFO [section ...]. Every section starts with a "# Section" header followed by field:value lines
+ */
+func infoCommandHandler(command []string, server *Server) (string, error) {
+	requested := map[string]bool{}
+	for _, section := range command[1:] {
+		requested[strings.ToLower(section)] = true
+	}
+	all := len(requested) == 0 || requested["all"] || requested["default"] || requested["everything"]
+
+	info := []string{}
+	for _, section := range infoSections {
+		if !all && !requested[section] {
+			continue
+		}
+
+		switch section {
+		case "memory":
+			info = append(info, memoryInfo(server))
+		case "persistence":
+			info = append(info, persistenceInfo(server))
+		case "replication":
+			info = append(info, replicationInfo(server))
+		case "cluster":
+			info = append(info, clusterInfo(server))
+		}
+	}
+
+	return resp.RESPSerializeBulkString(strings.Join(info, "\r\n")), nil
+}
+
+func memoryInfo(server *Server) string {
+	store := server.Store
+	stats := store.MemoryStats()
+	serverMem := collectServerMemory(server)
+	return formatInfoSection("Memory", [][2]string{
+		{"used_memory", fmt.Sprintf("%d", stats.Used+serverMem.total())},
+		{"used_memory_peak", fmt.Sprintf("%d", stats.Peak+serverMem.total())},
+		{"used_memory_overhead", fmt.Sprintf("%d", stats.OverheadBytes+serverMem.total())},
+		{"used_memory_dataset", fmt.Sprintf("%d", stats.DatasetBytes)},
+		{"maxmemory", fmt.Sprintf("%d", store.MaxMemory())},
+		{"maxmemory_policy", string(store.EvictionPolicy())},
+		{"evicted_keys", fmt.Sprintf("%d", store.EvictedKeys())},
+	})
+}
+
+func persistenceInfo(server *Server) string {
+	bgsaveInProgress, lastStatus := "0", "ok"
+	if server.Saver.InProgress() {
+		bgsaveInProgress = "1"
+	}
+	if server.Saver.LastError() != nil {
+		lastStatus = "err"
+	}
+	aofEnabled, aofStatus := "0", "ok"
+	if server.Aof.Enabled() {
+		aofEnabled = "1"
+	}
+	if server.Aof.LastWriteError() != nil {
+		aofStatus = "err"
+	}
+	rewriteInProgress, rewriteStatus := "0", "ok"
+	if server.Aof.RewriteInProgress() {
+		rewriteInProgress = "1"
+	}
+	if server.Aof.LastRewriteError() != nil {
+		rewriteStatus = "err"
+	}
+
+	return formatInfoSection("Persistence", [][2]string{
+		{"rdb_changes_since_last_save", fmt.Sprintf("%d", server.Store.Dirty())},
+		{"rdb_bgsave_in_progress", bgsaveInProgress},
+		{"rdb_last_save_time", fmt.Sprintf("%d", server.Saver.LastSave().Unix())},
+		{"rdb_last_bgsave_status", lastStatus},
+		{"aof_enabled", aofEnabled},
+		{"aof_last_write_status", aofStatus},
+		{"aof_rewrite_in_progress", rewriteInProgress},
+		{"aof_last_bgrewrite_status", rewriteStatus},
+		{"aof_current_size", fmt.Sprintf("%d", server.Aof.Size())},
+		{"aof_base_size", fmt.Sprintf("%d", server.Aof.BaseSize())},
+	})
+}
+
+func replicationInfo(server *Server) string {
+	fields := [][2]string{{"role", "master"}}
+	if server.ReplicationConfig["replicaof"] != "" {
+		host, port, _ := strings.Cut(server.ReplicationConfig["replicaof"], " ")
+		fields = append([][2]string{
+			{"role", "slave"},
+			{"master_host", host},
+			{"master_port", port},
+		}, server.MasterLink.Info()...)
+		fields = append(fields, [2]string{"slave_repl_offset", strconv.FormatInt(server.Replication.Offset(), 10)})
+	}
+
+	// Replicas attached to this server, a replica can have some too
+	replicas := []*client.Client{}
+	for _, c := range server.Clients.All() {
+		if c.ReplicaState != "" {
+			replicas = append(replicas, c)
+		}
+	}
+
+	fields = append(fields, [2]string{"connected_slaves", strconv.Itoa(len(replicas))})
+	for i, c := range replicas {
+		ip, _, _ := net.SplitHostPort(c.Conn().RemoteAddr().String())
+		lag := int64(time.Since(c.ReplicaAckTime).Seconds())
+		fields = append(fields, [2]string{fmt.Sprintf("slave%d", i), fmt.Sprintf("ip=%s,port=%s,state=%s,offset=%d,lag=%d",
+			ip, c.ReplicaListeningPort, c.ReplicaState, c.ReplicaAckOffset, lag)})
+	}
+	if minReplicasEnabled(server) {
+		fields = append(fields, [2]string{"min_slaves_good_slaves", strconv.Itoa(goodReplicas(server))})
+	}
+	return formatInfoSection("Replication", append(fields, server.Replication.Info()...))
+}
+
+func clusterInfo(server *Server) string {
+	enabled := "0"
+	if server.Cluster != nil {
+		enabled = "1"
+	}
+	return formatInfoSection("Cluster", [][2]string{{"cluster_enabled", enabled}})
+}
+
+func formatInfoSection(name string, fields [][2]string) string {
+	section := fmt.Sprintf("# %s\r\n", name)
+	for _, field := range fields {
+		section += fmt.Sprintf("%s:%s\r\n", field[0], field[1])
+	}
+	return section
+}
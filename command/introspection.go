@@ -0,0 +1,189 @@
+package command
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	OBJECT = "OBJECT"
+	MEMORY = "MEMORY"
+)
+
+/*
+* OBJECT ENCODING | IDLETIME | FREQ | REFCOUNT key
+ */
+func objectCommandHandler(command []string, server *Server) string {
+	subcommand := strings.ToUpper(command[1])
+	if len(command) != 3 {
+		return wrongNumberOfArguments("object|" + strings.ToLower(subcommand))
+	}
+
+	info, ok := server.Store.Object(command[2])
+	if !ok {
+		return resp.RESPNil
+	}
+
+	policy := server.Store.EvictionPolicy()
+	lfu := policy == persistence.AllKeysLFU || policy == persistence.VolatileLFU
+
+	switch subcommand {
+	case "ENCODING":
+		return resp.RESPSerializeBulkString(info.Encoding)
+	case "REFCOUNT":
+		return resp.RESPSerializeInteger(info.RefCount)
+	case "IDLETIME":
+		if lfu {
+			return resp.RESPSerializeError("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return resp.RESPSerializeInteger(int(info.Idle.Seconds()))
+	case "FREQ":
+		if !lfu {
+			return resp.RESPSerializeError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return resp.RESPSerializeInteger(int(info.Freq))
+	default:
+		return resp.RESPSerializeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try OBJECT HELP.", command[1]))
+	}
+}
+
+/*
+* MEMORY USAGE key [SAMPLES count] | STATS | DOCTOR
+ */
+func memoryCommandHandler(command []string, server *Server) string {
+	switch strings.ToUpper(command[1]) {
+	case "USAGE":
+		// SAMPLES is accepted for compatibility, the size of a string is always known exactly
+		if len(command) != 3 && !(len(command) == 5 && strings.ToUpper(command[3]) == "SAMPLES") {
+			return resp.RESPSerializeError("ERR syntax error")
+		}
+		info, ok := server.Store.Object(command[2])
+		if !ok {
+			return resp.RESPNil
+		}
+		return resp.RESPSerializeInteger(int(info.Size))
+	case "STATS":
+		return memoryStatsHandler(server)
+	case "DOCTOR":
+		return resp.RESPSerializeBulkString(memoryDoctor(server))
+	default:
+		return resp.RESPSerializeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try MEMORY HELP.", command[1]))
+	}
+}
+
+// Memory used by the server on top of the dataset
+type serverMemory struct {
+	replicationBacklog int64
+	clientsReplicas    int64
+	clientsNormal      int64
+}
+
+func (m serverMemory) total() int64 {
+	return m.replicationBacklog + m.clientsReplicas + m.clientsNormal
+}
+
+/*
+* The backlog as allocated, and the buffers of the clients: every connection has a read buffer
+* and replicas hold the writes propagated while they load the RDB
+ */
+func collectServerMemory(server *Server) serverMemory {
+	mem := serverMemory{replicationBacklog: int64(server.Replication.BacklogMemory())}
+	for _, c := range server.Clients.All() {
+		if c.ReplicaState != "" {
+			mem.clientsReplicas += client.ReadBufferSize + c.ReplicaPending
+			continue
+		}
+		mem.clientsNormal += client.ReadBufferSize
+	}
+	return mem
+}
+
+func memoryStatsHandler(server *Server) string {
+	stats := server.Store.MemoryStats()
+	serverMem := collectServerMemory(server)
+
+	var runtimeStats runtime.MemStats
+	runtime.ReadMemStats(&runtimeStats)
+
+	total := stats.Used + serverMem.total()
+	bytesPerKey := int64(0)
+	if stats.Keys > 0 {
+		bytesPerKey = stats.Used / int64(stats.Keys)
+	}
+
+	integer := func(n int64) string { return resp.RESPSerializeInteger(int(n)) }
+	percentage := func(part int64, whole int64) string {
+		if whole == 0 {
+			return resp.RESPSerializeBulkString("0")
+		}
+		return resp.RESPSerializeBulkString(strconv.FormatFloat(float64(part)*100/float64(whole), 'f', 2, 64))
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"peak.allocated", integer(stats.Peak + serverMem.total())},
+		{"total.allocated", integer(total)},
+		{"replication.backlog", integer(serverMem.replicationBacklog)},
+		{"clients.slaves", integer(serverMem.clientsReplicas)},
+		{"clients.normal", integer(serverMem.clientsNormal)},
+		{"db.0", resp.RESPSerializeRawArray([]string{
+			resp.RESPSerializeBulkString("overhead.hashtable.main"), integer(int64(stats.Keys) * persistence.EntryOverhead),
+			resp.RESPSerializeBulkString("overhead.hashtable.expires"), integer(int64(stats.Expires) * persistence.ExpireOverhead),
+		})},
+		{"overhead.total", integer(stats.OverheadBytes + serverMem.total())},
+		{"keys.count", integer(int64(stats.Keys))},
+		{"keys.bytes-per-key", integer(bytesPerKey)},
+		{"dataset.bytes", integer(stats.DatasetBytes)},
+		{"dataset.percentage", percentage(stats.DatasetBytes, total)},
+		{"peak.percentage", percentage(total, stats.Peak+serverMem.total())},
+		{"allocator.allocated", integer(int64(runtimeStats.HeapAlloc))},
+		{"allocator.resident", integer(int64(runtimeStats.Sys))},
+	}
+
+	elements := []string{}
+	for _, field := range fields {
+		elements = append(elements, resp.RESPSerializeBulkString(field.name), field.value)
+	}
+	return resp.RESPSerializeRawArray(elements)
+}
+
+// Looks for common memory problems and explains them in plain text
+func memoryDoctor(server *Server) string {
+	stats := server.Store.MemoryStats()
+	if stats.Keys == 0 {
+		return "The dataset is empty, there is nothing to diagnose yet."
+	}
+
+	issues := []string{}
+	if stats.Peak > stats.Used*3/2 {
+		issues = append(issues, fmt.Sprintf(
+			" * Peak memory: the peak memory usage (%d bytes) is more than 150%% of the memory currently used (%d bytes). "+
+				"Memory freed by deleted or evicted keys may not be returned to the operating system right away.",
+			stats.Peak, stats.Used))
+	}
+	if stats.OverheadBytes > stats.DatasetBytes {
+		issues = append(issues, fmt.Sprintf(
+			" * High overhead: %d bytes are spent on bookkeeping for only %d bytes of keys and values. "+
+				"Many very small keys are expensive, consider grouping them.",
+			stats.OverheadBytes, stats.DatasetBytes))
+	}
+	maxMemory := server.Store.MaxMemory()
+	if maxMemory > 0 && stats.Used > maxMemory*9/10 && server.Store.EvictionPolicy() == persistence.NoEviction {
+		issues = append(issues, fmt.Sprintf(
+			" * Close to maxmemory: %d of %d bytes are used and maxmemory-policy is noeviction, writes will soon be rejected with OOM errors.",
+			stats.Used, maxMemory))
+	}
+
+	if len(issues) == 0 {
+		return "No memory problems detected, use MEMORY USAGE <key> to inspect individual keys."
+	}
+	return "Found the following memory issues:\n\n" + strings.Join(issues, "\n\n") + "\n"
+}
@@ -0,0 +1,365 @@
+package command
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	DUMP           = "DUMP"
+	RESTORE        = "RESTORE"
+	RESTORE_ASKING = "RESTORE-ASKING"
+	MIGRATE        = "MIGRATE"
+)
+
+const (
+	// Connections MIGRATE keeps open to its targets, and how long an unused one stays open
+	maxMigrateConns    = 64
+	migrateConnTimeout = 10 * time.Second
+	// Timeout of MIGRATE when it is given none
+	defaultMigrateTimeout = time.Second
+)
+
+// DUMP key: the value of the key serialized in the RDB format, to be given to RESTORE
+func dumpCommandHandler(command []string, server *Server) string {
+	entry, ok := server.Store.Entry(command[1])
+	if !ok {
+		return resp.RESPNil
+	}
+	return resp.RESPSerializeBulkString(string(persistence.DumpEntry(entry)))
+}
+
+/*
+* RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]. ttl is
+* in milliseconds, 0 for no expiration, or a unix time in milliseconds with ABSTTL. A relative ttl
+* is propagated with ABSTTL so replicas and the AOF expire the key at the same time
+ */
+func restoreCommandHandler(command []string, server *Server) string {
+	key := command[1]
+	ttl, err := strconv.ParseInt(command[2], 10, 64)
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+	if ttl < 0 {
+		return resp.RESPSerializeError("ERR Invalid TTL value, must be >= 0")
+	}
+
+	replace, absTTL := false, false
+	idle, freq := time.Duration(-1), -1
+	for i := 4; i < len(command); i++ {
+		option := strings.ToUpper(command[i])
+		hasArg := i+1 < len(command)
+		switch {
+		case option == "REPLACE":
+			replace = true
+		case option == "ABSTTL":
+			absTTL = true
+		case option == "IDLETIME" && hasArg && freq < 0:
+			seconds, err := strconv.ParseInt(command[i+1], 10, 64)
+			if err != nil {
+				return resp.RESPSerializeError(notIntegerError)
+			}
+			if seconds < 0 {
+				return resp.RESPSerializeError("ERR Invalid IDLETIME value, must be >= 0")
+			}
+			idle = time.Duration(seconds) * time.Second
+			i++
+		case option == "FREQ" && hasArg && idle < 0:
+			f, err := strconv.Atoi(command[i+1])
+			if err != nil {
+				return resp.RESPSerializeError(notIntegerError)
+			}
+			if f < 0 || f > 255 {
+				return resp.RESPSerializeError("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			freq = f
+			i++
+		default:
+			return resp.RESPSerializeError("ERR syntax error")
+		}
+	}
+
+	if _, exists := server.Store.Type(key); exists && !replace {
+		return resp.RESPSerializeError("BUSYKEY Target key name already exists.")
+	}
+	entry, err := persistence.ParseDumpPayload([]byte(command[3]))
+	if err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+
+	if ttl > 0 {
+		expiration := time.UnixMilli(ttl)
+		if !absTTL {
+			expiration = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		// A key restored already expired is not created, the one it replaces is deleted
+		if !expiration.After(time.Now()) {
+			if replace && server.Store.Delete(key) > 0 {
+				server.propagateAs([]string{DEL, key})
+			} else {
+				server.propagateAs()
+			}
+			return resp.RESPSerializeSimpleString("OK")
+		}
+		entry.Expiration = &expiration
+
+		if !absTTL {
+			propagated := append([]string{}, command...)
+			propagated[2] = strconv.FormatInt(expiration.UnixMilli(), 10)
+			server.propagateAs(append(propagated, "ABSTTL"))
+		}
+	}
+
+	entry.Key = key
+	server.Store.Restore(entry, idle, freq)
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+/*
+* A connection MIGRATE opened to a target, reused by the next MIGRATE to the same target. It
+* remembers how it was authenticated and the database it selected, so they are only sent when
+* they change
+ */
+type migrateConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	lastUse time.Time
+	auth    []string
+	db      int
+}
+
+/*
+* MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password]
+* [AUTH2 username password] [KEYS key [key ...]]. Moves keys to another instance with RESTORE and
+* deletes them here unless COPY is given. If the target fails to restore a key none is deleted, and
+* MIGRATE can be retried with REPLACE. destination-db is a database of the target, selected there
+ */
+func migrateCommandHandler(command []string, server *Server) string {
+	host, port, key := command[1], command[2], command[3]
+	db, err := strconv.Atoi(command[4])
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+	timeoutMs, err := strconv.ParseInt(command[5], 10, 64)
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+
+	copyKeys, replace := false, false
+	var auth []string
+	keys := []string{key}
+	for i := 6; i < len(command); i++ {
+		switch option := strings.ToUpper(command[i]); {
+		case option == "COPY":
+			copyKeys = true
+		case option == "REPLACE":
+			replace = true
+		case option == "AUTH" && i+1 < len(command):
+			auth = []string{"AUTH", command[i+1]}
+			i++
+		case option == "AUTH2" && i+2 < len(command):
+			auth = []string{"AUTH", command[i+1], command[i+2]}
+			i += 2
+		case option == "KEYS":
+			if key != "" {
+				return resp.RESPSerializeError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = command[i+1:]
+			i = len(command)
+		default:
+			return resp.RESPSerializeError("ERR syntax error")
+		}
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultMigrateTimeout
+	}
+
+	entries := []persistence.Entry{}
+	for _, key := range keys {
+		if entry, ok := server.Store.Entry(key); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return resp.RESPSerializeSimpleString("NOKEY")
+	}
+
+	// A target importing the slot only accepts the keys of its importing slots with ASKING
+	restore := RESTORE
+	if server.Cluster != nil {
+		restore = RESTORE_ASKING
+	}
+	restores := ""
+	for _, entry := range entries {
+		ttl := int64(0)
+		if entry.Expiration != nil {
+			ttl = max(time.Until(*entry.Expiration).Milliseconds(), 1)
+		}
+		restoreCommand := []string{restore, entry.Key, strconv.FormatInt(ttl, 10), string(persistence.DumpEntry(entry))}
+		if replace {
+			restoreCommand = append(restoreCommand, "REPLACE")
+		}
+		restores += resp.RESPSerializeRESPArray(restoreCommand)
+	}
+
+	replies, errResponse := server.migrateRequest(net.JoinHostPort(host, port), auth, db, restores, len(entries), timeout)
+	if errResponse != "" {
+		server.propagateAs()
+		return resp.RESPSerializeError(errResponse)
+	}
+	for _, reply := range replies {
+		if strings.HasPrefix(reply, resp.RESPError) {
+			server.propagateAs()
+			return resp.RESPSerializeError("ERR Target instance replied with error: " + reply[1:])
+		}
+	}
+
+	if copyKeys {
+		server.propagateAs()
+		return resp.RESPSerializeSimpleString("OK")
+	}
+	migrated := []string{}
+	for _, entry := range entries {
+		migrated = append(migrated, entry.Key)
+	}
+	server.Store.Delete(migrated...)
+	server.propagateAs(append([]string{DEL}, migrated...))
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+/*
+* Sends the RESTOREs of a MIGRATE to the target and reads a reply for each of them. AUTH and
+* SELECT are sent first when the connection is not authenticated or on the database yet, and no
+* key is restored unless the target accepted them. A cached connection may have been closed by the
+* target since it was last used, the request is sent again on a new connection once if it fails
+* before any reply was read, unless it timed out
+ */
+func (s *Server) migrateRequest(addr string, auth []string, db int, restores string, commands int, timeout time.Duration) ([]string, string) {
+	for attempt := 0; ; attempt++ {
+		mc, cached, err := s.migrateConn(addr, timeout)
+		if err != nil {
+			return nil, "IOERR error or timeout connecting to the client"
+		}
+		retry := cached && attempt == 0
+		mc.conn.SetDeadline(time.Now().Add(timeout))
+
+		setup, setupCommands := "", 0
+		if auth != nil && !slices.Equal(auth, mc.auth) {
+			setup += resp.RESPSerializeRESPArray(auth)
+			setupCommands++
+		}
+		if db != mc.db {
+			setup += resp.RESPSerializeRESPArray([]string{"SELECT", strconv.Itoa(db)})
+			setupCommands++
+		}
+		if setupCommands > 0 {
+			replies, errResponse, again := s.migrateRoundTrip(addr, mc, setup, setupCommands, retry)
+			if again {
+				continue
+			}
+			if errResponse != "" {
+				return nil, errResponse
+			}
+			// The connection is not reused, it may be unauthenticated or on another database
+			for _, reply := range replies {
+				if strings.HasPrefix(reply, resp.RESPError) {
+					s.closeMigrateConn(addr)
+					return replies, ""
+				}
+			}
+			if auth != nil {
+				mc.auth = auth
+			}
+			mc.db, retry = db, false
+		}
+
+		replies, errResponse, again := s.migrateRoundTrip(addr, mc, restores, commands, retry)
+		if again {
+			continue
+		}
+		if errResponse != "" {
+			return nil, errResponse
+		}
+		mc.conn.SetDeadline(time.Time{})
+		return replies, ""
+	}
+}
+
+/*
+* Writes commands to the target and reads their replies. Reports when a request that may be retried
+* failed before any reply was read, the connection is closed on any error
+ */
+func (s *Server) migrateRoundTrip(addr string, mc *migrateConn, request string, commands int, retry bool) ([]string, string, bool) {
+	if _, err := mc.conn.Write([]byte(request)); err != nil {
+		s.closeMigrateConn(addr)
+		if retry {
+			return nil, "", true
+		}
+		return nil, "IOERR error or timeout writing to target instance", false
+	}
+
+	replies := []string{}
+	for len(replies) < commands {
+		line, err := mc.reader.ReadString('\n')
+		if err != nil {
+			s.closeMigrateConn(addr)
+			if len(replies) == 0 && retry && !errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, "", true
+			}
+			return nil, "IOERR error or timeout reading to target instance", false
+		}
+		replies = append(replies, strings.TrimSuffix(line, "\r\n"))
+	}
+	return replies, "", false
+}
+
+// The cached connection to the target, or a new one. The oldest connection is closed to make room
+func (s *Server) migrateConn(addr string, timeout time.Duration) (*migrateConn, bool, error) {
+	if mc, ok := s.migrateConns[addr]; ok {
+		mc.lastUse = time.Now()
+		return mc, true, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(s.migrateConns) >= maxMigrateConns {
+		oldest := ""
+		for cachedAddr, mc := range s.migrateConns {
+			if oldest == "" || mc.lastUse.Before(s.migrateConns[oldest].lastUse) {
+				oldest = cachedAddr
+			}
+		}
+		s.closeMigrateConn(oldest)
+	}
+	mc := &migrateConn{conn: conn, reader: bufio.NewReader(conn), lastUse: time.Now()}
+	s.migrateConns[addr] = mc
+	return mc, false, nil
+}
+
+func (s *Server) closeMigrateConn(addr string) {
+	if mc, ok := s.migrateConns[addr]; ok {
+		mc.conn.Close()
+		delete(s.migrateConns, addr)
+	}
+}
+
+// Closes the connections of MIGRATE that were not used for a while, called by the server cron
+func (s *Server) CloseIdleMigrateConns() {
+	for addr, mc := range s.migrateConns {
+		if time.Since(mc.lastUse) > migrateConnTimeout {
+			s.closeMigrateConn(addr)
+		}
+	}
+}
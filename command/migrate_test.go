@@ -0,0 +1,141 @@
+package command
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// A target of MIGRATE that records the commands it receives, its password is "secret" and it has 4 databases
+type migrateTarget struct {
+	l        net.Listener
+	commands []string
+
+	mu sync.Mutex
+}
+
+func newMigrateTarget(t *testing.T) *migrateTarget {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &migrateTarget{l: l}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go target.serve(conn)
+		}
+	}()
+	return target
+}
+
+func (m *migrateTarget) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := resp.NewReader(conn)
+	for {
+		command, _, err := reader.ReadCommand()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		m.commands = append(m.commands, strings.Join(command[:min(len(command), 3)], " "))
+		m.mu.Unlock()
+
+		reply := resp.RESPSerializeSimpleString("OK")
+		switch {
+		case command[0] == "AUTH" && command[len(command)-1] != "secret":
+			reply = resp.RESPSerializeError("WRONGPASS invalid username-password pair")
+		case command[0] == "SELECT" && command[1] > "3":
+			reply = resp.RESPSerializeError("ERR DB index is out of range")
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+// Commands received since the last call
+func (m *migrateTarget) received() []string {
+	defer m.mu.Unlock()
+
+	m.mu.Lock()
+	commands := m.commands
+	m.commands = nil
+	return commands
+}
+
+func TestMigrateAuthAndSelect(t *testing.T) {
+	target := newMigrateTarget(t)
+	host, port, _ := net.SplitHostPort(target.l.Addr().String())
+
+	tests := []struct {
+		name     string
+		options  []string
+		response string
+		received []string
+	}{
+		{
+			name:     "authenticates and selects the database on a new connection",
+			options:  []string{"3", "1000", "AUTH", "secret"},
+			response: resp.RESPSerializeSimpleString("OK"),
+			received: []string{"AUTH secret", "SELECT 3", "RESTORE key 0"},
+		},
+		{
+			name:     "reuses the authenticated connection and its database",
+			options:  []string{"3", "1000", "AUTH", "secret"},
+			response: resp.RESPSerializeSimpleString("OK"),
+			received: []string{"RESTORE key 0"},
+		},
+		{
+			name:     "selects another database",
+			options:  []string{"0", "1000", "AUTH", "secret"},
+			response: resp.RESPSerializeSimpleString("OK"),
+			received: []string{"SELECT 0", "RESTORE key 0"},
+		},
+		{
+			name:     "AUTH2 sends the username",
+			options:  []string{"0", "1000", "AUTH2", "user", "wrong"},
+			response: resp.RESPSerializeError("ERR Target instance replied with error: WRONGPASS invalid username-password pair"),
+			received: []string{"AUTH user wrong"},
+		},
+		{
+			name:     "a connection that failed to authenticate is not reused",
+			options:  []string{"0", "1000", "AUTH", "secret"},
+			response: resp.RESPSerializeSimpleString("OK"),
+			received: []string{"AUTH secret", "RESTORE key 0"},
+		},
+		{
+			name:     "no key is restored in a database the target does not have",
+			options:  []string{"9", "1000", "AUTH", "secret"},
+			response: resp.RESPSerializeError("ERR Target instance replied with error: ERR DB index is out of range"),
+			received: []string{"SELECT 9"},
+		},
+		{
+			name:     "a connection that failed to select the database is not reused",
+			options:  []string{"0", "1000", "AUTH", "secret"},
+			response: resp.RESPSerializeSimpleString("OK"),
+			received: []string{"AUTH secret", "RESTORE key 0"},
+		},
+	}
+
+	server := newTestServer()
+	c := client.NewClient(nil, nil, nil)
+	for _, test := range tests {
+		run(t, server, c, "SET", "key", "value")
+		command := append([]string{"MIGRATE", host, port, "key"}, test.options...)
+		if response := run(t, server, c, command...); response != test.response {
+			t.Errorf("%s: expected %q, got %q", test.name, test.response, response)
+		}
+		if received := target.received(); strings.Join(received, ", ") != strings.Join(test.received, ", ") {
+			t.Errorf("%s: expected the target to receive %q, got %q", test.name, test.received, received)
+		}
+	}
+}
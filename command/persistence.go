@@ -0,0 +1,41 @@
+package command
+
+import (
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	SAVE         = "SAVE"
+	BGSAVE       = "BGSAVE"
+	LASTSAVE     = "LASTSAVE"
+	BGREWRITEAOF = "BGREWRITEAOF"
+)
+
+// Saves the dataset to disk, blocking every other client until it is done
+func saveCommandHandler(server *Server) string {
+	if err := server.Saver.Save(); err != nil {
+		return resp.RESPSerializeError(err.Error())
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// Saves the dataset to disk in the background
+func bgsaveCommandHandler(server *Server) string {
+	if err := server.Saver.BackgroundSave(); err != nil {
+		return resp.RESPSerializeError(err.Error())
+	}
+	return resp.RESPSerializeSimpleString("Background saving started")
+}
+
+// Unix time of the last successful save
+func lastsaveCommandHandler(server *Server) string {
+	return resp.RESPSerializeInteger(int(server.Saver.LastSave().Unix()))
+}
+
+// Rewrites the AOF in the background, also allowed while the AOF is disabled
+func bgrewriteaofCommandHandler(server *Server) string {
+	if err := server.Aof.Rewrite(); err != nil {
+		return resp.RESPSerializeError(err.Error())
+	}
+	return resp.RESPSerializeSimpleString("Background append only file rewriting started")
+}
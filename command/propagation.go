@@ -0,0 +1,47 @@
+package command
+
+import "strings"
+
+// Wrap the commands a single command propagates, so replicas and the AOF apply them atomically
+const (
+	MULTI = "MULTI"
+	EXEC  = "EXEC"
+)
+
+/*
+* Called by handlers whose effect has to be propagated differently than the command was sent
+* (relative expirations, random or floating point results). With no commands nothing is propagated
+ */
+func (s *Server) propagateAs(commands ...[]string) {
+	s.propagation, s.rewritten = commands, true
+}
+
+/*
+* Commands to propagate to the AOF and the replicas for the command that just ran: what its handler
+* asked for, otherwise the command itself if it is a write. Several commands are wrapped in
+* MULTI/EXEC. Every argument is kept as is, the caller serializes them in canonical RESP
+ */
+func (s *Server) Propagation(command []string) [][]string {
+	commands := s.propagation
+	if !s.rewritten {
+		commands = nil
+		if IsWrite(command) {
+			commands = [][]string{command}
+		}
+	}
+	s.propagation, s.rewritten = nil, false
+
+	if len(commands) > 1 {
+		commands = append([][]string{{MULTI}}, append(commands, []string{EXEC})...)
+	}
+	return commands
+}
+
+// Whether the command starts or ends a transaction in the replication stream or the AOF
+func IsMulti(command []string) bool {
+	return strings.ToUpper(command[0]) == MULTI
+}
+
+func IsExec(command []string) bool {
+	return strings.ToUpper(command[0]) == EXEC
+}
@@ -0,0 +1,152 @@
+package command
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+)
+
+/*
+* Checks the propagated commands against the expected ones. An expected argument "+<duration>" is
+* a unix time in milliseconds that duration after the command ran
+ */
+func matchPropagation(got [][]string, want [][]string, before time.Time, after time.Time) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			return false
+		}
+		for j, arg := range want[i] {
+			if !strings.HasPrefix(arg, "+") {
+				if got[i][j] != arg {
+					return false
+				}
+				continue
+			}
+			d, _ := time.ParseDuration(arg[1:])
+			at, err := strconv.ParseInt(got[i][j], 10, 64)
+			if err != nil || at < before.Add(d).UnixMilli() || at > after.Add(d).UnixMilli() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestPropagation(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      [][]string
+		command    []string
+		propagated [][]string
+	}{
+		{
+			name:       "a write is propagated as is",
+			command:    []string{"SET", "key", "value"},
+			propagated: [][]string{{"SET", "key", "value"}},
+		},
+		{
+			name:    "a read is not propagated",
+			setup:   [][]string{{"SET", "key", "value"}},
+			command: []string{"GET", "key"},
+		},
+		{
+			name:       "SET EX becomes PXAT",
+			command:    []string{"SET", "key", "value", "EX", "100"},
+			propagated: [][]string{{"SET", "key", "value", "PXAT", "+100s"}},
+		},
+		{
+			name:       "SET PX becomes PXAT",
+			command:    []string{"SET", "key", "value", "PX", "1500"},
+			propagated: [][]string{{"SET", "key", "value", "PXAT", "+1500ms"}},
+		},
+		{
+			name:       "a relative EXPIRE becomes PEXPIREAT",
+			setup:      [][]string{{"SET", "key", "value"}},
+			command:    []string{"EXPIRE", "key", "100"},
+			propagated: [][]string{{"PEXPIREAT", "key", "+100s"}},
+		},
+		{
+			name:       "a relative PEXPIRE becomes PEXPIREAT",
+			setup:      [][]string{{"SET", "key", "value"}},
+			command:    []string{"PEXPIRE", "key", "2500"},
+			propagated: [][]string{{"PEXPIREAT", "key", "+2500ms"}},
+		},
+		{
+			name:       "EXPIREAT becomes PEXPIREAT",
+			setup:      [][]string{{"SET", "key", "value"}},
+			command:    []string{"EXPIREAT", "key", "4102444800"},
+			propagated: [][]string{{"PEXPIREAT", "key", "4102444800000"}},
+		},
+		{
+			name:       "an EXPIRE in the past becomes DEL",
+			setup:      [][]string{{"SET", "key", "value"}},
+			command:    []string{"EXPIRE", "key", "-1"},
+			propagated: [][]string{{"DEL", "key"}},
+		},
+		{
+			name:       "an EXPIREAT in the past becomes DEL",
+			setup:      [][]string{{"SET", "key", "value"}},
+			command:    []string{"EXPIREAT", "key", "1"},
+			propagated: [][]string{{"DEL", "key"}},
+		},
+		{
+			name:    "EXPIRE of a missing key propagates nothing",
+			command: []string{"EXPIRE", "missing", "100"},
+		},
+		{
+			name:    "DEL of a missing key propagates nothing",
+			command: []string{"DEL", "missing"},
+		},
+		{
+			name:       "DEL that removed a key is propagated as is",
+			setup:      [][]string{{"SET", "key", "value"}},
+			command:    []string{"DEL", "key", "missing"},
+			propagated: [][]string{{"DEL", "key", "missing"}},
+		},
+	}
+
+	for _, test := range tests {
+		server := newTestServer()
+		c := client.NewClient(nil, nil, nil)
+		for _, cmd := range test.setup {
+			run(t, server, c, cmd...)
+			server.Propagation(cmd)
+		}
+
+		before := time.Now()
+		run(t, server, c, test.command...)
+		after := time.Now()
+		if propagated := server.Propagation(test.command); !matchPropagation(propagated, test.propagated, before, after) {
+			t.Errorf("%s: expected %q to be propagated, got %q", test.name, test.propagated, propagated)
+		}
+	}
+}
+
+func TestPropagationMultiExec(t *testing.T) {
+	server := newTestServer()
+	command := []string{"MIGRATE", "127.0.0.1", "6380", "", "0", "1000", "KEYS", "a", "b"}
+
+	server.propagateAs([]string{"DEL", "a"}, []string{"DEL", "b"})
+	expected := [][]string{{"MULTI"}, {"DEL", "a"}, {"DEL", "b"}, {"EXEC"}}
+	if propagated := server.Propagation(command); !reflect.DeepEqual(propagated, expected) {
+		t.Fatalf("Expected two effects to be wrapped in MULTI/EXEC, got %q", propagated)
+	}
+
+	server.propagateAs([]string{"DEL", "a", "b"})
+	expected = [][]string{{"DEL", "a", "b"}}
+	if propagated := server.Propagation(command); !reflect.DeepEqual(propagated, expected) {
+		t.Fatalf("Expected a single effect not to be wrapped, got %q", propagated)
+	}
+
+	// What a handler asked for only applies to its own command
+	if propagated := server.Propagation([]string{"GET", "a"}); propagated != nil {
+		t.Fatalf("Expected nothing to be propagated for a read, got %q", propagated)
+	}
+}
@@ -0,0 +1,130 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/cluster"
+	"github.com/jason-gill00/redis-from-scratch/pubsub"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	SUBSCRIBE    = "SUBSCRIBE"
+	UNSUBSCRIBE  = "UNSUBSCRIBE"
+	PSUBSCRIBE   = "PSUBSCRIBE"
+	PUNSUBSCRIBE = "PUNSUBSCRIBE"
+	PUBLISH      = "PUBLISH"
+	SSUBSCRIBE   = "SSUBSCRIBE"
+	SUNSUBSCRIBE = "SUNSUBSCRIBE"
+	SPUBLISH     = "SPUBLISH"
+	PUBSUB       = "PUBSUB"
+	QUIT         = "QUIT"
+	RESET        = "RESET"
+)
+
+// Commands a client is allowed to send while it is subscribed to at least one channel
+var subscribedContextCommands = map[string]bool{
+	SUBSCRIBE:    true,
+	UNSUBSCRIBE:  true,
+	PSUBSCRIBE:   true,
+	PUNSUBSCRIBE: true,
+	SSUBSCRIBE:   true,
+	SUNSUBSCRIBE: true,
+	PING:         true,
+	QUIT:         true,
+	RESET:        true,
+}
+
+/*
+* Handles the pub/sub family of commands. Returns false if the command is not a pub/sub command
+ */
+func pubSubCommandHandler(command []string, c *client.Client, pubSub *pubsub.PubSub) (string, bool) {
+	name := strings.ToUpper(command[0])
+	args := command[1:]
+
+	switch name {
+	case SUBSCRIBE:
+		return pubSub.Subscribe(c, args), true
+	case UNSUBSCRIBE:
+		return pubSub.Unsubscribe(c, args), true
+	case PSUBSCRIBE:
+		return pubSub.PSubscribe(c, args), true
+	case PUNSUBSCRIBE:
+		return pubSub.PUnsubscribe(c, args), true
+	case PUBLISH:
+		return resp.RESPSerializeInteger(pubSub.Publish(args[0], args[1])), true
+	case SSUBSCRIBE:
+		if !sameSlot(args) {
+			return resp.RESPSerializeError(crossSlotError), true
+		}
+		return pubSub.SSubscribe(c, args), true
+	case SUNSUBSCRIBE:
+		if !sameSlot(args) {
+			return resp.RESPSerializeError(crossSlotError), true
+		}
+		return pubSub.SUnsubscribe(c, args), true
+	case SPUBLISH:
+		return resp.RESPSerializeInteger(pubSub.SPublish(args[0], args[1])), true
+	case PUBSUB:
+		return pubSubIntrospectionHandler(args, pubSub), true
+	default:
+		return "", false
+	}
+}
+
+/*
+* PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT | SHARDCHANNELS [pattern] | SHARDNUMSUB [channel ...]
+ */
+func pubSubIntrospectionHandler(args []string, pubSub *pubsub.PubSub) string {
+	subcommand := strings.ToUpper(args[0])
+	switch subcommand {
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 2 {
+			return wrongNumberOfArguments(PUBSUB + "|" + subcommand)
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		return resp.RESPSerializeRESPArray(pubSub.ActiveChannels(pattern, subcommand == "SHARDCHANNELS"))
+	case "NUMSUB", "SHARDNUMSUB":
+		elements := []string{}
+		for _, channel := range args[1:] {
+			count := pubSub.NumSub(channel, subcommand == "SHARDNUMSUB")
+			elements = append(elements, resp.RESPSerializeBulkString(channel), resp.RESPSerializeInteger(count))
+		}
+		return resp.RESPSerializeRawArray(elements)
+	case "NUMPAT":
+		if len(args) != 1 {
+			return wrongNumberOfArguments(PUBSUB + "|" + subcommand)
+		}
+		return resp.RESPSerializeInteger(pubSub.NumPat())
+	default:
+		return resp.RESPSerializeError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0]))
+	}
+}
+
+// Shard channels are scoped to a hash slot, so a single command may only reference channels of one slot
+func sameSlot(channels []string) bool {
+	for _, channel := range channels {
+		if cluster.KeySlot(channel) != cluster.KeySlot(channels[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns an error if the connection is subscribed and the command is not allowed in the subscribed context
+func checkSubscribedContext(command []string, c *client.Client, pubSub *pubsub.PubSub) string {
+	name := strings.ToUpper(command[0])
+	if subscribedContextCommands[name] || pubSub.SubscriptionCount(c) == 0 {
+		return ""
+	}
+
+	return resp.RESPSerializeError(fmt.Sprintf(
+		"ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+		strings.ToLower(command[0]),
+	))
+}
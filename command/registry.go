@@ -0,0 +1,146 @@
+package command
+
+import "strings"
+
+type commandFlag int
+
+const (
+	flagWrite    commandFlag = 1 << iota // May modify the keyspace
+	flagReadOnly                         // Only reads from the keyspace
+	flagDenyOOM                          // May increase memory usage, rejected once maxmemory is reached
+)
+
+/*
+* Describes a command: how many arguments it takes and where its keys are. A negative arity
+* means the command takes at least that many arguments (including the command name).
+* lastKey -1 means the keys go until the last argument. Commands whose keys are not at fixed
+* positions find them with getKeys instead
+ */
+type commandSpec struct {
+	arity    int
+	flags    commandFlag
+	firstKey int
+	lastKey  int
+	step     int
+	getKeys  func(command []string) []string
+}
+
+var commandTable = map[string]commandSpec{
+	PING:         {arity: -1},
+	ECHO:         {arity: 2},
+	SELECT:       {arity: 2},
+	GET:          {arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1},
+	SET:          {arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+	DEL:          {arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1},
+	EXPIRE:       {arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1},
+	PEXPIRE:      {arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1},
+	EXPIREAT:     {arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1},
+	PEXPIREAT:    {arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1},
+	TYPE:         {arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1},
+	CONFIG:       {arity: -2},
+	KEY:          {arity: 2, flags: flagReadOnly},
+	INFO:         {arity: -1},
+	REPLCONF:     {arity: -2},
+	PSYNC:        {arity: -3},
+	SUBSCRIBE:    {arity: -2},
+	UNSUBSCRIBE:  {arity: -1},
+	PSUBSCRIBE:   {arity: -2},
+	PUNSUBSCRIBE: {arity: -1},
+	PUBLISH:      {arity: 3},
+	// Shard channels are routed to the node serving their slot like keys
+	SSUBSCRIBE:   {arity: -2, firstKey: 1, lastKey: -1, step: 1},
+	SUNSUBSCRIBE: {arity: -1, firstKey: 1, lastKey: -1, step: 1},
+	SPUBLISH:     {arity: 3, firstKey: 1, lastKey: 1, step: 1},
+	PUBSUB:       {arity: -2},
+	CLIENT:       {arity: -2},
+	HELLO:        {arity: -1},
+	OBJECT:       {arity: -2, firstKey: 2, lastKey: 2, step: 1},
+	MEMORY:       {arity: -2},
+	SAVE:         {arity: 1},
+	BGSAVE:       {arity: -1},
+	LASTSAVE:     {arity: 1},
+	BGREWRITEAOF: {arity: 1},
+	WAIT:         {arity: 3},
+	WAITAOF:      {arity: 4},
+	REPLICAOF:    {arity: 3},
+	SLAVEOF:      {arity: 3},
+	ROLE:         {arity: 1},
+	CLUSTER:      {arity: -2},
+	READONLY:     {arity: 1},
+	READWRITE:    {arity: 1},
+	ASKING:       {arity: 1},
+	DUMP:         {arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1},
+	RESTORE:      {arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+	// RESTORE sent by MIGRATE to a node importing the slot, as if the client sent ASKING before it
+	RESTORE_ASKING: {arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1},
+	MIGRATE:        {arity: -6, flags: flagWrite, getKeys: migrateKeys},
+}
+
+func lookupCommand(command []string) (commandSpec, bool) {
+	spec, ok := commandTable[strings.ToUpper(command[0])]
+	return spec, ok
+}
+
+// Returns an error response if the command was called with the wrong number of arguments
+func checkArity(command []string) string {
+	spec, ok := lookupCommand(command)
+	if !ok {
+		return ""
+	}
+
+	if (spec.arity > 0 && len(command) != spec.arity) || len(command) < -spec.arity {
+		return wrongNumberOfArguments(command[0])
+	}
+	return ""
+}
+
+// Returns the keys referenced by the command, based on the key positions of its spec
+func commandKeys(command []string) []string {
+	spec, ok := lookupCommand(command)
+	if ok && spec.getKeys != nil {
+		return spec.getKeys(command)
+	}
+	if !ok || spec.firstKey == 0 {
+		return nil
+	}
+
+	lastKey := spec.lastKey
+	if lastKey < 0 {
+		lastKey = len(command) + lastKey
+	}
+
+	keys := []string{}
+	for i := spec.firstKey; i <= lastKey && i < len(command); i += spec.step {
+		keys = append(keys, command[i])
+	}
+	return keys
+}
+
+// The key of MIGRATE, or the keys after its KEYS option when the key is empty
+func migrateKeys(command []string) []string {
+	if command[3] != "" {
+		return []string{command[3]}
+	}
+	for i := 6; i < len(command); i++ {
+		if strings.ToUpper(command[i]) == "KEYS" {
+			return command[i+1:]
+		}
+	}
+	return nil
+}
+
+// Whether the command may modify the keyspace
+func IsWrite(command []string) bool {
+	spec, ok := lookupCommand(command)
+	return ok && spec.flags&flagWrite != 0
+}
+
+func isDenyOOM(command []string) bool {
+	spec, ok := lookupCommand(command)
+	return ok && spec.flags&flagDenyOOM != 0
+}
+
+func isReadOnly(command []string) bool {
+	spec, ok := lookupCommand(command)
+	return ok && spec.flags&flagReadOnly != 0
+}
@@ -0,0 +1,204 @@
+package command
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/replication"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	WAIT      = "WAIT"
+	WAITAOF   = "WAITAOF"
+	REPLICAOF = "REPLICAOF"
+	SLAVEOF   = "SLAVEOF"
+	ROLE      = "ROLE"
+)
+
+// States of the link with the master as reported by ROLE, which calls the transfer "sync"
+var roleLinkStates = map[string]string{
+	replication.LinkConnect:    "connect",
+	replication.LinkConnecting: "connecting",
+	replication.LinkHandshake:  "handshake",
+	replication.LinkTransfer:   "sync",
+	replication.LinkConnected:  "connected",
+}
+
+/*
+* WAIT numreplicas timeout. Blocks the client until numreplicas replicas acknowledged every write
+* made so far or the timeout (in milliseconds, 0 for none) expires, the master replies with the
+* number of replicas that acknowledged them
+ */
+func waitCommandHandler(command []string, c *client.Client, server *Server) string {
+	if server.ReplicationConfig["replicaof"] != "" {
+		return resp.RESPSerializeError("ERR WAIT cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+
+	numReplicas, err := strconv.Atoi(command[1])
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+	timeout, errResponse := parseWaitTimeout(command[2])
+	if errResponse != "" {
+		return errResponse
+	}
+
+	c.Wait = &client.WaitRequest{NumReplicas: numReplicas, Timeout: timeout}
+	return ""
+}
+
+/*
+* WAITAOF numlocal numreplicas timeout. Like WAIT but the writes have to be fsynced to the AOF,
+* locally when numlocal is 1 and on numreplicas replicas. Replies with both counts
+ */
+func waitaofCommandHandler(command []string, c *client.Client, server *Server) string {
+	if server.ReplicationConfig["replicaof"] != "" {
+		return resp.RESPSerializeError("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+
+	numLocal, err := strconv.Atoi(command[1])
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+	numReplicas, err := strconv.Atoi(command[2])
+	if err != nil {
+		return resp.RESPSerializeError(notIntegerError)
+	}
+	timeout, errResponse := parseWaitTimeout(command[3])
+	if errResponse != "" {
+		return errResponse
+	}
+	if numLocal > 0 && !server.Aof.Enabled() {
+		return resp.RESPSerializeError("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+
+	c.Wait = &client.WaitRequest{NumReplicas: numReplicas, NumLocal: numLocal, Aof: true, Timeout: timeout}
+	return ""
+}
+
+/*
+* REPLICAOF host port | REPLICAOF NO ONE. Only validates the arguments, the client handler switches
+* the role of the server once the command replied +OK
+ */
+func replicaofCommandHandler(command []string, server *Server) string {
+	// Cluster nodes replicate the master of their shard
+	if server.Cluster != nil {
+		return resp.RESPSerializeError("ERR REPLICAOF not allowed in cluster mode.")
+	}
+	if strings.EqualFold(command[1], "no") && strings.EqualFold(command[2], "one") {
+		return resp.RESPSerializeSimpleString("OK")
+	}
+
+	port, err := strconv.Atoi(command[2])
+	if err != nil || port < 0 || port > 65535 {
+		return resp.RESPSerializeError("ERR Invalid master port")
+	}
+	if server.ReplicationConfig["replicaof"] == command[1]+" "+command[2] {
+		return resp.RESPSerializeSimpleString("OK Already connected to specified master")
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+/*
+* ROLE. A master replies master, its offset and the ip, port and acknowledged offset of every
+* online replica. A replica replies slave, the address of its master, the state of the link and
+* the offset it processed (-1 while it is not connected)
+ */
+func roleCommandHandler(server *Server) string {
+	if replicaOf := server.ReplicationConfig["replicaof"]; replicaOf != "" {
+		host, port, _ := strings.Cut(replicaOf, " ")
+		portNum, _ := strconv.Atoi(port)
+		state := server.MasterLink.State()
+		offset := int64(-1)
+		if state == replication.LinkConnected {
+			offset = server.Replication.Offset()
+		}
+		return resp.RESPSerializeRawArray([]string{
+			resp.RESPSerializeBulkString("slave"),
+			resp.RESPSerializeBulkString(host),
+			resp.RESPSerializeInteger(portNum),
+			resp.RESPSerializeBulkString(roleLinkStates[state]),
+			resp.RESPSerializeInteger(int(offset)),
+		})
+	}
+
+	replicas := []string{}
+	for _, c := range server.Clients.All() {
+		if c.ReplicaState != client.ReplicaOnline {
+			continue
+		}
+		ip, _, _ := net.SplitHostPort(c.Conn().RemoteAddr().String())
+		replicas = append(replicas, resp.RESPSerializeRESPArray([]string{ip, c.ReplicaListeningPort, strconv.FormatInt(c.ReplicaAckOffset, 10)}))
+	}
+	return resp.RESPSerializeRawArray([]string{
+		resp.RESPSerializeBulkString("master"),
+		resp.RESPSerializeInteger(int(server.Replication.Offset())),
+		resp.RESPSerializeRawArray(replicas),
+	})
+}
+
+/*
+* Rejects writes on a replica with replica-read-only, its dataset only changes with the replication
+* stream. The connection to the master is the one client allowed to write
+ */
+func checkReadOnlyReplica(command []string, c *client.Client, server *Server) string {
+	if server.ReplicationConfig["replicaof"] == "" || c.Master || server.Config["replica-read-only"] == "no" || !IsWrite(command) {
+		return ""
+	}
+	return resp.RESPSerializeError("READONLY You can't write against a read only replica")
+}
+
+/*
+* Rejects writes on a master with min-replicas-to-write set while fewer replicas than that
+* acknowledged the replication stream within min-replicas-max-lag seconds, so an isolated master
+* stops accepting writes it could lose
+ */
+func checkGoodReplicas(command []string, c *client.Client, server *Server) string {
+	if c.Master || !minReplicasEnabled(server) || !IsWrite(command) {
+		return ""
+	}
+
+	minReplicas, _ := strconv.Atoi(server.Config["min-replicas-to-write"])
+	if goodReplicas(server) < minReplicas {
+		return resp.RESPSerializeError("NOREPLICAS Not enough good replicas to write.")
+	}
+	return ""
+}
+
+// Like Redis, min-replicas-max-lag 0 turns the check off too. Replicas never check it
+func minReplicasEnabled(server *Server) bool {
+	return server.ReplicationConfig["replicaof"] == "" && server.Config["min-replicas-to-write"] != "0" && server.Config["min-replicas-max-lag"] != "0"
+}
+
+// Number of online replicas whose last acknowledgment is at most min-replicas-max-lag seconds old
+func goodReplicas(server *Server) int {
+	maxLag := server.ConfigSeconds("min-replicas-max-lag")
+	good := 0
+	for _, c := range server.Clients.All() {
+		if c.ReplicaState == client.ReplicaOnline && time.Since(c.ReplicaAckTime).Truncate(time.Second) <= maxLag {
+			good++
+		}
+	}
+	return good
+}
+
+// Whether the command is REPLICAOF or its old name SLAVEOF
+func IsReplicaOf(command []string) bool {
+	name := strings.ToUpper(command[0])
+	return name == REPLICAOF || name == SLAVEOF
+}
+
+func parseWaitTimeout(arg string) (time.Duration, string) {
+	timeout, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, resp.RESPSerializeError("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return 0, resp.RESPSerializeError("ERR timeout is negative")
+	}
+	return time.Duration(timeout) * time.Millisecond, ""
+}
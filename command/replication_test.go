@@ -0,0 +1,194 @@
+package command
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/replication"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// A connection from 127.0.0.1
+type localConn struct {
+	net.Conn
+}
+
+func (localConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+// Registers a replica that last acknowledged the replication stream lag ago
+func addTestReplica(server *Server, state string, lag time.Duration) *client.Client {
+	c := client.NewClient(localConn{}, nil, nil)
+	c.ReplicaState = state
+	c.ReplicaAckTime = time.Now().Add(-lag)
+	server.Clients.Add(c)
+	return c
+}
+
+func TestMinReplicas(t *testing.T) {
+	noReplicas := resp.RESPSerializeError("NOREPLICAS Not enough good replicas to write.")
+	ok := resp.RESPSerializeSimpleString("OK")
+
+	tests := []struct {
+		name     string
+		maxLag   string
+		lags     []time.Duration
+		states   []string
+		master   bool
+		command  []string
+		response string
+	}{
+		{
+			name:     "no replica",
+			command:  []string{"SET", "key", "value"},
+			response: noReplicas,
+		},
+		{
+			name:     "one replica lags too much",
+			lags:     []time.Duration{time.Second, 20 * time.Second},
+			command:  []string{"SET", "key", "value"},
+			response: noReplicas,
+		},
+		{
+			name:     "enough replicas acknowledged within the lag",
+			lags:     []time.Duration{time.Second, 5 * time.Second},
+			command:  []string{"SET", "key", "value"},
+			response: ok,
+		},
+		{
+			name:     "the lag is counted in whole seconds",
+			lags:     []time.Duration{0, 10*time.Second + 500*time.Millisecond},
+			command:  []string{"SET", "key", "value"},
+			response: ok,
+		},
+		{
+			name:     "a replica still loading the RDB does not count",
+			lags:     []time.Duration{0, 0},
+			states:   []string{client.ReplicaOnline, client.ReplicaWaitBgsave},
+			command:  []string{"DEL", "key"},
+			response: noReplicas,
+		},
+		{
+			name:     "reads are allowed",
+			command:  []string{"GET", "key"},
+			response: resp.RESPNil,
+		},
+		{
+			name:     "the master link is allowed to write",
+			master:   true,
+			command:  []string{"SET", "key", "value"},
+			response: ok,
+		},
+		{
+			name:     "min-replicas-max-lag 0 disables the check",
+			maxLag:   "0",
+			command:  []string{"SET", "key", "value"},
+			response: ok,
+		},
+	}
+
+	for _, test := range tests {
+		server := newTestServer()
+		server.Config["min-replicas-to-write"] = "2"
+		if test.maxLag != "" {
+			server.Config["min-replicas-max-lag"] = test.maxLag
+		}
+		for i, lag := range test.lags {
+			state := client.ReplicaOnline
+			if test.states != nil {
+				state = test.states[i]
+			}
+			addTestReplica(server, state, lag)
+		}
+
+		c := client.NewClient(nil, nil, nil)
+		c.Master = test.master
+		if response := run(t, server, c, test.command...); response != test.response {
+			t.Errorf("%s: expected %q, got %q", test.name, test.response, response)
+		}
+	}
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	readOnly := resp.RESPSerializeError("READONLY You can't write against a read only replica")
+	ok := resp.RESPSerializeSimpleString("OK")
+
+	tests := []struct {
+		name     string
+		readOnly string
+		master   bool
+		command  []string
+		response string
+	}{
+		{name: "client writes are rejected", command: []string{"SET", "key", "other"}, response: readOnly},
+		{name: "every write is rejected", command: []string{"DEL", "key"}, response: readOnly},
+		{name: "reads are served", command: []string{"GET", "key"}, response: resp.RESPSerializeSimpleString("value")},
+		{name: "the master link applies writes", master: true, command: []string{"SET", "key", "other"}, response: ok},
+		{name: "replica-read-only no allows writes", readOnly: "no", command: []string{"SET", "key", "other"}, response: ok},
+	}
+
+	for _, test := range tests {
+		server := newTestServer()
+		run(t, server, client.NewClient(nil, nil, nil), "SET", "key", "value")
+		server.ReplicationConfig["replicaof"] = "127.0.0.1 6380"
+		if test.readOnly != "" {
+			server.Config["replica-read-only"] = test.readOnly
+		}
+
+		c := client.NewClient(nil, nil, nil)
+		c.Master = test.master
+		if response := run(t, server, c, test.command...); response != test.response {
+			t.Errorf("%s: expected %q, got %q", test.name, test.response, response)
+		}
+
+		value, _ := server.Store.Get("key")
+		if written := string(value) == "other"; written != (test.response == ok) {
+			t.Errorf("%s: expected the write to be applied only when accepted, key is %q", test.name, value)
+		}
+	}
+}
+
+func TestRoleMaster(t *testing.T) {
+	server := newTestServer()
+	if response := run(t, server, client.NewClient(nil, nil, nil), "ROLE"); response != "*3\r\n$6\r\nmaster\r\n:0\r\n*0\r\n" {
+		t.Fatalf("Unexpected ROLE of a master without replicas %q", response)
+	}
+
+	server.Replication.Psync("?", "-1")
+	server.Replication.Feed("*1\r\n$4\r\nPING\r\n")
+	online := addTestReplica(server, client.ReplicaOnline, 0)
+	online.ReplicaListeningPort, online.ReplicaAckOffset = "6380", 14
+	// Neither a replica loading the RDB nor a normal client are listed
+	addTestReplica(server, client.ReplicaWaitBgsave, 0).ReplicaListeningPort = "6381"
+	addTestReplica(server, "", 0)
+
+	expected := "*3\r\n$6\r\nmaster\r\n:14\r\n*1\r\n*3\r\n$9\r\n127.0.0.1\r\n$4\r\n6380\r\n$2\r\n14\r\n"
+	if response := run(t, server, client.NewClient(nil, nil, nil), "ROLE"); response != expected {
+		t.Fatalf("Expected ROLE %q, got %q", expected, response)
+	}
+}
+
+func TestRoleReplica(t *testing.T) {
+	tests := []struct {
+		state    string
+		response string
+	}{
+		{replication.LinkConnecting, "*5\r\n$5\r\nslave\r\n$9\r\n127.0.0.1\r\n:6380\r\n$10\r\nconnecting\r\n:-1\r\n"},
+		{replication.LinkTransfer, "*5\r\n$5\r\nslave\r\n$9\r\n127.0.0.1\r\n:6380\r\n$4\r\nsync\r\n:-1\r\n"},
+		{replication.LinkConnected, "*5\r\n$5\r\nslave\r\n$9\r\n127.0.0.1\r\n:6380\r\n$9\r\nconnected\r\n:14\r\n"},
+	}
+
+	for _, test := range tests {
+		server := newTestServer()
+		server.ReplicationConfig["replicaof"] = "127.0.0.1 6380"
+		server.Replication.ResetHistory(replication.NewReplID(), 14)
+		server.MasterLink.SetState(test.state)
+
+		if response := run(t, server, client.NewClient(nil, nil, nil), "ROLE"); response != test.response {
+			t.Errorf("%s: expected ROLE %q, got %q", test.state, test.response, response)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/cluster"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/pubsub"
+	"github.com/jason-gill00/redis-from-scratch/replication"
+	"github.com/jason-gill00/redis-from-scratch/tracking"
+)
+
+// Server groups the state shared by every connection that command handlers read or modify
+type Server struct {
+	Store       *persistence.Store
+	PubSub      *pubsub.PubSub
+	Tracker     *tracking.Tracker
+	Clients     *client.Registry
+	Saver       *persistence.Saver
+	Aof         *persistence.Aof
+	Replication *replication.Replication
+	MasterLink  *replication.MasterLink
+	// The cluster this node is part of, nil unless cluster-enabled
+	Cluster           *cluster.Cluster
+	Config            map[string]string
+	ReplicationConfig map[string]string
+
+	// Set by the handler of the command being executed if it propagates something else, see Propagation
+	propagation [][]string
+	rewritten   bool
+	// Connections of MIGRATE to its targets, by address
+	migrateConns map[string]*migrateConn
+}
+
+func NewServer(store *persistence.Store, config map[string]string, replicationConfig map[string]string) *Server {
+	clients := client.NewRegistry()
+	pubSub := pubsub.NewPubSub()
+
+	return &Server{
+		Store:             store,
+		PubSub:            pubSub,
+		Tracker:           tracking.NewTracker(clients, pubSub),
+		Clients:           clients,
+		Saver:             persistence.NewSaver(store),
+		Aof:               persistence.NewAof(store),
+		Replication:       replication.NewReplication(),
+		MasterLink:        replication.NewMasterLink(),
+		Config:            config,
+		ReplicationConfig: replicationConfig,
+		migrateConns:      map[string]*migrateConn{},
+	}
+}
+
+// Releases the state held for a client that disconnected
+func (s *Server) RemoveClient(c *client.Client) {
+	s.PubSub.RemoveClient(c)
+	s.Tracker.RemoveClient(c)
+	s.Clients.Remove(c)
+}
+
+/*
+* Validates a config parameter and applies it to the subsystem it controls. The normalized
+* value is stored in the config map so CONFIG GET returns what is actually in effect
+ */
+func (s *Server) ApplyConfig(param string, val string) error {
+	switch param {
+	case "dir":
+		s.Saver.SetDir(val)
+		s.Aof.SetDir(val)
+	case "dbfilename":
+		s.Saver.SetFileName(val)
+	case "save":
+		savePoints, err := persistence.ParseSavePoints(val)
+		if err != nil {
+			return err
+		}
+		s.Saver.SetSavePoints(savePoints)
+	case "appendonly":
+		enabled, err := parseYesNo(val)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			err = s.Aof.Open()
+		} else {
+			err = s.Aof.Close()
+		}
+		if err != nil {
+			return err
+		}
+		val = formatYesNo(enabled)
+	case "appendfilename":
+		if s.Aof.Enabled() {
+			return fmt.Errorf("appendfilename can not be changed while appendonly is enabled")
+		}
+		s.Aof.SetFileName(val)
+	case "appenddirname":
+		if s.Aof.Enabled() {
+			return fmt.Errorf("appenddirname can not be changed while appendonly is enabled")
+		}
+		s.Aof.SetDirName(val)
+	case "aof-use-rdb-preamble":
+		useRdbPreamble, err := parseYesNo(val)
+		if err != nil {
+			return err
+		}
+		s.Aof.SetUseRdbPreamble(useRdbPreamble)
+		val = formatYesNo(useRdbPreamble)
+	case "auto-aof-rewrite-percentage", "auto-aof-rewrite-min-size":
+		percentage, minSize, err := s.autoAofRewrite(param, val)
+		if err != nil {
+			return err
+		}
+		s.Aof.SetAutoRewrite(percentage, minSize)
+		if param == "auto-aof-rewrite-min-size" {
+			val = strconv.FormatInt(minSize, 10)
+		}
+	case "appendfsync":
+		fsync, err := persistence.ParseAppendFsync(val)
+		if err != nil {
+			return err
+		}
+		s.Aof.SetFsync(fsync)
+		val = string(fsync)
+	case "aof-load-truncated":
+		loadTruncated, err := parseYesNo(val)
+		if err != nil {
+			return err
+		}
+		s.Aof.SetLoadTruncated(loadTruncated)
+		val = formatYesNo(loadTruncated)
+	case "repl-diskless-sync":
+		diskless, err := parseYesNo(val)
+		if err != nil {
+			return err
+		}
+		val = formatYesNo(diskless)
+	case "min-replicas-to-write", "min-replicas-max-lag":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s must be a non negative integer", param)
+		}
+		val = strconv.Itoa(n)
+	case "replica-read-only":
+		readOnly, err := parseYesNo(val)
+		if err != nil {
+			return err
+		}
+		val = formatYesNo(readOnly)
+	case "repl-backlog-size":
+		size, err := persistence.ParseMemory(val)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("repl-backlog-size must be a positive size")
+		}
+		s.Replication.SetBacklogSize(int(size))
+		val = strconv.FormatInt(size, 10)
+	case "repl-timeout", "repl-ping-replica-period":
+		seconds, err := strconv.Atoi(val)
+		if err != nil || seconds <= 0 {
+			return fmt.Errorf("%s must be a positive number of seconds", param)
+		}
+	case "notify-keyspace-events":
+		if err := s.PubSub.SetKeyspaceEvents(val); err != nil {
+			return err
+		}
+		val = s.PubSub.KeyspaceEvents()
+	case "maxmemory":
+		maxMemory, err := persistence.ParseMemory(val)
+		if err != nil {
+			return err
+		}
+		s.Store.SetMaxMemory(maxMemory)
+		val = strconv.FormatInt(maxMemory, 10)
+	case "maxmemory-policy":
+		policy, err := persistence.ParseEvictionPolicy(val)
+		if err != nil {
+			return err
+		}
+		s.Store.SetEvictionPolicy(policy)
+		val = string(policy)
+	case "maxmemory-samples":
+		samples, err := strconv.Atoi(val)
+		if err != nil || samples <= 0 {
+			return fmt.Errorf("maxmemory-samples must be a positive integer")
+		}
+		s.Store.SetMaxMemorySamples(samples)
+	case "cluster-enabled":
+		enabled, err := parseYesNo(val)
+		if err != nil {
+			return err
+		}
+		if enabled != (s.Cluster != nil) {
+			return fmt.Errorf("cluster-enabled can not be changed at runtime")
+		}
+		val = formatYesNo(enabled)
+	case "cluster-config-file":
+		if current, ok := s.Config[param]; ok && current != val {
+			return fmt.Errorf("cluster-config-file can not be changed at runtime")
+		}
+	case "cluster-node-timeout":
+		timeout, err := strconv.Atoi(val)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("cluster-node-timeout must be a positive number of milliseconds")
+		}
+		if s.Cluster != nil {
+			s.Cluster.SetNodeTimeout(time.Duration(timeout) * time.Millisecond)
+		}
+	case "tracking-table-max-keys":
+		maxKeys, err := strconv.Atoi(val)
+		if err != nil || maxKeys < 0 {
+			return fmt.Errorf("tracking-table-max-keys must be a non negative integer")
+		}
+		s.Tracker.SetMaxKeys(maxKeys)
+	}
+
+	s.Config[param] = val
+	return nil
+}
+
+// A config parameter holding a number of seconds, validated by ApplyConfig
+func (s *Server) ConfigSeconds(param string) time.Duration {
+	seconds, _ := strconv.Atoi(s.Config[param])
+	return time.Duration(seconds) * time.Second
+}
+
+// Both auto rewrite settings, with the one being changed parsed from val
+func (s *Server) autoAofRewrite(param string, val string) (int, int64, error) {
+	percentage, minSize := s.Config["auto-aof-rewrite-percentage"], s.Config["auto-aof-rewrite-min-size"]
+	if param == "auto-aof-rewrite-percentage" {
+		percentage = val
+	} else {
+		minSize = val
+	}
+
+	p, err := strconv.Atoi(percentage)
+	if err != nil || p < 0 {
+		return 0, 0, fmt.Errorf("auto-aof-rewrite-percentage must be a non negative integer")
+	}
+	size, err := persistence.ParseMemory(minSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return p, size, nil
+}
+
+func parseYesNo(val string) (bool, error) {
+	switch strings.ToLower(val) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+}
+
+func formatYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
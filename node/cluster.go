@@ -0,0 +1,56 @@
+package node
+
+import (
+	"strconv"
+
+	"github.com/jason-gill00/redis-from-scratch/cluster"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+/*
+* What the cluster bus asks of this node. It only calls it from the client handler, while it
+* applies an event of the bus or runs its cron
+ */
+type clusterHost struct {
+	n *Node
+}
+
+func (h clusterHost) ReplicationOffset() int64 {
+	return h.n.server.Replication.Offset()
+}
+
+// A replica elected master stops replicating, a node given a new master follows it
+func (h clusterHost) SetMaster(master *cluster.Node) {
+	if master == nil {
+		h.n.promote()
+		return
+	}
+	h.n.follow(master.IP + " " + strconv.Itoa(master.Port))
+}
+
+// The keys of a slot another master took over are deleted on the replicas and in the AOF as well
+func (h clusterHost) DeleteKeysInSlot(slot int) {
+	store := h.n.server.Store
+	keys := store.KeysInSlot(slot, store.CountKeysInSlot(slot))
+	for _, key := range keys {
+		store.Delete(key)
+		h.n.propagate(resp.RESPSerializeRESPArray([]string{"DEL", key}), propagateAof|propagateRepl)
+	}
+}
+
+func (h clusterHost) CountKeysInSlot(slot int) int {
+	return h.n.server.Store.CountKeysInSlot(slot)
+}
+
+// Starts the cluster bus. A replica according to nodes.conf connects to its master again
+func (n *Node) startCluster() error {
+	cl := n.server.Cluster
+	cl.SetHost(clusterHost{n})
+	if err := cl.Listen(); err != nil {
+		return err
+	}
+	if master := cl.Myself().Master(); master != nil && master.IP != "" {
+		n.startLink(master.IP+" "+strconv.Itoa(master.Port), false)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/replication"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+func sendCommand(conn net.Conn, command string, expectedResponse string) error {
+	_, err := conn.Write([]byte(command))
+	if err != nil {
+		return fmt.Errorf("error sending command: %s", err)
+	}
+	response := make([]byte, 1024)
+	n, err := conn.Read(response)
+	if err != nil {
+		return fmt.Errorf("error reading response: %s", err)
+	}
+	if !strings.Contains(string(response[:n]), expectedResponse) {
+		return fmt.Errorf("unexpected response from master: %s", string(response[:n]))
+	}
+	return nil
+}
+
+/*
+* Connection to the master that fails a read or write once the master sent nothing for the
+* timeout, which guards the handshake and the RDB transfer. Once the replica is connected the
+* timeout is lifted, repl-timeout is then checked against the last time the master sent anything
+ */
+type deadlineConn struct {
+	net.Conn
+	timeout atomic.Int64
+}
+
+func newDeadlineConn(conn net.Conn, timeout time.Duration) *deadlineConn {
+	c := &deadlineConn{Conn: conn}
+	c.timeout.Store(int64(timeout))
+	return c
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(c.deadline())
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(c.deadline())
+	return c.Conn.Write(b)
+}
+
+func (c *deadlineConn) liftTimeout() {
+	c.timeout.Store(0)
+}
+
+func (c *deadlineConn) deadline() time.Time {
+	if timeout := time.Duration(c.timeout.Load()); timeout > 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
+// A connection to the master that went through the handshake and PSYNC
+type masterSync struct {
+	conn *deadlineConn
+	// Positioned where the stream of commands starts
+	reader *resp.Reader
+	replID string
+	offset int64
+	// RDB of a full resync, nil when the replica continues its history
+	rdb *persistence.RdbFile
+	// Link the connection was made for
+	link *masterLink
+}
+
+/*
+* Connects to the master and synchronizes with it, reporting the state of the link. psyncID and
+* psyncOffset are the history the replica has (? and -1 for none), the master continues it if it can
+ */
+func connectToMaster(setState func(string), masterAddr, port, psyncID string, psyncOffset int64, timeout time.Duration) (*masterSync, error) {
+	setState(replication.LinkConnecting)
+	raw, err := net.DialTimeout("tcp", masterAddr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to master: %s", err)
+	}
+	conn := newDeadlineConn(raw, timeout)
+
+	setState(replication.LinkHandshake)
+	if err := handshake(conn, port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	setState(replication.LinkTransfer)
+	sync, err := psync(conn, psyncID, psyncOffset)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sync, nil
+}
+
+func handshake(conn net.Conn, port string) error {
+	pingCommand := resp.RESPSerializeRESPArray([]string{"PING"})
+	if err := sendCommand(conn, pingCommand, "PONG"); err != nil {
+		return fmt.Errorf("PING failed: %s", err)
+	}
+	replConfPort := resp.RESPSerializeRESPArray([]string{"REPLCONF", "listening-port", port})
+	if err := sendCommand(conn, replConfPort, "OK"); err != nil {
+		return fmt.Errorf("REPLCONF listening-port failed: %s", err)
+	}
+	replConfCapa := resp.RESPSerializeRESPArray([]string{"REPLCONF", "capa", "psync2"})
+	if err := sendCommand(conn, replConfCapa, "OK"); err != nil {
+		return fmt.Errorf("REPLCONF capa failed: %s", err)
+	}
+	return nil
+}
+
+/*
+* Sends PSYNC and reads the reply of the master: +CONTINUE [replid] when it continues the history
+* of the replica, otherwise +FULLRESYNC <replid> <offset> followed by the RDB transfer
+ */
+func psync(conn *deadlineConn, psyncID string, psyncOffset int64) (*masterSync, error) {
+	command := resp.RESPSerializeRESPArray([]string{"PSYNC", psyncID, strconv.FormatInt(psyncOffset, 10)})
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return nil, fmt.Errorf("error sending command: %s", err)
+	}
+
+	sync := &masterSync{conn: conn, reader: resp.NewReader(conn)}
+	line, err := readLine(sync.reader.Buffered())
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+
+	if len(fields) >= 1 && fields[0] == "+CONTINUE" {
+		sync.replID, sync.offset = psyncID, psyncOffset-1
+		if len(fields) >= 2 {
+			sync.replID = fields[1]
+		}
+		return sync, nil
+	}
+
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		return nil, fmt.Errorf("unexpected PSYNC response from master: %s", line)
+	}
+	sync.replID = fields[1]
+	if sync.offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid FULLRESYNC offset: %s", fields[2])
+	}
+
+	payload, err := readRdb(sync.reader.Buffered())
+	if err != nil {
+		return nil, err
+	}
+	if sync.rdb, err = persistence.ParseRdb(payload); err != nil {
+		return nil, fmt.Errorf("invalid RDB from master: %w", err)
+	}
+	return sync, nil
+}
+
+/*
+* Reads the $<length>\r\n<payload> RDB transfer. Unlike a bulk string it is not followed by a
+* CRLF, the next byte is the first byte of the replication stream
+ */
+func readRdb(r *bufio.Reader) ([]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, resp.RESPBulk) {
+		return nil, fmt.Errorf("unexpected RDB transfer header from master: %s", line)
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid RDB transfer length: %s", line)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("error reading RDB from master: %w", err)
+	}
+	return payload, nil
+}
+
+// Reads a CRLF terminated line, skipping the empty lines a master may send as keepalives
+func readLine(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading from master: %w", err)
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			return line, nil
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package node
+
+import (
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/command"
+	"github.com/jason-gill00/redis-from-scratch/replication"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Delays between attempts to connect to the master, doubling after every failure
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// A command of the replication stream, the exact bytes it was received as and the link it came from
+type masterCommand struct {
+	command []string
+	raw     []byte
+	conn    net.Conn
+}
+
+// Link of a replica with its master, it lives until the replica is promoted or follows another master
+type masterLink struct {
+	addr string
+	// Connection to the master, commands of the replication stream are executed as this client
+	client *client.Client
+	// Whether the dataset follows the history of the master, so PSYNC can continue it after a reconnect
+	cached bool
+	// Offset of the replication stream known to be fsynced to the AOF
+	aofAckOffset int64
+	// MULTI and the commands queued after it until EXEC, nil outside of a transaction
+	transaction []masterCommand
+	// Closed once the link is dropped, stops connecting to the master
+	done chan struct{}
+}
+
+/*
+* Makes this node a replica of replicaOf ("host port"). cached tells whether the dataset already
+* follows a history the master may continue, otherwise the replica asks for a full resync
+ */
+func (n *Node) startLink(replicaOf string, cached bool) {
+	n.link = &masterLink{
+		addr:   strings.ReplaceAll(replicaOf, " ", ":"),
+		cached: cached,
+		done:   make(chan struct{}),
+	}
+	n.server.ReplicationConfig["replicaof"] = replicaOf
+	n.server.MasterLink.SetState(replication.LinkConnect)
+	// Replicas get deletions of expired keys from their master
+	n.server.Store.SetActiveExpire(false)
+	n.connect(n.link)
+}
+
+// Drops the link with the master, a connection attempt in progress gives up
+func (n *Node) stopLink() {
+	close(n.link.done)
+	if n.link.client != nil {
+		n.link.client.Conn().Close()
+	}
+	n.link = nil
+}
+
+// Connects to the master in the background, continuing from the first byte not applied yet
+func (n *Node) connect(link *masterLink) {
+	psyncID, psyncOffset := "?", int64(-1)
+	if link.cached {
+		psyncID, psyncOffset = n.server.Replication.ReplID(), n.server.Replication.Offset()+1
+	}
+	go n.connectLoop(link, psyncID, psyncOffset, n.server.ConfigSeconds("repl-timeout"))
+}
+
+/*
+* Connects to the master until it succeeds, waiting longer after every failed attempt so a master
+* that is down is not flooded with connections. The connection is handed to the client handler
+ */
+func (n *Node) connectLoop(link *masterLink, psyncID string, psyncOffset int64, timeout time.Duration) {
+	setState := func(state string) {
+		select {
+		case <-link.done:
+		default:
+			n.server.MasterLink.SetState(state)
+		}
+	}
+
+	backoff := reconnectMinBackoff
+	for {
+		sync, err := connectToMaster(setState, link.addr, n.port, psyncID, psyncOffset, timeout)
+		if err == nil {
+			sync.link = link
+			select {
+			case n.linkUp <- sync:
+			case <-link.done:
+				sync.conn.Close()
+			}
+			return
+		}
+
+		setState(replication.LinkConnect)
+		slog.Error("Error synchronizing with master", "err", err, "retryIn", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-link.done:
+			return
+		}
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
+/*
+* Starts applying the replication stream of a connection that completed PSYNC. After a full
+* resync the dataset is replaced by the RDB sent by the master and the replica takes over its
+* replication id and offset, after a partial one it just continues
+ */
+func (n *Node) masterLinkUp(sync *masterSync) {
+	// The link was dropped while connecting
+	if sync.link != n.link {
+		sync.conn.Close()
+		return
+	}
+
+	// Replicas of this node follow the new dataset or replication id once they reconnect
+	if sync.rdb != nil || sync.replID != n.server.Replication.ReplID() {
+		n.disconnectReplicas()
+	}
+
+	if sync.rdb != nil {
+		n.server.Store.Flush()
+		n.server.Store.Load(sync.rdb)
+		n.server.Replication.ResetHistory(sync.replID, sync.offset)
+		slog.Info("Loaded the dataset of the master", "replid", sync.replID, "offset", sync.offset, "keys", len(sync.rdb.Database))
+	} else {
+		n.server.Replication.ContinueHistory(sync.replID)
+		slog.Info("Partial resynchronization with the master succeeded", "replid", sync.replID, "offset", sync.offset)
+	}
+
+	n.link.cached = true
+	sync.conn.liftTimeout()
+	n.link.client = client.NewClient(sync.conn, nil, nil)
+	n.link.client.Master = true
+	n.server.MasterLink.SetState(replication.LinkConnected)
+	go n.masterReadLoop(sync.reader, sync.conn)
+}
+
+/*
+* Stops applying a lost connection and reconnects, continuing from the first byte of the stream
+* that was not applied. A transaction cut off in the middle is dropped, it is sent again
+ */
+func (n *Node) masterLinkDown(conn net.Conn) {
+	conn.Close()
+	if !n.fromMaster(conn) {
+		return
+	}
+
+	n.link.client, n.link.transaction = nil, nil
+	n.server.MasterLink.SetState(replication.LinkConnect)
+	n.connect(n.link)
+}
+
+// Whether conn is the current connection to the master
+func (n *Node) fromMaster(conn net.Conn) bool {
+	return n.link != nil && n.link.client != nil && n.link.client.Conn() == conn
+}
+
+// Reads the replication stream and hands every command to the client handler
+func (n *Node) masterReadLoop(reader *resp.Reader, conn net.Conn) {
+	for {
+		cmd, raw, err := reader.ReadRawCommand()
+		if err != nil {
+			slog.Error("Lost connection with master", "err", err)
+			n.linkLost <- conn
+			return
+		}
+
+		n.masterChan <- masterCommand{command: cmd, raw: raw, conn: conn}
+	}
+}
+
+/*
+* Acknowledges the offset to the master every second. The master PINGs its replicas every
+* repl-ping-replica-period, so a master that sent nothing for repl-timeout is considered gone
+ */
+func (n *Node) replicationCron() {
+	if n.link.client == nil {
+		return
+	}
+
+	if idle := n.server.MasterLink.Idle(); idle > n.server.ConfigSeconds("repl-timeout") {
+		slog.Warn("MASTER timeout: no data nor PING received", "idle", idle)
+		n.masterLinkDown(n.link.client.Conn())
+		return
+	}
+	n.sendAck()
+}
+
+/*
+* Applies a command of the replication stream. The master does not expect replies, except for
+* REPLCONF GETACK which asks for the offset processed so far. Commands between MULTI and EXEC are
+* queued and applied together, so clients never see half of a transaction
+ */
+func (n *Node) applyMasterCommand(mc masterCommand) {
+	// Commands read before the link was dropped are sent again after reconnecting
+	if !n.fromMaster(mc.conn) {
+		return
+	}
+	n.server.MasterLink.Touch()
+
+	link := n.link
+	switch {
+	case len(mc.command) >= 2 && strings.ToUpper(mc.command[0]) == command.REPLCONF && strings.ToLower(mc.command[1]) == "getack":
+		n.sendAck()
+	case command.IsMulti(mc.command):
+		link.transaction = []masterCommand{mc}
+		return
+	case link.transaction != nil && !command.IsExec(mc.command):
+		link.transaction = append(link.transaction, mc)
+		return
+	case link.transaction != nil:
+		// A transaction only counts towards the offset once it is applied, so a replica that loses
+		// the link in the middle of it asks for it again
+		aof, stream := string(link.transaction[0].raw), string(link.transaction[0].raw)
+		for _, queued := range link.transaction[1:] {
+			if n.execMasterCommand(queued.command) {
+				aof += string(queued.raw)
+			}
+			stream += string(queued.raw)
+		}
+		n.server.Aof.Append(aof + string(mc.raw))
+		n.feedReplicas(stream)
+		link.transaction = nil
+	default:
+		if n.execMasterCommand(mc.command) {
+			n.server.Aof.Append(string(mc.raw))
+		}
+	}
+
+	// The offset counts the bytes of the replication stream exactly as they were received, and
+	// replicas of this node get these same bytes so they share the replication id and offsets
+	n.feedReplicas(string(mc.raw))
+}
+
+// Runs a command sent by the master, returns whether it is a write that goes to the AOF
+func (n *Node) execMasterCommand(cmd []string) bool {
+	if _, err := command.CacheCommandHandler(cmd, n.link.client, n.server); err != nil {
+		slog.Error("Encountered error when handling command from master", "err", err)
+		return false
+	}
+	return command.IsWrite(cmd)
+}
+
+/*
+* Tells the master how much of the replication stream was processed and how much of it is
+* fsynced to the AOF (for WAITAOF), sent every second and whenever the master asks
+ */
+func (n *Node) sendAck() {
+	offset := n.server.Replication.Offset()
+	if appended, synced := n.server.Aof.Offsets(); n.server.Aof.Enabled() && synced >= appended {
+		n.link.aofAckOffset = offset
+	}
+	ack := []string{"REPLCONF", "ACK", strconv.FormatInt(offset, 10), "FACK", strconv.FormatInt(n.link.aofAckOffset, 10)}
+	n.write(resp.RESPSerializeRESPArray(ack), n.link.client.Conn())
+}
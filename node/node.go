@@ -0,0 +1,331 @@
+package node
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/cluster"
+	"github.com/jason-gill00/redis-from-scratch/command"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Where a propagated command is sent, like PROPAGATE_AOF and PROPAGATE_REPL in Redis
+const (
+	propagateAof = 1 << iota
+	propagateRepl
+)
+
+/*
+* A server and its client handler. It is a master, or a replica of another server while it has a
+* link with a master. REPLICAOF switches between the two without dropping clients
+ */
+type Node struct {
+	addr      string
+	port      string
+	server    *command.Server
+	msgChan   chan client.ClientMsg
+	closeChan chan *client.Client
+	replicas  []*replica
+	// Replicas whose RDB transfer finished
+	syncDone chan *replica
+
+	// Clients blocked by WAIT or WAITAOF, the commands they sent meanwhile and their timeouts
+	waiting     []*client.Client
+	deferred    map[*client.Client][][]string
+	waitTimeout chan waitExpired
+
+	// Last time replicas were sent a PING
+	lastReplPing time.Time
+
+	// Link with the master while this node is a replica, nil while it is a master
+	link *masterLink
+	// Commands of the replication stream, applied on the client handler like any other command
+	masterChan chan masterCommand
+	// Connections to the master that completed PSYNC and connections that were lost
+	linkUp   chan *masterSync
+	linkLost chan net.Conn
+}
+
+func NewNode(server *command.Server, port string) *Node {
+	n := &Node{
+		addr:        fmt.Sprintf("0.0.0.0:%s", port),
+		port:        port,
+		server:      server,
+		msgChan:     make(chan client.ClientMsg),
+		closeChan:   make(chan *client.Client),
+		syncDone:    make(chan *replica),
+		deferred:    map[*client.Client][][]string{},
+		waitTimeout: make(chan waitExpired),
+		masterChan:  make(chan masterCommand),
+		linkUp:      make(chan *masterSync),
+		linkLost:    make(chan net.Conn),
+	}
+
+	// Keys evicted or expired on the master are deleted on the replicas and in the AOF explicitly
+	server.Store.OnKeyspaceEvent(n.propagateDeletion)
+
+	return n
+}
+
+func (n *Node) Start() {
+	l, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		fmt.Println("Failed to bind to port 6379")
+		os.Exit(1)
+	}
+
+	// Clients are served the current dataset while a replica connects to its master
+	if replicaOf := n.server.ReplicationConfig["replicaof"]; replicaOf != "" {
+		n.startLink(replicaOf, false)
+	}
+
+	if n.server.Cluster != nil {
+		if err := n.startCluster(); err != nil {
+			fmt.Printf("Failed to bind the cluster bus: %s \n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Seperate thread to read incomming messages from clients
+	go n.clientHandler()
+
+	// This is responsible for accepting new connections
+	n.acceptLoop(l)
+}
+
+func (n *Node) clientHandler() {
+	cron := time.NewTicker(time.Second)
+	defer cron.Stop()
+	expireCron := time.NewTicker(persistence.ActiveExpireInterval)
+	defer expireCron.Stop()
+
+	// Both stay nil, and never ready, unless cluster mode is enabled
+	var clusterEvents <-chan cluster.Event
+	var clusterCron <-chan time.Time
+	if n.server.Cluster != nil {
+		clusterEvents = n.server.Cluster.Events()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		clusterCron = ticker.C
+	}
+
+	for {
+		select {
+		case clientMsg := <-n.msgChan:
+			n.processCommand(clientMsg.Client, clientMsg.Command)
+
+		case closeClient := <-n.closeChan:
+			slog.Info("Closing connection", "info", closeClient.Conn().RemoteAddr().String())
+			n.server.RemoveClient(closeClient)
+			n.removeReplica(closeClient.Conn())
+			n.removeWaiting(closeClient)
+			closeClient.Conn().Close()
+
+		case r := <-n.syncDone:
+			n.replicaSynced(r)
+
+		case expired := <-n.waitTimeout:
+			n.waitTimedOut(expired)
+
+		case command := <-n.masterChan:
+			n.applyMasterCommand(command)
+
+		case sync := <-n.linkUp:
+			n.masterLinkUp(sync)
+
+		case conn := <-n.linkLost:
+			n.masterLinkDown(conn)
+
+		case ev := <-clusterEvents:
+			n.server.Cluster.HandleEvent(ev)
+
+		case <-clusterCron:
+			n.server.Cluster.Cron()
+
+		case <-cron.C:
+			n.serverCron()
+
+		// Only a master actively expires keys, a replica gets them deleted by its master
+		case <-expireCron.C:
+			if n.link == nil {
+				n.server.Store.ActiveExpireCycle()
+			}
+		}
+	}
+}
+
+func (n *Node) processCommand(c *client.Client, cmd []string) {
+	// Commands of a blocked client run once it is unblocked, in order
+	if c.Wait != nil {
+		n.deferred[c] = append(n.deferred[c], cmd)
+		return
+	}
+
+	response, err := command.CacheCommandHandler(cmd, c, n.server)
+	if err != nil {
+		slog.Error("Encountered error when handling command", "err", err)
+		return
+	}
+	if c.Wait != nil {
+		n.blockForReplicas(c)
+		return
+	}
+
+	// Writes are propagated before replying, so with appendfsync always a reply means the write is on disk
+	if !strings.HasPrefix(response, resp.RESPError) {
+		n.propagateCommand(cmd)
+	}
+	n.write(response, c.Conn())
+
+	// Once a replica synchronized with PSYNC every write is propagated to it
+	if strings.HasPrefix(response, "+FULLRESYNC") {
+		n.fullResync(c)
+	} else if strings.HasPrefix(response, "+CONTINUE") {
+		n.partialResync(c)
+	}
+
+	// An acknowledgment from a replica may be what a blocked client waits for
+	if strings.ToUpper(cmd[0]) == command.REPLCONF && len(n.waiting) > 0 {
+		n.checkWaiting()
+	}
+
+	if command.IsReplicaOf(cmd) && response == resp.RESPSerializeSimpleString("OK") {
+		n.replicaOf(cmd[1], cmd[2])
+	}
+}
+
+/*
+* Periodic tasks that need a consistent view of the dataset run on the client handler, between
+* two commands. A rewrite started here switches incr files right where its snapshot is taken
+ */
+func (n *Node) serverCron() {
+	// Local AOF fsyncs happen in the background, WAITAOF clients notice them here
+	if len(n.waiting) > 0 {
+		n.checkWaiting()
+	}
+
+	if n.link != nil {
+		n.replicationCron()
+	}
+
+	// A replica tells a master that went silent from one with nothing to propagate by these PINGs
+	if n.link == nil && len(n.replicas) > 0 && time.Since(n.lastReplPing) >= n.server.ConfigSeconds("repl-ping-replica-period") {
+		n.lastReplPing = time.Now()
+		n.propagate(resp.RESPSerializeRESPArray([]string{command.PING}), propagateRepl)
+	}
+
+	if n.server.Aof.ShouldRewrite() {
+		slog.Info("Starting automatic rewriting of the AOF", "size", n.server.Aof.Size(), "baseSize", n.server.Aof.BaseSize())
+		if err := n.server.Aof.Rewrite(); err != nil {
+			slog.Error("Could not start the AOF rewrite", "err", err)
+		}
+	}
+
+	n.server.CloseIdleMigrateConns()
+}
+
+/*
+* Responsible for accepting new connections and appending the connection to the clients map
+ */
+func (n *Node) acceptLoop(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			slog.Error("error accepting connection", "err", err)
+			continue
+		}
+
+		client := client.NewClient(conn, n.msgChan, n.closeChan)
+		n.server.Clients.Add(client)
+
+		go client.ReadLoop()
+	}
+}
+
+/*
+* Propagates an executed command. Writes go to the AOF and the replicas as their handler asked, one
+* by one in canonical RESP. Published messages only go to the replicas so subscribers connected to
+* them receive them too
+ */
+func (n *Node) propagateCommand(cmd []string) {
+	switch strings.ToUpper(cmd[0]) {
+	case command.PUBLISH, command.SPUBLISH:
+		n.propagate(resp.RESPSerializeRESPArray(cmd), propagateRepl)
+		return
+	}
+
+	for _, propagated := range n.server.Propagation(cmd) {
+		n.propagate(resp.RESPSerializeRESPArray(propagated), propagateAof|propagateRepl)
+	}
+}
+
+/*
+* Sends a serialized command to the AOF and/or every connected replica. Writes made directly on
+* a replica are local, its replicas only get the stream of its master
+ */
+func (n *Node) propagate(serializedCommand string, targets int) {
+	if targets&propagateAof != 0 {
+		n.server.Aof.Append(serializedCommand)
+	}
+	if targets&propagateRepl != 0 && n.link == nil {
+		n.feedReplicas(serializedCommand)
+	}
+}
+
+/*
+* Adds bytes to the replication stream. The backlog lets replicas that reconnect continue from
+* where they were, replicas still loading the RDB get them once they are done
+ */
+func (n *Node) feedReplicas(data string) {
+	n.server.Replication.Feed(data)
+	for _, r := range n.replicas {
+		if r.client.ReplicaState == client.ReplicaWaitBgsave {
+			r.pending = append(r.pending, data)
+			r.client.ReplicaPending += int64(len(data))
+			continue
+		}
+		n.write(data, r.client.Conn())
+	}
+}
+
+// Closes the connections of the replicas of this node, they reconnect and PSYNC again
+func (n *Node) disconnectReplicas() {
+	for _, r := range n.replicas {
+		r.client.Conn().Close()
+	}
+}
+
+/*
+* Replicas ignore maxmemory and wait for the master to expire keys, so evicted and expired keys are
+* propagated as DEL. Both happen on the client handler, evictions and lazy expirations while it
+* runs a command, so they are propagated before the command that caused them
+ */
+func (n *Node) propagateDeletion(event persistence.KeyspaceEvent) {
+	if event.Class != persistence.EventEvicted && event.Class != persistence.EventExpired {
+		return
+	}
+	n.propagate(resp.RESPSerializeRESPArray([]string{"DEL", event.Key}), propagateAof|propagateRepl)
+}
+
+// Stops propagating to a replica that disconnected
+func (n *Node) removeReplica(conn net.Conn) {
+	for i, r := range n.replicas {
+		if r.client.Conn() == conn {
+			n.replicas = append(n.replicas[:i], n.replicas[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *Node) write(response string, conn net.Conn) {
+	_, err := conn.Write([]byte(response))
+	if err != nil {
+		fmt.Printf("Error encountered when writing response: %s", err.Error())
+	}
+}
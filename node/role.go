@@ -0,0 +1,56 @@
+package node
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+/*
+* REPLICAOF host port makes this node a replica of another server, REPLICAOF NO ONE turns it into
+* a master again. Clients stay connected either way
+ */
+func (n *Node) replicaOf(host string, port string) {
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		n.promote()
+		return
+	}
+	n.follow(host + " " + port)
+}
+
+/*
+* Stops replicating and starts a new replication history. The history of the old master is kept
+* as replid2, so its other replicas can partially resynchronize with this node
+ */
+func (n *Node) promote() {
+	if n.link == nil {
+		return
+	}
+
+	n.stopLink()
+	n.server.ReplicationConfig["replicaof"] = ""
+	n.server.Replication.Promote()
+	n.server.Store.SetActiveExpire(true)
+	slog.Info("MASTER MODE enabled", "replid", n.server.Replication.ReplID())
+}
+
+/*
+* Replicates replicaOf from now on. The dataset of a master is its own history, so the new master
+* can continue it if it shares that history (it was a replica of this node). Replicas of this node
+* are disconnected, they reconnect and follow the new history, and WAIT clients are unblocked
+ */
+func (n *Node) follow(replicaOf string) {
+	cached := true
+	if n.link != nil {
+		cached = n.link.cached
+		n.stopLink()
+	}
+
+	n.disconnectReplicas()
+	for _, c := range slices.Clone(n.waiting) {
+		n.unblock(c)
+	}
+
+	n.startLink(replicaOf, cached)
+	slog.Info("Connecting to MASTER", "master", replicaOf)
+}
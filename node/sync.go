@@ -0,0 +1,90 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+)
+
+// A replica that synchronized with PSYNC
+type replica struct {
+	client *client.Client
+	// Writes propagated while the RDB is transferred, sent once the replica loaded it
+	pending []string
+}
+
+/*
+* Starts a full resynchronization. The snapshot is taken right away on the client handler so it
+* matches the offset sent with FULLRESYNC exactly, the RDB is transferred in the background and
+* writes propagated meanwhile are buffered until the replica has the whole snapshot
+ */
+func (n *Node) fullResync(c *client.Client) {
+	r := &replica{client: c}
+	c.ReplicaState = client.ReplicaWaitBgsave
+	n.replicas = append(n.replicas, r)
+
+	snapshot := n.server.Store.Snapshot()
+	diskless := n.server.Config["repl-diskless-sync"] != "no"
+	go func() {
+		if err := n.sendRdb(c.Conn(), snapshot, diskless); err != nil {
+			slog.Error("Full resynchronization failed", "replica", c.Conn().RemoteAddr().String(), "err", err)
+			c.Conn().Close()
+			return
+		}
+		n.syncDone <- r
+	}()
+}
+
+// Adds a replica that continues from the backlog, it is online right away
+func (n *Node) partialResync(c *client.Client) {
+	c.ReplicaState = client.ReplicaOnline
+	c.ReplicaAckTime = time.Now()
+	n.replicas = append(n.replicas, &replica{client: c})
+}
+
+/*
+* Sends the RDB as $<length>\r\n<payload>, like a bulk string without the trailing CRLF. Diskless
+* syncs serialize the snapshot in memory, disk based ones save it to the RDB file first
+ */
+func (n *Node) sendRdb(conn net.Conn, snapshot *persistence.Snapshot, diskless bool) error {
+	var payload []byte
+	if !diskless {
+		if err := n.server.Saver.SaveSnapshot(snapshot); err != nil {
+			slog.Warn("Could not save the RDB file for the replica, sending it diskless", "err", err)
+			diskless = true
+		} else if payload, err = os.ReadFile(n.server.Saver.Path()); err != nil {
+			return err
+		}
+	}
+	if diskless {
+		var buf bytes.Buffer
+		if err := persistence.WriteRdb(&buf, snapshot); err != nil {
+			return err
+		}
+		payload = buf.Bytes()
+	}
+
+	slog.Info("Sending RDB to replica", "replica", conn.RemoteAddr().String(), "bytes", len(payload), "diskless", diskless)
+	if _, err := conn.Write([]byte(fmt.Sprintf("$%d\r\n", len(payload)))); err != nil {
+		return err
+	}
+	_, err := conn.Write(payload)
+	return err
+}
+
+// Called on the client handler once the RDB was sent, the replica gets every write it missed
+func (n *Node) replicaSynced(r *replica) {
+	for _, serializedCommand := range r.pending {
+		n.write(serializedCommand, r.client.Conn())
+	}
+	r.pending, r.client.ReplicaPending = nil, 0
+	r.client.ReplicaState = client.ReplicaOnline
+	r.client.ReplicaAckTime = time.Now()
+	slog.Info("Synchronization with replica succeeded", "replica", r.client.Conn().RemoteAddr().String())
+}
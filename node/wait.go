@@ -0,0 +1,108 @@
+package node
+
+import (
+	"slices"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Timeout of a WAIT, only unblocks the client if it is still blocked by that WAIT
+type waitExpired struct {
+	client  *client.Client
+	request *client.WaitRequest
+}
+
+/*
+* Blocks a client that called WAIT or WAITAOF until enough replicas acknowledged the current
+* offset. Replicas only acknowledge once per second on their own, so they are asked right away
+* with REPLCONF GETACK
+ */
+func (n *Node) blockForReplicas(c *client.Client) {
+	w := c.Wait
+	w.Offset = n.server.Replication.Offset()
+	w.AofOffset, _ = n.server.Aof.Offsets()
+
+	if n.waitSatisfied(c) {
+		n.unblock(c)
+		return
+	}
+
+	n.waiting = append(n.waiting, c)
+	if w.Timeout > 0 {
+		time.AfterFunc(w.Timeout, func() { n.waitTimeout <- waitExpired{client: c, request: w} })
+	}
+	n.propagate(resp.RESPSerializeRESPArray([]string{"REPLCONF", "GETACK", "*"}), propagateRepl)
+}
+
+// Number of replicas that acknowledged the offset the client waits for, and whether the local AOF did
+func (n *Node) waitCounts(c *client.Client) (replicas int, local int) {
+	w := c.Wait
+	for _, r := range n.replicas {
+		if r.client.ReplicaState != client.ReplicaOnline {
+			continue
+		}
+		acked := r.client.ReplicaAckOffset
+		if w.Aof {
+			acked = r.client.ReplicaAofAckOffset
+		}
+		if acked >= w.Offset {
+			replicas++
+		}
+	}
+
+	if _, synced := n.server.Aof.Offsets(); w.Aof && n.server.Aof.Enabled() && synced >= w.AofOffset {
+		local = 1
+	}
+	return replicas, local
+}
+
+func (n *Node) waitSatisfied(c *client.Client) bool {
+	replicas, local := n.waitCounts(c)
+	return replicas >= c.Wait.NumReplicas && local >= c.Wait.NumLocal
+}
+
+// Unblocks the clients whose WAIT is satisfied
+func (n *Node) checkWaiting() {
+	for _, c := range slices.Clone(n.waiting) {
+		if n.waitSatisfied(c) {
+			n.unblock(c)
+		}
+	}
+}
+
+func (n *Node) waitTimedOut(expired waitExpired) {
+	// The client may have been unblocked already, or be blocked by a later WAIT
+	if expired.client.Wait == expired.request {
+		n.unblock(expired.client)
+	}
+}
+
+/*
+* Replies to a blocked client with the counts reached so far and runs the commands it sent
+* while it was blocked
+ */
+func (n *Node) unblock(c *client.Client) {
+	replicas, local := n.waitCounts(c)
+	if c.Wait.Aof {
+		n.write(resp.RESPSerializeRawArray([]string{resp.RESPSerializeInteger(local), resp.RESPSerializeInteger(replicas)}), c.Conn())
+	} else {
+		n.write(resp.RESPSerializeInteger(replicas), c.Conn())
+	}
+
+	c.Wait = nil
+	n.waiting = slices.DeleteFunc(n.waiting, func(w *client.Client) bool { return w == c })
+	deferred := n.deferred[c]
+	delete(n.deferred, c)
+	for _, cmd := range deferred {
+		n.processCommand(c, cmd)
+	}
+}
+
+// Forgets a blocked client that disconnected
+func (n *Node) removeWaiting(c *client.Client) {
+	c.Wait = nil
+	n.waiting = slices.DeleteFunc(n.waiting, func(w *client.Client) bool { return w == c })
+	delete(n.deferred, c)
+}
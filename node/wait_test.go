@@ -0,0 +1,144 @@
+package node
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/command"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// A connection that records what is written to it
+type recordConn struct {
+	net.Conn
+	written []string
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		c.written = append(c.written, string(b))
+	}
+	return len(b), nil
+}
+
+func newTestClient() (*client.Client, *recordConn) {
+	conn := &recordConn{}
+	return client.NewClient(conn, nil, nil), conn
+}
+
+// A master with online replicas, as if they had completed a full resynchronization
+func newTestNode(replicas int) (*Node, []*client.Client) {
+	config := map[string]string{"maxmemory": "0", "min-replicas-to-write": "0", "min-replicas-max-lag": "10"}
+	n := NewNode(command.NewServer(persistence.NewStore(), config, map[string]string{"replicaof": ""}), "0")
+	n.server.Replication.Psync("?", "-1")
+
+	clients := []*client.Client{}
+	for range replicas {
+		c, _ := newTestClient()
+		c.ReplicaState = client.ReplicaOnline
+		n.replicas = append(n.replicas, &replica{client: c})
+		clients = append(clients, c)
+	}
+	return n, clients
+}
+
+// REPLCONF ACK of a replica, with the offset fsynced to its AOF when fack is set
+func ack(n *Node, r *client.Client, offset int64, fack bool) {
+	cmd := []string{"REPLCONF", "ACK", strconv.FormatInt(offset, 10)}
+	if fack {
+		cmd = append(cmd, "FACK", strconv.FormatInt(offset, 10))
+	}
+	n.processCommand(r, cmd)
+}
+
+func TestWaitAcknowledgedReplicas(t *testing.T) {
+	n, replicas := newTestNode(2)
+	c, conn := newTestClient()
+
+	before := n.server.Replication.Offset()
+	n.processCommand(c, []string{"SET", "key", "value"})
+	n.processCommand(c, []string{"WAIT", "2", "0"})
+	if c.Wait == nil {
+		t.Fatalf("Expected WAIT to block until the replicas acknowledge the write")
+	}
+
+	// Sent while blocked, run in order once WAIT replied
+	n.processCommand(c, []string{"GET", "key"})
+	n.processCommand(c, []string{"SET", "key", "other"})
+	n.processCommand(c, []string{"GET", "key"})
+
+	ack(n, replicas[0], before, false)
+	ack(n, replicas[0], n.server.Replication.Offset(), false)
+	ack(n, replicas[1], before, false)
+	if c.Wait == nil || len(conn.written) != 1 {
+		t.Fatalf("Expected WAIT to be blocked with one replica acknowledging the write, got %q", conn.written)
+	}
+
+	ack(n, replicas[1], n.server.Replication.Offset(), false)
+	expected := []string{"+OK\r\n", ":2\r\n", "+value\r\n", "+OK\r\n", "+other\r\n"}
+	if c.Wait != nil || strings.Join(conn.written, "") != strings.Join(expected, "") {
+		t.Fatalf("Expected %q, got %q", expected, conn.written)
+	}
+	if len(n.waiting) != 0 || len(n.deferred) != 0 {
+		t.Fatalf("Expected no blocked client left")
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	n, replicas := newTestNode(3)
+	c, conn := newTestClient()
+
+	n.processCommand(c, []string{"SET", "key", "value"})
+	ack(n, replicas[0], n.server.Replication.Offset(), false)
+
+	// Enough replicas already acknowledged the writes
+	n.processCommand(c, []string{"WAIT", "1", "0"})
+	n.processCommand(c, []string{"WAIT", "2", "50"})
+	n.processCommand(c, []string{"PING"})
+	ack(n, replicas[1], n.server.Replication.Offset(), false)
+	// The timeout of a WAIT that already replied is ignored
+	n.waitTimedOut(<-n.waitTimeout)
+
+	expected := []string{"+OK\r\n", ":1\r\n", ":2\r\n", resp.RESPSerializeRESPArray([]string{"PONG"})}
+	if strings.Join(conn.written, "") != strings.Join(expected, "") {
+		t.Fatalf("Expected %q, got %q", expected, conn.written)
+	}
+
+	// The REPLCONF GETACK sent by the blocked WAIT moved the offset
+	ack(n, replicas[0], n.server.Replication.Offset(), false)
+	ack(n, replicas[1], n.server.Replication.Offset(), false)
+	n.processCommand(c, []string{"WAIT", "3", "50"})
+	n.waitTimedOut(<-n.waitTimeout)
+	if reply := conn.written[len(conn.written)-1]; reply != ":2\r\n" {
+		t.Fatalf("Expected the timeout to reply with the replicas that acknowledged, got %q", reply)
+	}
+}
+
+func TestWaitaof(t *testing.T) {
+	n, replicas := newTestNode(2)
+	c, conn := newTestClient()
+
+	n.processCommand(c, []string{"WAITAOF", "1", "0", "0"})
+	if reply := conn.written[0]; !strings.HasPrefix(reply, "-ERR WAITAOF cannot be used when numlocal is set") {
+		t.Fatalf("Expected WAITAOF to need appendonly for numlocal, got %q", reply)
+	}
+
+	n.processCommand(c, []string{"SET", "key", "value"})
+	n.processCommand(c, []string{"WAITAOF", "0", "1", "0"})
+
+	// Only an offset fsynced to the AOF of the replica counts
+	ack(n, replicas[0], n.server.Replication.Offset(), false)
+	if c.Wait == nil {
+		t.Fatalf("Expected WAITAOF to wait for a replica to fsync the write")
+	}
+	ack(n, replicas[1], n.server.Replication.Offset(), true)
+
+	expected := resp.RESPSerializeRawArray([]string{resp.RESPSerializeInteger(0), resp.RESPSerializeInteger(1)})
+	if c.Wait != nil || conn.written[len(conn.written)-1] != expected {
+		t.Fatalf("Expected %q, got %q", expected, conn.written)
+	}
+}
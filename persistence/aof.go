@@ -0,0 +1,472 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+type AppendFsync string
+
+// How often the AOF is flushed to disk
+const (
+	FsyncAlways   AppendFsync = "always"   // After every write, before the client gets its reply
+	FsyncEverySec AppendFsync = "everysec" // Once per second, at most a second of writes is lost
+	FsyncNo       AppendFsync = "no"       // Whenever the OS decides to
+)
+
+const (
+	DefaultAofFileName = "appendonly.aof"
+	DefaultAofDirName  = "appendonlydir"
+)
+
+var (
+	ErrAofTruncated         = errors.New("AOF file is truncated")
+	ErrAofRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+)
+
+/*
+* Append only file: every write command executed by the master is appended in RESP form, so the
+* dataset can be rebuilt by replaying it. Like Redis 7 the AOF is made of several files in
+* appenddirname, listed in a manifest: a base file with a snapshot of the dataset and incr files
+* with the commands written after it. A rewrite replaces all of them with a new base
+ */
+type Aof struct {
+	store          *Store
+	dir            string
+	dirName        string
+	fileName       string
+	fsync          AppendFsync
+	loadTruncated  bool
+	useRdbPreamble bool
+	// Automatic rewrite once the AOF grew by this percentage since the last rewrite and is at least minSize
+	rewritePercentage int
+	rewriteMinSize    int64
+
+	// Files of the AOF, nil when the files on disk are not the ones being appended to
+	manifest *aofManifest
+	// Incr file commands are appended to, nil when the AOF is disabled
+	file *os.File
+	// Whether there are writes that were not fsynced yet
+	pending bool
+	// Bytes appended since the server started and how many of them are known to be fsynced
+	appended     int64
+	synced       int64
+	lastWriteErr error
+	// Size of all the files of the AOF, and of the AOF right after the last rewrite
+	size     int64
+	baseSize int64
+
+	rewriteInProgress bool
+	lastRewriteErr    error
+
+	mu sync.Mutex
+}
+
+// Result of checking an AOF file, the file is valid if it is valid up to its full size
+type AofCheck struct {
+	Size    int64
+	ValidTo int64
+	// Number of commands up to ValidTo
+	Commands int
+}
+
+func NewAof(store *Store) *Aof {
+	return &Aof{
+		store:             store,
+		dirName:           DefaultAofDirName,
+		fileName:          DefaultAofFileName,
+		fsync:             FsyncEverySec,
+		loadTruncated:     true,
+		useRdbPreamble:    true,
+		rewritePercentage: 100,
+		rewriteMinSize:    64 * 1024 * 1024,
+	}
+}
+
+func ParseAppendFsync(policy string) (AppendFsync, error) {
+	switch p := AppendFsync(strings.ToLower(policy)); p {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid appendfsync: %s", policy)
+	}
+}
+
+func (a *Aof) SetDir(dir string) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.dir = dir
+}
+
+func (a *Aof) SetFileName(fileName string) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.fileName = fileName
+}
+
+func (a *Aof) SetDirName(dirName string) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.dirName = dirName
+}
+
+func (a *Aof) SetUseRdbPreamble(useRdbPreamble bool) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.useRdbPreamble = useRdbPreamble
+}
+
+func (a *Aof) SetAutoRewrite(percentage int, minSize int64) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.rewritePercentage, a.rewriteMinSize = percentage, minSize
+}
+
+func (a *Aof) SetFsync(fsync AppendFsync) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.fsync = fsync
+}
+
+func (a *Aof) SetLoadTruncated(loadTruncated bool) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	a.loadTruncated = loadTruncated
+}
+
+func (a *Aof) LoadTruncated() bool {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.loadTruncated
+}
+
+// Directory holding the files of the AOF and their manifest
+func (a *Aof) DirPath() string {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.dirPath()
+}
+
+func (a *Aof) dirPath() string {
+	return filepath.Join(a.dir, a.dirName)
+}
+
+func (a *Aof) manifestPath() string {
+	return filepath.Join(a.dirPath(), a.fileName+".manifest")
+}
+
+// Whether there is an AOF to load, either a manifest or a single file written before multi part AOFs
+func (a *Aof) Exists() bool {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	if _, err := os.Stat(a.manifestPath()); err == nil {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(a.dir, a.fileName))
+	return err == nil
+}
+
+func (a *Aof) Enabled() bool {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.file != nil
+}
+
+// Error of the last failed write or fsync, nil once a write succeeds again
+func (a *Aof) LastWriteError() error {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.lastWriteErr
+}
+
+/*
+* Number of bytes appended since the server started and how many of them are fsynced, WAITAOF
+* waits for the fsynced count to reach the appended count at the time it was called
+ */
+func (a *Aof) Offsets() (appended int64, synced int64) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.appended, a.synced
+}
+
+func (a *Aof) Size() int64 {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.size
+}
+
+/*
+* Starts appending to the AOF. Right after loading the AOF on startup the last incr file is
+* appended to, otherwise the files on disk are stale (or missing) and a new base is written
+* from the current dataset, so turning the AOF on for a running server does not lose any key
+ */
+func (a *Aof) Open() error {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	if a.file != nil {
+		return nil
+	}
+	if a.rewriteInProgress {
+		return ErrAofRewriteInProgress
+	}
+	if err := os.MkdirAll(a.dirPath(), 0755); err != nil {
+		return err
+	}
+
+	if a.manifest == nil {
+		return a.rewriteSync()
+	}
+
+	if len(a.manifest.incrs) == 0 {
+		m := a.manifest.clone()
+		file, err := a.openNewIncr(m)
+		if err != nil {
+			return err
+		}
+		a.manifest, a.file = m, file
+	} else {
+		last := a.manifest.incrs[len(a.manifest.incrs)-1]
+		file, err := os.OpenFile(filepath.Join(a.dirPath(), last.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		a.file = file
+	}
+
+	a.size = a.filesSize(a.manifest)
+	a.baseSize = a.size
+	return nil
+}
+
+// Stops appending to the AOF, flushing what was written so far
+func (a *Aof) Close() error {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	if a.file == nil {
+		return nil
+	}
+
+	err := a.file.Sync()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	// Writes from now on are not logged, the files on disk become stale
+	a.file, a.pending, a.manifest = nil, false, nil
+	return err
+}
+
+/*
+* Appends a serialized command. With appendfsync always the write is on disk when this returns,
+* callers append before replying so an acknowledged write is never lost
+ */
+func (a *Aof) Append(serializedCommand string) {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	if a.file == nil {
+		return
+	}
+
+	n, err := a.file.WriteString(serializedCommand)
+	a.size += int64(n)
+	a.appended += int64(n)
+	if err == nil && a.fsync == FsyncAlways {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		slog.Error("Error writing to the AOF", "err", err)
+		a.lastWriteErr = err
+		return
+	}
+
+	a.lastWriteErr = nil
+	a.pending = a.fsync != FsyncAlways
+	if !a.pending {
+		a.synced = a.appended
+	}
+}
+
+// Flushes the AOF to disk once per second when appendfsync is everysec
+func (a *Aof) FsyncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.mu.Lock()
+		if a.file != nil && a.pending && a.fsync == FsyncEverySec {
+			if err := a.file.Sync(); err != nil {
+				slog.Error("Error syncing the AOF", "err", err)
+				a.lastWriteErr = err
+			} else {
+				a.pending, a.synced = false, a.appended
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+/*
+* Replays the AOF: the base file and then every incr file listed in the manifest. RDB data (a base
+* in RDB format or a preamble) is passed to loadRdb and every command to apply. If the last file ends
+* in the middle of a command and aof-load-truncated is set, the incomplete command is cut off and
+* the load succeeds. A single AOF file written before multi part AOFs is moved into the AOF directory
+* and becomes the base file
+ */
+func (a *Aof) Load(loadRdb func(*RdbFile), apply func([]string)) error {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	m, err := loadAofManifest(a.manifestPath())
+	if os.IsNotExist(err) {
+		m, err = a.upgradeSingleFile()
+	}
+	if err != nil {
+		return err
+	}
+
+	files := m.files()
+	for i, f := range files {
+		path := filepath.Join(a.dirPath(), f.name)
+		check, err := readAof(path, loadRdb, apply)
+		if !errors.Is(err, ErrAofTruncated) {
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+			continue
+		}
+
+		// Only the file being appended to when the server stopped can be truncated by a crash
+		if !a.loadTruncated || i != len(files)-1 {
+			return fmt.Errorf("%s: %w: valid up to byte %d of %d, use the check-aof tool to fix it", f.name, err, check.ValidTo, check.Size)
+		}
+		slog.Warn("AOF was truncated, removing the incomplete command at its end", "file", f.name, "validTo", check.ValidTo, "size", check.Size)
+		if err := os.Truncate(path, check.ValidTo); err != nil {
+			return err
+		}
+	}
+
+	// Files left behind by a rewrite that was interrupted right after switching the manifest
+	a.removeHistory(m)
+	a.manifest = m
+	return nil
+}
+
+func (a *Aof) upgradeSingleFile() (*aofManifest, error) {
+	legacy := filepath.Join(a.dir, a.fileName)
+	if _, err := os.Stat(legacy); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(a.dirPath(), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(legacy, filepath.Join(a.dirPath(), a.fileName)); err != nil {
+		return nil, err
+	}
+
+	m := &aofManifest{base: &aofFile{name: a.fileName, seq: 1, kind: aofFileBase}, baseSeq: 1}
+	if err := persistAofManifest(a.manifestPath(), m); err != nil {
+		return nil, err
+	}
+	slog.Info("Moved the AOF into the AOF directory as the base of a multi part AOF", "dir", a.dirPath())
+	return m, nil
+}
+
+// Total size of the files listed in the manifest
+func (a *Aof) filesSize(m *aofManifest) int64 {
+	size := int64(0)
+	for _, f := range m.files() {
+		if info, err := os.Stat(filepath.Join(a.dirPath(), f.name)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// Checks an AOF file without applying it, reporting up to which byte it is valid
+func CheckAof(path string) (AofCheck, error) {
+	return readAof(path, func(*RdbFile) {}, func([]string) {})
+}
+
+func readAof(path string, loadRdb func(*RdbFile), apply func([]string)) (AofCheck, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return AofCheck{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return AofCheck{}, err
+	}
+	check := AofCheck{Size: info.Size()}
+
+	// The RDB parser and the command reader share the buffer, commands start right after the preamble
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+	if header, _ := reader.Peek(5); bytes.Equal(header, []byte("REDIS")) {
+		rdb, err := parseRdb(reader)
+		if err != nil {
+			return check, fmt.Errorf("invalid AOF RDB preamble: %w", err)
+		}
+		loadRdb(rdb)
+		check.ValidTo = counter.n - int64(reader.Buffered())
+	}
+
+	commands := resp.NewReader(reader)
+	for {
+		command, n, err := commands.ReadCommand()
+		if err == io.EOF {
+			return check, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return check, ErrAofTruncated
+		}
+		if err != nil {
+			return check, fmt.Errorf("bad AOF format at byte %d: %w", check.ValidTo, err)
+		}
+
+		apply(command)
+		check.ValidTo += int64(n)
+		check.Commands++
+	}
+}
+
+// Counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
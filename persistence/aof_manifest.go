@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Kinds of files listed in the AOF manifest
+const (
+	aofFileBase    = "b" // Snapshot of the dataset the incr files apply to
+	aofFileIncr    = "i" // Commands appended after the base was taken
+	aofFileHistory = "h" // Files replaced by a rewrite, deleted once the new manifest is persisted
+)
+
+type aofFile struct {
+	name string
+	seq  int64
+	kind string
+}
+
+/*
+* The manifest lists the files making up the AOF, one per line:
+*
+*	file appendonly.aof.2.base.rdb seq 2 type b
+*	file appendonly.aof.3.incr.aof seq 3 type i
+*
+* Loading the AOF means loading the base file and then every incr file in order
+ */
+type aofManifest struct {
+	base    *aofFile
+	incrs   []aofFile
+	history []aofFile
+	// Highest sequence numbers used so far, new files always get a higher one
+	baseSeq int64
+	incrSeq int64
+}
+
+func loadAofManifest(path string) (*aofManifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &aofManifest{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid AOF manifest line: %s", line)
+		}
+		f := aofFile{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				f.name = fields[i+1]
+			case "seq":
+				if f.seq, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid AOF manifest seq: %s", line)
+				}
+			case "type":
+				f.kind = fields[i+1]
+			}
+		}
+		if f.name == "" || filepath.Base(f.name) != f.name {
+			return nil, fmt.Errorf("invalid AOF manifest file name: %s", line)
+		}
+
+		switch f.kind {
+		case aofFileBase:
+			if m.base != nil {
+				return nil, fmt.Errorf("AOF manifest has more than one base file")
+			}
+			m.base = &f
+			m.baseSeq = max(m.baseSeq, f.seq)
+		case aofFileIncr:
+			if len(m.incrs) > 0 && f.seq <= m.incrs[len(m.incrs)-1].seq {
+				return nil, fmt.Errorf("AOF manifest incr files are out of order")
+			}
+			m.incrs = append(m.incrs, f)
+			m.incrSeq = max(m.incrSeq, f.seq)
+		case aofFileHistory:
+			m.history = append(m.history, f)
+		default:
+			return nil, fmt.Errorf("invalid AOF manifest file type: %s", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Files to load, in order
+func (m *aofManifest) files() []aofFile {
+	files := []aofFile{}
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *aofManifest) marshal() string {
+	var sb strings.Builder
+	for _, f := range append(m.files(), m.history...) {
+		fmt.Fprintf(&sb, "file %s seq %d type %s\n", f.name, f.seq, f.kind)
+	}
+	return sb.String()
+}
+
+func (m *aofManifest) clone() *aofManifest {
+	c := *m
+	if m.base != nil {
+		base := *m.base
+		c.base = &base
+	}
+	c.incrs = append([]aofFile{}, m.incrs...)
+	c.history = append([]aofFile{}, m.history...)
+	return &c
+}
+
+/*
+* Writes the manifest to a temporary file and renames it over the old one, so after a crash the
+* manifest is either the old or the new one and always lists files that exist
+ */
+func persistAofManifest(path string, m *aofManifest) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.manifest")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.WriteString(m.marshal()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Makes a rename durable by syncing the directory holding the file
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Paths of the files listed in an AOF manifest, in the order they are loaded
+func AofManifestFiles(manifestPath string) ([]string, error) {
+	m, err := loadAofManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, f := range m.files() {
+		paths = append(paths, filepath.Join(filepath.Dir(manifestPath), f.name))
+	}
+	return paths, nil
+}
@@ -0,0 +1,303 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+func (a *Aof) RewriteInProgress() bool {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.rewriteInProgress
+}
+
+// Error of the last rewrite, nil if it succeeded
+func (a *Aof) LastRewriteError() error {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.lastRewriteErr
+}
+
+// Size of the AOF right after the last rewrite (or after it was loaded)
+func (a *Aof) BaseSize() int64 {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	return a.baseSize
+}
+
+/*
+* Whether the AOF grew enough since the last rewrite to rewrite it automatically, following
+* auto-aof-rewrite-percentage and auto-aof-rewrite-min-size
+ */
+func (a *Aof) ShouldRewrite() bool {
+	defer a.mu.Unlock()
+
+	a.mu.Lock()
+	if a.file == nil || a.rewriteInProgress || a.rewritePercentage <= 0 || a.size < a.rewriteMinSize {
+		return false
+	}
+	base := max(a.baseSize, 1)
+	return (a.size-base)*100/base >= int64(a.rewritePercentage)
+}
+
+/*
+* Rewrites the AOF in the background (BGREWRITEAOF). Commands are appended to a new incr file
+* from now on while a new base is written from a snapshot of the dataset. Once the base is on
+* disk the manifest is switched to it and the files it replaces are deleted. Until then the
+* manifest still lists the old files, so a crash in between loses nothing
+ */
+func (a *Aof) Rewrite() error {
+	a.mu.Lock()
+	if a.rewriteInProgress {
+		a.mu.Unlock()
+		return ErrAofRewriteInProgress
+	}
+	if err := os.MkdirAll(a.dirPath(), 0755); err != nil {
+		a.mu.Unlock()
+		return err
+	}
+
+	m := a.manifest
+	if m == nil {
+		var err error
+		if m, err = a.diskManifest(); err != nil {
+			a.mu.Unlock()
+			return err
+		}
+	}
+
+	firstIncr := ""
+	if a.file != nil {
+		m = m.clone()
+		file, err := a.openNewIncr(m)
+		if err != nil {
+			a.mu.Unlock()
+			return err
+		}
+		a.switchFile(file)
+		a.manifest = m
+		firstIncr = m.incrs[len(m.incrs)-1].name
+	}
+
+	snapshot := a.store.Snapshot()
+	base := a.newBase(m.baseSeq+1, snapshot)
+	path := filepath.Join(a.dirPath(), base.name)
+	a.rewriteInProgress = true
+	a.mu.Unlock()
+
+	go func() {
+		err := a.writeBase(path, snapshot)
+		if err == nil {
+			slog.Info("Background AOF rewrite terminated with success", "base", base.name, "keys", len(snapshot.Entries))
+		} else {
+			slog.Error("Background AOF rewrite failed", "err", err)
+			os.Remove(path)
+		}
+
+		defer a.mu.Unlock()
+
+		a.mu.Lock()
+		a.rewriteInProgress = false
+		if err == nil {
+			err = a.finishRewrite(base, firstIncr)
+		}
+		a.lastRewriteErr = err
+	}()
+	return nil
+}
+
+/*
+* Switches the manifest to the new base. Incr files opened before the rewrite started are
+* covered by the base and replaced along with the old base
+ */
+func (a *Aof) finishRewrite(base aofFile, firstIncr string) error {
+	m := a.manifest
+	if m == nil {
+		// The AOF was disabled meanwhile, the base replaces whatever is on disk
+		var err error
+		if m, err = a.diskManifest(); err != nil {
+			return err
+		}
+		firstIncr = ""
+	}
+
+	next := &aofManifest{base: &base, baseSeq: base.seq, incrSeq: m.incrSeq, history: m.history}
+	if m.base != nil {
+		next.history = append(next.history, *m.base)
+	}
+	covered := true
+	for _, f := range m.incrs {
+		if f.name == firstIncr {
+			covered = false
+		}
+		if covered {
+			next.history = append(next.history, f)
+		} else {
+			next.incrs = append(next.incrs, f)
+		}
+	}
+	for i := range next.history {
+		next.history[i].kind = aofFileHistory
+	}
+
+	if err := persistAofManifest(a.manifestPath(), next); err != nil {
+		return err
+	}
+	a.removeHistory(next)
+
+	if a.manifest != nil {
+		a.manifest = next
+		a.size = a.filesSize(next)
+		a.baseSize = a.size
+	}
+	return nil
+}
+
+/*
+* Writes a new base synchronously and starts a new incr file after it, used when the AOF is
+* turned on while the files on disk (if any) do not match the dataset
+ */
+func (a *Aof) rewriteSync() error {
+	m, err := a.diskManifest()
+	if err != nil {
+		return err
+	}
+
+	snapshot := a.store.Snapshot()
+	base := a.newBase(m.baseSeq+1, snapshot)
+	if err := a.writeBase(filepath.Join(a.dirPath(), base.name), snapshot); err != nil {
+		return err
+	}
+
+	next := &aofManifest{base: &base, baseSeq: base.seq, incrSeq: m.incrSeq, history: m.history}
+	for _, f := range m.files() {
+		f.kind = aofFileHistory
+		next.history = append(next.history, f)
+	}
+	file, err := a.openNewIncr(next)
+	if err != nil {
+		return err
+	}
+	a.removeHistory(next)
+
+	a.manifest, a.file = next, file
+	a.size = a.filesSize(next)
+	a.baseSize = a.size
+	return nil
+}
+
+// Manifest currently on disk, empty if there is none yet
+func (a *Aof) diskManifest() (*aofManifest, error) {
+	m, err := loadAofManifest(a.manifestPath())
+	if os.IsNotExist(err) {
+		return &aofManifest{}, nil
+	}
+	return m, err
+}
+
+/*
+* Creates the next incr file and adds it to the manifest, which is persisted so the file is
+* part of the AOF before anything is appended to it
+ */
+func (a *Aof) openNewIncr(m *aofManifest) (*os.File, error) {
+	m.incrSeq++
+	incr := aofFile{name: fmt.Sprintf("%s.%d.incr.aof", a.fileName, m.incrSeq), seq: m.incrSeq, kind: aofFileIncr}
+	path := filepath.Join(a.dirPath(), incr.name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.incrs = append(m.incrs, incr)
+	if err := persistAofManifest(a.manifestPath(), m); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return file, nil
+}
+
+// Flushes and closes the incr file being appended to and appends to file instead
+func (a *Aof) switchFile(file *os.File) {
+	if err := a.file.Sync(); err != nil {
+		slog.Error("Error syncing the AOF", "err", err)
+	} else {
+		a.synced = a.appended
+	}
+	a.file.Close()
+	a.file, a.pending = file, false
+}
+
+// Deletes the files replaced by a rewrite and drops them from the manifest
+func (a *Aof) removeHistory(m *aofManifest) {
+	if len(m.history) == 0 {
+		return
+	}
+	for _, f := range m.history {
+		if err := os.Remove(filepath.Join(a.dirPath(), f.name)); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error removing AOF history file", "file", f.name, "err", err)
+		}
+	}
+	m.history = nil
+	if err := persistAofManifest(a.manifestPath(), m); err != nil {
+		slog.Error("Error persisting the AOF manifest", "err", err)
+	}
+}
+
+/*
+* A base is an RDB file unless aof-use-rdb-preamble is off. Command form bases can only hold
+* strings, datasets with other types are still written in RDB form
+ */
+func (a *Aof) newBase(seq int64, snapshot *Snapshot) aofFile {
+	ext := "rdb"
+	if !a.useRdbPreamble {
+		ext = "aof"
+		for _, entry := range snapshot.Entries {
+			if entry.Object != nil {
+				slog.Warn("Dataset has non string keys, writing the AOF base in RDB form")
+				ext = "rdb"
+				break
+			}
+		}
+	}
+	return aofFile{name: fmt.Sprintf("%s.%d.base.%s", a.fileName, seq, ext), seq: seq, kind: aofFileBase}
+}
+
+func (a *Aof) writeBase(path string, snapshot *Snapshot) error {
+	if filepath.Ext(path) == ".rdb" {
+		snapshot.AofBase = true
+		return writeRdbFile(path, snapshot)
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return writeAofCommands(w, snapshot)
+	})
+}
+
+// Writes the dataset as the commands that recreate it
+func writeAofCommands(w io.Writer, snapshot *Snapshot) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range snapshot.Entries {
+		command := []string{"SET", string(entry.Key), string(entry.Value)}
+		if _, err := bw.WriteString(resp.RESPSerializeRESPArray(command)); err != nil {
+			return err
+		}
+		if entry.Expiration == nil {
+			continue
+		}
+		command = []string{"PEXPIREAT", string(entry.Key), strconv.FormatInt(entry.Expiration.UnixMilli(), 10)}
+		if _, err := bw.WriteString(resp.RESPSerializeRESPArray(command)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
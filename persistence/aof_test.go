@@ -0,0 +1,115 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadAofWithPreamble(t *testing.T) {
+	store := NewStore()
+	store.Set("base", []byte("1"), nil)
+
+	dir := t.TempDir()
+	aof := NewAof(store)
+	aof.SetDir(dir)
+	if err := aof.Open(); err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	aof.Append("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+	aof.Close()
+
+	loaded, commands := loadAof(t, dir)
+	if loaded == nil || loaded.Database["base"].Value != "1" || loaded.Metadata["aof-base"] != "1" {
+		t.Errorf("Unexpected RDB base: %v", loaded)
+	}
+	if strings.Join(commands, ",") != "SET foo bar" {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func TestLoadAofTruncated(t *testing.T) {
+	dir := t.TempDir()
+	aofDir := filepath.Join(dir, DefaultAofDirName)
+	os.MkdirAll(aofDir, 0755)
+	os.WriteFile(filepath.Join(aofDir, "appendonly.aof.manifest"), []byte("file appendonly.aof.1.incr.aof seq 1 type i\n"), 0644)
+
+	path := filepath.Join(aofDir, "appendonly.aof.1.incr.aof")
+	valid := "*2\r\n$3\r\nDEL\r\n$1\r\na\r\n"
+	os.WriteFile(path, []byte(valid+"*3\r\n$3\r\nSET\r\n$1"), 0644)
+
+	aof := NewAof(NewStore())
+	aof.SetDir(dir)
+	aof.SetLoadTruncated(false)
+	noop := func([]string) {}
+	if err := aof.Load(func(*RdbFile) {}, noop); !errors.Is(err, ErrAofTruncated) {
+		t.Fatalf("Expected a truncated AOF error, got %v", err)
+	}
+
+	aof.SetLoadTruncated(true)
+	if err := aof.Load(func(*RdbFile) {}, noop); err != nil {
+		t.Fatalf("Failed to load truncated AOF: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != valid {
+		t.Fatalf("Expected the incomplete command to be removed, got %q", content)
+	}
+}
+
+func TestAofRewrite(t *testing.T) {
+	store := NewStore()
+	store.Set("a", []byte("1"), nil)
+
+	dir := t.TempDir()
+	aof := NewAof(store)
+	aof.SetDir(dir)
+	aof.SetUseRdbPreamble(false)
+	if err := aof.Open(); err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	store.Set("a", []byte("2"), nil)
+	aof.Append("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n2\r\n")
+
+	if err := aof.Rewrite(); err != nil {
+		t.Fatalf("Failed to start rewrite: %v", err)
+	}
+	aof.Append("*2\r\n$3\r\nDEL\r\n$1\r\na\r\n")
+	for aof.RewriteInProgress() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := aof.LastRewriteError(); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	aof.Close()
+
+	manifest, _ := os.ReadFile(filepath.Join(dir, DefaultAofDirName, "appendonly.aof.manifest"))
+	expected := "file appendonly.aof.2.base.aof seq 2 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"
+	if string(manifest) != expected {
+		t.Errorf("Unexpected manifest: %q", manifest)
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, DefaultAofDirName)); len(files) != 3 {
+		t.Errorf("Expected the replaced files to be removed, got %d files", len(files))
+	}
+
+	_, commands := loadAof(t, dir)
+	if strings.Join(commands, ",") != "SET a 2,DEL a" {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func loadAof(t *testing.T, dir string) (*RdbFile, []string) {
+	aof := NewAof(NewStore())
+	aof.SetDir(dir)
+
+	var loaded *RdbFile
+	commands := []string{}
+	err := aof.Load(func(rdb *RdbFile) { loaded = rdb }, func(command []string) {
+		commands = append(commands, strings.Join(command, " "))
+	})
+	if err != nil {
+		t.Fatalf("Failed to load AOF: %v", err)
+	}
+	return loaded, commands
+}
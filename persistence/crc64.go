@@ -0,0 +1,28 @@
+package persistence
+
+// Redis checksums RDB files with the Jones CRC64 polynomial, reflected, with no initial or final xor
+const crc64JonesPolynomial = 0x95ac9329ac4bc9b5
+
+var crc64Table = func() [256]uint64 {
+	var table [256]uint64
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64JonesPolynomial
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// Continues the checksum crc with the given bytes, start with a crc of 0
+func crc64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,15 @@
+package persistence
+
+import "testing"
+
+func TestCrc64(t *testing.T) {
+	// Reference value from the Redis source (crc64.c)
+	if got := crc64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("crc64 was incorrect. Expected: 0xe9c6d914c4b8d9ca, Received: %#x", got)
+	}
+
+	// The checksum can be computed incrementally
+	if got := crc64(crc64(0, []byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("incremental crc64 was incorrect. Received: %#x", got)
+	}
+}
@@ -0,0 +1,110 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+	ErrBadFormat   = errors.New("Bad data format")
+)
+
+/*
+* Serializes a value like DUMP: its RDB value type and value, followed by the RDB version and
+* the CRC64 of everything before it, both little endian. The key and its expiration are not part
+* of the payload
+ */
+func DumpEntry(entry Entry) []byte {
+	buf := &bytes.Buffer{}
+	rw := &rdbWriter{w: bufio.NewWriter(buf)}
+
+	rw.write([]byte{rdbValueType(entry.Object)})
+	rw.writeValue(entry)
+	version := make([]byte, 2)
+	binary.LittleEndian.PutUint16(version, rdbVersion)
+	rw.write(version)
+
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, rw.crc)
+	rw.w.Write(checksum)
+	rw.w.Flush()
+	return buf.Bytes()
+}
+
+/*
+* Decodes a payload of DUMP into the value of an entry. Payloads of a newer RDB version or with a
+* wrong checksum are rejected
+ */
+func ParseDumpPayload(payload []byte) (Entry, error) {
+	if len(payload) < 10 {
+		return Entry{}, ErrDumpPayload
+	}
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbMaxVersion {
+		return Entry{}, ErrDumpPayload
+	}
+	if crc64(0, payload[:len(payload)-8]) != binary.LittleEndian.Uint64(footer[2:]) {
+		return Entry{}, ErrDumpPayload
+	}
+
+	body := payload[:len(payload)-10]
+	rr := &rdbReader{r: bufio.NewReader(bytes.NewReader(body))}
+	valueType, err := rr.readByte()
+	if err != nil {
+		return Entry{}, ErrBadFormat
+	}
+	value, obj, err := rr.readObject(valueType)
+	if err != nil {
+		return Entry{}, ErrBadFormat
+	}
+	return Entry{Value: value, Object: obj}, nil
+}
+
+// A copy of the key with its value and expiration, for DUMP and MIGRATE. It is not an access
+func (s *Store) Entry(key string) (Entry, bool) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	val, ok := s.data[key]
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	if !ok {
+		return Entry{}, false
+	}
+	return Entry{Key: key, Value: val.val, Object: val.obj, Expiration: val.expiration}, true
+}
+
+/*
+* Stores a value of RESTORE, replacing whatever the key held. idle and freq set the access history
+* of the key like IDLETIME and FREQ, negative values keep the one of a new key
+ */
+func (s *Store) Restore(entry Entry, idle time.Duration, freq int) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(entry.Key)
+	_, exists := s.data[entry.Key]
+
+	newVal := newValue(entry.Value, entry.Expiration)
+	newVal.obj = entry.Object
+	if idle >= 0 {
+		newVal.lastAccess = time.Now().Add(-idle)
+	}
+	if freq >= 0 {
+		newVal.lfuCounter = uint8(freq)
+	}
+	s.setEntry(entry.Key, newVal)
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", entry.Key)
+	}
+	if !exists {
+		s.notify(EventNew, "new", entry.Key)
+	}
+	s.notify(EventGeneric, "restore", entry.Key)
+}
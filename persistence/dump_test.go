@@ -0,0 +1,50 @@
+package persistence
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDumpPayload(t *testing.T) {
+	// DUMP of the integer 10 by Redis, RDB version 10
+	entry, err := ParseDumpPayload([]byte("\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(entry.Value) != "10" || entry.Object != nil {
+		t.Errorf("Unexpected value: %q %v", entry.Value, entry.Object)
+	}
+
+	if _, err := ParseDumpPayload([]byte("\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbc")); !errors.Is(err, ErrDumpPayload) {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	for _, entry := range []Entry{
+		{Value: []byte("bin\r\n\x00ary")},
+		{Object: List{"a", "b"}},
+		{Object: Hash{"f": "v"}},
+		{Object: SortedSet{"m": 2.5}},
+	} {
+		restored, err := ParseDumpPayload(DumpEntry(entry))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(restored.Value) != string(entry.Value) || !reflect.DeepEqual(restored.Object, entry.Object) {
+			t.Errorf("Value did not round trip: %#v", restored)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s := NewStore()
+	s.Restore(Entry{Key: "k", Value: []byte("v")}, time.Hour, 100)
+
+	info, ok := s.Object("k")
+	if !ok || info.Idle < time.Hour || info.Freq != 100 {
+		t.Errorf("Unexpected access history: %+v", info)
+	}
+}
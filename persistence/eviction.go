@@ -0,0 +1,331 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EvictionPolicy string
+
+// Policies used to pick the keys that are evicted once maxmemory is reached
+const (
+	NoEviction     EvictionPolicy = "noeviction"
+	AllKeysLRU     EvictionPolicy = "allkeys-lru"
+	AllKeysLFU     EvictionPolicy = "allkeys-lfu"
+	AllKeysRandom  EvictionPolicy = "allkeys-random"
+	VolatileLRU    EvictionPolicy = "volatile-lru"
+	VolatileLFU    EvictionPolicy = "volatile-lfu"
+	VolatileRandom EvictionPolicy = "volatile-random"
+	VolatileTTL    EvictionPolicy = "volatile-ttl"
+)
+
+const (
+	// Estimated memory used by a key on top of the key and value bytes (map entry, value struct, string headers)
+	EntryOverhead = 64
+	// Estimated memory used by the expiration of a key
+	ExpireOverhead = 24
+
+	defaultMaxMemorySamples = 5
+	// Number of the best eviction candidates remembered between evictions
+	evictionPoolSize = 16
+
+	// Initial LFU counter of a new key, so new keys are not evicted before they get a chance to be accessed
+	lfuInitVal = 5
+	// The higher the factor, the more accesses are needed to increment the LFU counter
+	lfuLogFactor = 10
+	// The LFU counter is decremented by one for every period the key was not accessed
+	lfuDecayPeriod = time.Minute
+)
+
+var ErrOutOfMemory = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+type evictionCandidate struct {
+	key string
+	// The higher the score the better the candidate is for eviction
+	score uint64
+}
+
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(strings.ToLower(policy)); p {
+	case NoEviction, AllKeysLRU, AllKeysLFU, AllKeysRandom, VolatileLRU, VolatileLFU, VolatileRandom, VolatileTTL:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid maxmemory-policy: %s", policy)
+	}
+}
+
+/*
+* Parses a memory size the way Redis configs do: plain bytes or a number followed by
+* k, kb, m, mb, g or gb (k = 1000 bytes, kb = 1024 bytes)
+ */
+func ParseMemory(size string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	size = strings.ToLower(size)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSuffix(size, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size: %s", size)
+	}
+	return n * multiplier, nil
+}
+
+// Sets the memory limit in bytes, 0 means no limit
+func (s *Store) SetMaxMemory(maxMemory int64) {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	s.maxMemory = maxMemory
+}
+
+func (s *Store) SetEvictionPolicy(policy EvictionPolicy) {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	s.evictionPolicy = policy
+	s.evictionPool = nil
+}
+
+// Sets the number of keys sampled to find the best key to evict
+func (s *Store) SetMaxMemorySamples(samples int) {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	s.samples = samples
+}
+
+func (s *Store) MaxMemory() int64 {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return s.maxMemory
+}
+
+func (s *Store) EvictionPolicy() EvictionPolicy {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return s.evictionPolicy
+}
+
+// Estimated memory used by all keys and values in bytes
+func (s *Store) UsedMemory() int64 {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return s.usedMemory
+}
+
+// Number of keys evicted since the server started
+func (s *Store) EvictedKeys() int64 {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return s.evictedKeys
+}
+
+/*
+* Evicts keys according to the eviction policy until the used memory is back under maxmemory.
+* Returns ErrOutOfMemory if the memory could not be freed (noeviction or no key can be evicted)
+ */
+func (s *Store) PerformEvictions() error {
+	evicted := []string{}
+	var err error
+
+	s.mu.Lock()
+	for s.maxMemory > 0 && s.usedMemory > s.maxMemory {
+		key, ok := s.selectEvictionCandidate()
+		if !ok {
+			err = ErrOutOfMemory
+			break
+		}
+
+		s.deleteEntry(key)
+		s.evictedKeys++
+		evicted = append(evicted, key)
+	}
+	s.mu.Unlock()
+
+	for _, key := range evicted {
+		s.notify(EventEvicted, "evicted", key)
+	}
+	return err
+}
+
+// Picks the next key to evict. Must be called with the lock held
+func (s *Store) selectEvictionCandidate() (string, bool) {
+	switch s.evictionPolicy {
+	case AllKeysRandom:
+		for key := range s.data {
+			return key, true
+		}
+		return "", false
+	case VolatileRandom:
+		for key := range s.expires {
+			return key, true
+		}
+		return "", false
+	case AllKeysLRU, AllKeysLFU, VolatileLRU, VolatileLFU, VolatileTTL:
+		return s.evictFromPool()
+	default:
+		return "", false
+	}
+}
+
+/*
+* Approximates the eviction policy the way Redis does: a few keys are sampled and inserted into a
+* pool of the best candidates seen so far, the best candidate that still exists is evicted
+ */
+func (s *Store) evictFromPool() (string, bool) {
+	volatile := s.evictionPolicy == VolatileLRU || s.evictionPolicy == VolatileLFU || s.evictionPolicy == VolatileTTL
+	now := time.Now()
+
+	for {
+		sampled := 0
+		if volatile {
+			for key := range s.expires {
+				s.insertEvictionCandidate(key, s.evictionScore(s.data[key], now))
+				if sampled++; sampled == s.samples {
+					break
+				}
+			}
+		} else {
+			for key, val := range s.data {
+				s.insertEvictionCandidate(key, s.evictionScore(val, now))
+				if sampled++; sampled == s.samples {
+					break
+				}
+			}
+		}
+		if sampled == 0 {
+			return "", false
+		}
+
+		// The best candidates are at the end of the pool. Candidates might have been deleted since they were sampled
+		for len(s.evictionPool) > 0 {
+			candidate := s.evictionPool[len(s.evictionPool)-1]
+			s.evictionPool = s.evictionPool[:len(s.evictionPool)-1]
+
+			if _, ok := s.data[candidate.key]; !ok {
+				continue
+			}
+			if volatile && !s.expires[candidate.key] {
+				continue
+			}
+			return candidate.key, true
+		}
+	}
+}
+
+// Inserts the candidate into the pool, which is sorted by ascending score. Must be called with the lock held
+func (s *Store) insertEvictionCandidate(key string, score uint64) {
+	for i, candidate := range s.evictionPool {
+		if candidate.key == key {
+			s.evictionPool = append(s.evictionPool[:i], s.evictionPool[i+1:]...)
+			break
+		}
+	}
+
+	position := 0
+	for position < len(s.evictionPool) && s.evictionPool[position].score < score {
+		position++
+	}
+
+	if len(s.evictionPool) == evictionPoolSize {
+		// The pool is full and every candidate is better than this one
+		if position == 0 {
+			return
+		}
+		// Drop the worst candidate to make room
+		s.evictionPool = s.evictionPool[1:]
+		position--
+	}
+
+	s.evictionPool = append(s.evictionPool, evictionCandidate{})
+	copy(s.evictionPool[position+1:], s.evictionPool[position:])
+	s.evictionPool[position] = evictionCandidate{key: key, score: score}
+}
+
+func (s *Store) evictionScore(val *value, now time.Time) uint64 {
+	switch s.evictionPolicy {
+	case AllKeysLFU, VolatileLFU:
+		return uint64(255 - val.lfuDecayedCounter(now))
+	case VolatileTTL:
+		// Keys that expire sooner are better candidates
+		return math.MaxUint64 - uint64(val.expiration.UnixMilli())
+	default:
+		return uint64(now.Sub(val.lastAccess).Milliseconds())
+	}
+}
+
+func newValue(val []byte, expiration *time.Time) *value {
+	now := time.Now()
+	return &value{
+		expiration:   expiration,
+		val:          val,
+		lastAccess:   now,
+		lfuCounter:   lfuInitVal,
+		lfuDecayTime: now,
+	}
+}
+
+// Records an access to the value for the LRU and LFU policies
+func (v *value) touch() {
+	now := time.Now()
+	v.lastAccess = now
+	v.lfuCounter = lfuLogIncr(v.lfuDecayedCounter(now))
+	v.lfuDecayTime = now
+}
+
+// Returns the LFU counter decremented by the number of decay periods since it was last updated
+func (v *value) lfuDecayedCounter(now time.Time) uint8 {
+	periods := int64(now.Sub(v.lfuDecayTime) / lfuDecayPeriod)
+	if periods >= int64(v.lfuCounter) {
+		return 0
+	}
+	return v.lfuCounter - uint8(periods)
+}
+
+// Increments the counter with a probability that gets lower the higher the counter is
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+
+	baseval := float64(counter) - lfuInitVal
+	if baseval < 0 {
+		baseval = 0
+	}
+	if rand.Float64() < 1.0/(baseval*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+func entrySize(key string, val *value) int64 {
+	size := int64(EntryOverhead + len(key) + len(val.val) + objectSize(val.obj))
+	if val.expiration != nil {
+		size += ExpireOverhead
+	}
+	return size
+}
@@ -0,0 +1,80 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{
+		"0":     0,
+		"100":   100,
+		"1k":    1000,
+		"1kb":   1024,
+		"100mb": 100 * 1024 * 1024,
+		"2GB":   2 * 1024 * 1024 * 1024,
+	}
+
+	for size, expected := range tests {
+		got, err := ParseMemory(size)
+		if err != nil {
+			t.Errorf("ParseMemory(%q) returned error: %s", size, err.Error())
+		}
+		if got != expected {
+			t.Errorf("ParseMemory(%q) was incorrect. Expected: %d, Received: %d", size, expected, got)
+		}
+	}
+
+	if _, err := ParseMemory("ten"); err == nil {
+		t.Errorf("ParseMemory should reject invalid sizes")
+	}
+}
+
+func TestPerformEvictionsNoEviction(t *testing.T) {
+	store := NewStore()
+	store.Set("foo", []byte("bar"), nil)
+	store.SetMaxMemory(1)
+
+	if err := store.PerformEvictions(); err != ErrOutOfMemory {
+		t.Errorf("Expected ErrOutOfMemory with noeviction, Received: %v", err)
+	}
+	if _, ok := store.Get("foo"); !ok {
+		t.Errorf("noeviction should never delete keys")
+	}
+}
+
+func TestPerformEvictionsAllKeysLRU(t *testing.T) {
+	store := NewStore()
+	store.SetEvictionPolicy(AllKeysLRU)
+	store.SetMaxMemorySamples(100)
+
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key%d", i), []byte("value"), nil)
+	}
+	// Every key except key0 was last used a minute ago
+	for key, val := range store.data {
+		if key != "key0" {
+			val.lastAccess = time.Now().Add(-time.Minute)
+		}
+	}
+
+	evicted := []string{}
+	store.OnKeyspaceEvent(func(event KeyspaceEvent) {
+		if event.Class == EventEvicted {
+			evicted = append(evicted, event.Key)
+		}
+	})
+
+	store.SetMaxMemory(store.UsedMemory() - 1)
+	if err := store.PerformEvictions(); err != nil {
+		t.Fatalf("Failed to perform evictions: %v", err)
+	}
+
+	if len(evicted) != 1 || evicted[0] == "key0" {
+		t.Errorf("Expected a single key other than key0 to be evicted, Received: %v", evicted)
+	}
+	if store.UsedMemory() > store.MaxMemory() {
+		t.Errorf("Used memory %d is still above maxmemory %d", store.UsedMemory(), store.MaxMemory())
+	}
+}
@@ -0,0 +1,173 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	listpackHeaderSize = 6
+	listpackEnd        = 0xff
+)
+
+/*
+* Decodes a listpack: a 4 byte total size, a 2 byte element count, the elements and an end byte.
+* Every element is an encoding byte, the integer or string data and the length of both (backlen).
+* Integers are returned in their decimal string form
+ */
+func listpackEntries(lp []byte) ([]string, error) {
+	if len(lp) < listpackHeaderSize+1 || int(binary.LittleEndian.Uint32(lp)) > len(lp) {
+		return nil, fmt.Errorf("invalid listpack header")
+	}
+
+	entries := []string{}
+	for pos := listpackHeaderSize; pos < len(lp); {
+		b := lp[pos]
+		if b == listpackEnd {
+			return entries, nil
+		}
+
+		var entry string
+		// Size of the encoding and data, and where the data starts for string entries
+		var size, header int
+		switch {
+		case b&0x80 == 0: // 0xxxxxxx - 7 bit unsigned integer
+			entry, size = strconv.Itoa(int(b)), 1
+		case b&0xc0 == 0x80: // 10xxxxxx - string of up to 63 bytes
+			header = 1
+			size = header + int(b&0x3f)
+		case b&0xe0 == 0xc0: // 110xxxxx - 13 bit signed integer
+			if pos+2 > len(lp) {
+				return nil, fmt.Errorf("listpack entry out of bounds")
+			}
+			n := int(b&0x1f)<<8 | int(lp[pos+1])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+			entry, size = strconv.Itoa(n), 2
+		case b&0xf0 == 0xe0: // 1110xxxx - string of up to 4095 bytes
+			if pos+2 > len(lp) {
+				return nil, fmt.Errorf("listpack entry out of bounds")
+			}
+			header = 2
+			size = header + (int(b&0x0f)<<8 | int(lp[pos+1]))
+		case b == 0xf0: // 32 bit string length
+			if pos+5 > len(lp) {
+				return nil, fmt.Errorf("listpack entry out of bounds")
+			}
+			header = 5
+			size = header + int(binary.LittleEndian.Uint32(lp[pos+1:]))
+		case b >= 0xf1 && b <= 0xf4: // 16, 24, 32 and 64 bit signed integers
+			width := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[b]
+			if pos+1+width > len(lp) {
+				return nil, fmt.Errorf("listpack entry out of bounds")
+			}
+			entry, size = strconv.FormatInt(littleEndianInt(lp[pos+1:pos+1+width]), 10), 1+width
+		default:
+			return nil, fmt.Errorf("invalid listpack encoding: %#x", b)
+		}
+
+		if pos+size > len(lp) {
+			return nil, fmt.Errorf("listpack entry out of bounds")
+		}
+		if header > 0 {
+			entry = string(lp[pos+header : pos+size])
+		}
+		entries = append(entries, entry)
+		pos += size + listpackBacklenSize(size)
+	}
+
+	return nil, fmt.Errorf("listpack is missing its end byte")
+}
+
+// Encodes the elements as a listpack, elements that are integers are stored as integers
+func encodeListpack(elements []string) []byte {
+	lp := make([]byte, listpackHeaderSize)
+	for _, element := range elements {
+		entry := listpackEncode(element)
+		lp = append(lp, entry...)
+		lp = append(lp, listpackBacklen(len(entry))...)
+	}
+	lp = append(lp, listpackEnd)
+
+	binary.LittleEndian.PutUint32(lp, uint32(len(lp)))
+	binary.LittleEndian.PutUint16(lp[4:], uint16(min(len(elements), 65535)))
+	return lp
+}
+
+func listpackEncode(element string) []byte {
+	if n, err := strconv.ParseInt(element, 10, 64); err == nil && strconv.FormatInt(n, 10) == element {
+		switch {
+		case n >= 0 && n <= 127:
+			return []byte{byte(n)}
+		case n >= -4096 && n <= 4095:
+			u := uint16(n) & 0x1fff
+			return []byte{0xc0 | byte(u>>8), byte(u)}
+		case n >= -32768 && n <= 32767:
+			return append([]byte{0xf1}, littleEndianBytes(n, 2)...)
+		case n >= -8388608 && n <= 8388607:
+			return append([]byte{0xf2}, littleEndianBytes(n, 3)...)
+		case n >= -2147483648 && n <= 2147483647:
+			return append([]byte{0xf3}, littleEndianBytes(n, 4)...)
+		default:
+			return append([]byte{0xf4}, littleEndianBytes(n, 8)...)
+		}
+	}
+
+	switch l := len(element); {
+	case l < 64:
+		return append([]byte{0x80 | byte(l)}, element...)
+	case l < 4096:
+		return append([]byte{0xe0 | byte(l>>8), byte(l)}, element...)
+	default:
+		entry := []byte{0xf0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(entry[1:], uint32(l))
+		return append(entry, element...)
+	}
+}
+
+// The backlen stores the entry size in 7 bit groups, most significant first, so it can be read backwards
+func listpackBacklen(size int) []byte {
+	backlen := make([]byte, listpackBacklenSize(size))
+	for i := len(backlen) - 1; i >= 0; i-- {
+		backlen[i] = byte(size&127) | 128
+		size >>= 7
+	}
+	// Only the first byte has the continuation bit cleared
+	backlen[0] &= 127
+	return backlen
+}
+
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// Reads a little endian two's complement integer of 1 to 8 bytes
+func littleEndianInt(buf []byte) int64 {
+	var u uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(buf[i])
+	}
+	shift := 64 - 8*len(buf)
+	return int64(u<<shift) >> shift
+}
+
+func littleEndianBytes(n int64, width int) []byte {
+	buf := make([]byte, width)
+	for i := range buf {
+		buf[i] = byte(n >> (8 * i))
+	}
+	return buf
+}
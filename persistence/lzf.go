@@ -0,0 +1,58 @@
+package persistence
+
+import "fmt"
+
+/*
+* Decompresses LZF data as written by Redis. The input is a sequence of chunks, the 3 most
+* significant bits of the control byte tell the chunk type:
+*   000LLLLL                    - literal run of L+1 bytes
+*   LLLooooo oooooooo           - back reference of length L+2 at offset o+1
+*   111ooooo LLLLLLLL oooooooo  - back reference of length L+9 at offset o+1
+ */
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			length := ctrl + 1
+			if i+length > len(in) {
+				return nil, fmt.Errorf("lzf literal run out of bounds")
+			}
+			out = append(out, in[i:i+length]...)
+			i += length
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("lzf back reference out of bounds")
+			}
+			length += int(in[i])
+			i++
+		}
+		length += 2
+
+		if i >= len(in) {
+			return nil, fmt.Errorf("lzf back reference out of bounds")
+		}
+		ref := len(out) - ((ctrl&0x1f)<<8 | int(in[i])) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("lzf back reference before start of output")
+		}
+
+		// The reference can overlap the bytes being written, so copy byte by byte
+		for j := 0; j < length; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, fmt.Errorf("lzf decompressed %d bytes, expected %d", len(out), outLen)
+	}
+	return out, nil
+}
@@ -0,0 +1,25 @@
+package persistence
+
+// Classes of keyspace events, these map to the flags of the notify-keyspace-events config
+type EventClass int
+
+const (
+	EventGeneric EventClass = 1 << iota // g - generic commands like DEL, EXPIRE, RENAME
+	EventString                         // $ - string commands
+	EventList                           // l - list commands
+	EventSet                            // s - set commands
+	EventHash                           // h - hash commands
+	EventZset                           // z - sorted set commands
+	EventExpired                        // x - a key expired
+	EventEvicted                        // e - a key was evicted because of maxmemory
+	EventStream                         // t - stream commands
+	EventKeyMiss                        // m - a key was read but did not exist
+	EventNew                            // n - a new key was added
+)
+
+// A KeyspaceEvent is emitted by the store every time a key is modified, expires or is evicted
+type KeyspaceEvent struct {
+	Class EventClass
+	Event string
+	Key   string
+}
@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// Strings up to this length are allocated together with their object header in Redis
+	embstrSizeLimit = 44
+	// Integers in [0, sharedIntegers) are shared objects in Redis, their refcount never changes
+	sharedIntegers = 10000
+	sharedRefCount = 2147483647
+)
+
+// Internal details about a key as reported by OBJECT and MEMORY USAGE
+type ObjectInfo struct {
+	Encoding string
+	RefCount int
+	Idle     time.Duration
+	Freq     uint8
+	Size     int64
+}
+
+// Breakdown of the memory used by the dataset
+type MemoryStats struct {
+	Peak    int64
+	Used    int64
+	Keys    int
+	Expires int
+	// Bytes of the keys and values themselves
+	DatasetBytes int64
+	// Bytes used by the hash table entries, value headers and expirations
+	OverheadBytes int64
+}
+
+/*
+* Returns the internal details of a key without counting it as an access, so inspecting a key
+* does not change its idle time or access frequency
+ */
+func (s *Store) Object(key string) (ObjectInfo, bool) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	val, ok := s.data[key]
+
+	info := ObjectInfo{}
+	if ok {
+		now := time.Now()
+		info = ObjectInfo{
+			Encoding: stringEncoding(val.val),
+			RefCount: 1,
+			Idle:     now.Sub(val.lastAccess),
+			Freq:     val.lfuDecayedCounter(now),
+			Size:     entrySize(key, val),
+		}
+		if val.obj != nil {
+			info.Encoding = objectEncoding(val.obj)
+		} else if n, err := strconv.ParseInt(string(val.val), 10, 64); err == nil && n >= 0 && n < sharedIntegers {
+			info.RefCount = sharedRefCount
+		}
+	}
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	return info, ok
+}
+
+func (s *Store) MemoryStats() MemoryStats {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	overhead := int64(len(s.data))*EntryOverhead + int64(len(s.expires))*ExpireOverhead
+	return MemoryStats{
+		Peak:          s.peakMemory,
+		Used:          s.usedMemory,
+		Keys:          len(s.data),
+		Expires:       len(s.expires),
+		DatasetBytes:  s.usedMemory - overhead,
+		OverheadBytes: overhead,
+	}
+}
+
+// Returns the encoding Redis would use for a string value
+func stringEncoding(val []byte) string {
+	if len(val) <= 20 {
+		if _, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			return "int"
+		}
+	}
+	if len(val) <= embstrSizeLimit {
+		return "embstr"
+	}
+	return "raw"
+}
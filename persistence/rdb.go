@@ -4,21 +4,42 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"strconv"
 )
 
 const (
+	rdbOpCodeModuleAux     = 247 // F7 - module auxiliary data
+	rdbOpCodeIdle          = 248 // F8 - LRU idle time of the next key
+	rdbOpCodeFreq          = 249 // F9 - LFU frequency of the next key
 	rdbOpCodeMetaData      = 250 // FA - metadata section
-	rdbOpCodeDbSubsection  = 254 // FE - start of db section
-	rdbOpCodeExpiryMs      = 252 // FC - indicates key has exp in ms
 	rdbOpCodeHashTableSize = 251 // FB - indivicates start of hash table size
+	rdbOpCodeExpiryMs      = 252 // FC - indicates key has exp in ms
+	rdbOpCodeExpiry        = 253 // FD - indicates key has exp in seconds
+	rdbOpCodeDbSubsection  = 254 // FE - start of db section
 	rdbOpCodeEnd           = 255 // FF - end of rdb file
 )
 
+const (
+	rdbEncodingInt8  = 0 // String stored as an 8 bit integer
+	rdbEncodingInt16 = 1 // String stored as a 16 bit integer
+	rdbEncodingInt32 = 2 // String stored as a 32 bit integer
+	rdbEncodingLzf   = 3 // LZF compressed string
+)
+
+// Newest RDB version the parser understands
+const rdbMaxVersion = 12
+
+var ErrRdbChecksum = errors.New("rdb checksum mismatch")
+
 type data struct {
-	Value      string
+	// Value of a string key
+	Value string
+	// Value of any other type, one of List, Set, Hash, SortedSet or *Stream
+	Object     any
 	Expiration *uint64
 }
 
@@ -26,222 +47,296 @@ type database = map[string]data
 
 type RdbFile struct {
 	header   string
-	metadata map[string]string
+	Version  int
+	Metadata map[string]string
+	// Keys of database 0
 	Database database
+	// Keys of every database selected in the file, by database index
+	Databases map[int]database
+}
+
+// rdbReader reads RDB encoded data while keeping a running CRC64 of everything read
+type rdbReader struct {
+	r   *bufio.Reader
+	crc uint64
 }
 
 func ParseRdb(data []byte) (*RdbFile, error) {
-	reader := bufio.NewReader(bytes.NewReader(data))
+	return parseRdb(bytes.NewReader(data))
+}
 
-	// Read header
-	header, err := readHeader(reader)
+func ParseRdbFile(rdbFile string) (*RdbFile, error) {
+	rdb, err := os.Open(rdbFile)
 	if err != nil {
 		return nil, err
 	}
+	defer rdb.Close()
 
-	if !strings.Contains(string(header), "REDIS") {
-		return nil, fmt.Errorf("invalid rdb header: %s", string(header))
-	}
-
-	// metadata := make(map[string]string)
-	db := make(database)
-
-	for {
-		b, err := reader.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-
-		switch b {
-		case rdbOpCodeMetaData:
-			// TODO: read metadata
-			continue
-		case rdbOpCodeDbSubsection:
-			db, err = readDatabase(reader)
-			if err != nil {
-				return nil, err
-			}
-		case rdbOpCodeEnd:
-			return &RdbFile{header: string(header), metadata: nil, Database: db}, nil
-		default:
-			continue
-		}
-
-	}
+	return parseRdb(rdb)
 }
 
-func ParseRdbFile(rdbFile string) (*RdbFile, error) {
-	rdb, err := os.Open(rdbFile)
+/*
+* Parses a whole RDB file: header, aux fields, any number of database sections and the EOF opcode
+* followed by the CRC64 checksum. A checksum of 0 means the file was written with checksums disabled
+ */
+func parseRdb(r io.Reader) (*RdbFile, error) {
+	rr := &rdbReader{r: bufio.NewReader(r)}
+
+	header, err := rr.readFull(9)
 	if err != nil {
 		return nil, err
 	}
-	defer rdb.Close()
-	reader := bufio.NewReader(rdb)
-
-	// Read header
-	header, err := readHeader(reader)
+	version, err := parseHeader(header)
 	if err != nil {
 		return nil, err
 	}
 
-	if !strings.Contains(string(header), "REDIS") {
-		return nil, fmt.Errorf("invalid rdb header: %s", string(header))
+	rdb := &RdbFile{
+		header:    string(header),
+		Version:   version,
+		Metadata:  map[string]string{},
+		Databases: map[int]database{},
 	}
+	db := database{}
+	rdb.Databases[0] = db
 
-	// metadata := make(map[string]string)
-	db := make(database)
+	// Expiration of the next key, set by the expiry opcodes that precede it
+	var expiration *uint64
 
 	for {
-		b, err := reader.ReadByte()
+		opcode, err := rr.readByte()
 		if err != nil {
 			return nil, err
 		}
 
-		switch b {
+		switch opcode {
 		case rdbOpCodeMetaData:
-			// TODO: read metadata
-			continue
-		case rdbOpCodeDbSubsection:
-			db, err = readDatabase(reader)
+			key, err := rr.readString()
 			if err != nil {
 				return nil, err
 			}
-		case rdbOpCodeEnd:
-			return &RdbFile{header: string(header), metadata: nil, Database: db}, nil
-		default:
-			continue
-		}
-
-	}
-}
-
-func readDatabase(reader *bufio.Reader) (map[string]data, error) {
-	_, err := reader.ReadByte()
-	if err != nil {
-		return nil, err
-	}
-
-	db := database{}
-
-	for {
-		// Read opcode without moving the reader
-		peekOpcode, err := reader.Peek(1)
-		if err != nil {
-			return nil, err
-		}
-		if peekOpcode[0] == rdbOpCodeEnd {
-			return db, nil
-		}
-		// Read opcode
-		opcode, err := reader.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-
-		switch opcode {
-		case rdbOpCodeEnd:
-			return db, nil
-		case rdbOpCodeHashTableSize:
-			_, err := reader.ReadByte()
+			val, err := rr.readString()
 			if err != nil {
 				return nil, err
 			}
-			_, err = reader.ReadByte()
+			rdb.Metadata[string(key)] = string(val)
+		case rdbOpCodeDbSubsection:
+			index, err := rr.readPlainLength()
 			if err != nil {
 				return nil, err
 			}
+			if _, ok := rdb.Databases[int(index)]; !ok {
+				rdb.Databases[int(index)] = database{}
+			}
+			db = rdb.Databases[int(index)]
+		case rdbOpCodeHashTableSize:
+			// Only a hint to presize the hash tables, the keys are counted while reading them
+			if _, err := rr.readPlainLength(); err != nil {
+				return nil, err
+			}
+			if _, err := rr.readPlainLength(); err != nil {
+				return nil, err
+			}
 		case rdbOpCodeExpiryMs:
-			// Next 8 bytes specify the timestamp
-			timestampBuf := make([]byte, 8)
-			t, err := reader.Read(timestampBuf)
-			// Convert the byte slice to an int64 (litt-endian encoding)
-			timestamp := binary.LittleEndian.Uint64(timestampBuf[:t])
-
+			buf, err := rr.readFull(8)
 			if err != nil {
 				return nil, err
 			}
-
-			_, err = reader.ReadByte()
+			timestamp := binary.LittleEndian.Uint64(buf)
+			expiration = &timestamp
+		case rdbOpCodeExpiry:
+			buf, err := rr.readFull(4)
 			if err != nil {
 				return nil, err
 			}
-			key, value, err := readKeyValue(reader)
-			if err != nil {
+			timestamp := uint64(binary.LittleEndian.Uint32(buf)) * 1000
+			expiration = &timestamp
+		case rdbOpCodeIdle:
+			// The store tracks access times itself, the idle time of a loaded key starts from 0
+			if _, err := rr.readPlainLength(); err != nil {
+				return nil, err
+			}
+		case rdbOpCodeFreq:
+			if _, err := rr.readByte(); err != nil {
+				return nil, err
+			}
+		case rdbOpCodeModuleAux:
+			return nil, fmt.Errorf("rdb module aux data is not supported")
+		case rdbOpCodeEnd:
+			if err := rr.verifyChecksum(version); err != nil {
 				return nil, err
 			}
-			db[string(key)] = data{Value: string(value), Expiration: &timestamp}
+			rdb.Database = rdb.Databases[0]
+			return rdb, nil
 		default:
-			// No expiration is specified so we just need to read key/value
-			key, value, err := readKeyValue(reader)
+			// Anything else is the value type of a key/value pair
+			key, err := rr.readString()
 			if err != nil {
 				return nil, err
 			}
-			db[string(key)] = data{Value: string(value), Expiration: nil}
-
+			value, obj, err := rr.readObject(opcode)
+			if err != nil {
+				return nil, err
+			}
+			db[string(key)] = data{Value: string(value), Object: obj, Expiration: expiration}
+			expiration = nil
 		}
 	}
 }
 
-func readKeyValue(reader *bufio.Reader) (string, string, error) {
-	keyLen, err := reader.ReadByte()
-	if err != nil {
-		return "", "", err
+// The header is "REDIS" followed by a 4 digit version
+func parseHeader(header []byte) (int, error) {
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return 0, fmt.Errorf("invalid rdb header: %s", string(header))
 	}
-	keyBuf := make([]byte, keyLen)
-	k, err := reader.Read(keyBuf)
-	if err != nil {
-		return "", "", err
+
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > rdbMaxVersion {
+		return 0, fmt.Errorf("unsupported rdb version: %s", string(header[5:]))
 	}
-	valueLen, err := reader.ReadByte()
-	if err != nil {
-		return "", "", err
+	return version, nil
+}
+
+/*
+* RDB files from version 5 end with the CRC64 of everything before it. Older files have no
+* checksum and files written with rdbchecksum disabled have a checksum of 0
+ */
+func (rr *rdbReader) verifyChecksum(version int) error {
+	if version < 5 {
+		return nil
 	}
-	valueBuf := make([]byte, valueLen)
-	v, err := reader.Read(valueBuf)
-	if err != nil {
-		return "", "", err
+
+	expected := rr.crc
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		return fmt.Errorf("rdb checksum missing: %w", err)
 	}
 
-	return string(keyBuf[:k]), string(valueBuf[:v]), nil
+	checksum := binary.LittleEndian.Uint64(buf)
+	if checksum != 0 && checksum != expected {
+		return fmt.Errorf("%w: expected %016x, got %016x", ErrRdbChecksum, expected, checksum)
+	}
+	return nil
 }
 
-/*
-* This fn reads the metadata from the rdb file TODO: currently this just supports a single key/value pair.
-* extend this to support n key/values
- */
-func readMetadata(reader *bufio.Reader) (map[string]string, error) {
-	attrByte, err := reader.ReadByte()
+func (rr *rdbReader) readByte() (byte, error) {
+	b, err := rr.r.ReadByte()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	attrBuffer := make([]byte, attrByte)
-	n, err := reader.Read(attrBuffer)
-	if err != nil {
+	rr.crc = crc64(rr.crc, []byte{b})
+	return b, nil
+}
+
+func (rr *rdbReader) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	attrName := attrBuffer[:n]
+	rr.crc = crc64(rr.crc, buf)
+	return buf, nil
+}
 
-	attrValByte, err := reader.ReadByte()
+/*
+* Reads a length (see rdbWriter.writeLength). If the two most significant bits are 11 the
+* remaining 6 bits are not a length but the special encoding of the string that follows
+ */
+func (rr *rdbReader) readLength() (uint64, bool, error) {
+	first, err := rr.readByte()
 	if err != nil {
-		return nil, err
+		return 0, false, err
 	}
 
-	attrValBuffer := make([]byte, attrValByte)
-	m, err := reader.Read(attrValBuffer)
-	if err != nil {
-		return nil, err
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3f), false, nil
+	case 1:
+		next, err := rr.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch first {
+		case 0x80:
+			buf, err := rr.readFull(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := rr.readFull(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		default:
+			return 0, false, fmt.Errorf("invalid rdb length encoding: %#x", first)
+		}
+	default:
+		return uint64(first & 0x3f), true, nil
 	}
+}
 
-	return map[string]string{string(attrName): string(attrValBuffer[:m])}, nil
+// Reads a length that can not be a special string encoding
+func (rr *rdbReader) readPlainLength() (uint64, error) {
+	length, encoded, err := rr.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, fmt.Errorf("unexpected rdb string encoding where a length was expected")
+	}
+	return length, nil
 }
 
-func readHeader(reader *bufio.Reader) ([]byte, error) {
-	// Create a buffer for the header (size of the header is 9 bytes)
-	buffer := make([]byte, 9)
-	n, err := reader.Read(buffer)
+// Reads a string which is either length prefixed, an integer or LZF compressed
+func (rr *rdbReader) readString() ([]byte, error) {
+	length, encoded, err := rr.readLength()
 	if err != nil {
 		return nil, err
 	}
+	if !encoded {
+		return rr.readFull(int(length))
+	}
 
-	return buffer[:n], nil
+	switch length {
+	case rdbEncodingInt8:
+		buf, err := rr.readFull(1)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(buf[0])))), nil
+	case rdbEncodingInt16:
+		buf, err := rr.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case rdbEncodingInt32:
+		buf, err := rr.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf))))), nil
+	case rdbEncodingLzf:
+		compressedLen, err := rr.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		uncompressedLen, err := rr.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := rr.readFull(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(uncompressedLen))
+	default:
+		return nil, fmt.Errorf("unknown rdb string encoding: %d", length)
+	}
 }
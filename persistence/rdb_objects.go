@@ -0,0 +1,530 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RDB value types
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZset             = 3
+	rdbTypeHash             = 4
+	rdbTypeZset2            = 5
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZsetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZsetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+)
+
+const (
+	// Quicklist 2 node containers
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+
+	// Flags of an entry in a stream listpack
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+)
+
+/*
+* Reads the value of a key. Strings are returned as bytes, every other type is decoded into the
+* store object for it (List, Set, Hash, SortedSet or *Stream) whatever its encoding in the file
+ */
+func (rr *rdbReader) readObject(valueType byte) ([]byte, any, error) {
+	switch valueType {
+	case rdbTypeString:
+		val, err := rr.readString()
+		return val, nil, err
+	case rdbTypeList:
+		elements, err := rr.readStrings(1)
+		return nil, List(elements), err
+	case rdbTypeSet:
+		members, err := rr.readStrings(1)
+		return nil, newSet(members), err
+	case rdbTypeHash:
+		pairs, err := rr.readStrings(2)
+		return nil, newHash(pairs), err
+	case rdbTypeZset, rdbTypeZset2:
+		zset, err := rr.readZset(valueType)
+		return nil, zset, err
+	case rdbTypeHashZipmap:
+		pairs, err := rr.readEncoded(zipmapEntries)
+		return nil, newHash(pairs), err
+	case rdbTypeListZiplist:
+		elements, err := rr.readEncoded(ziplistEntries)
+		return nil, List(elements), err
+	case rdbTypeSetIntset:
+		members, err := rr.readEncoded(intsetEntries)
+		return nil, newSet(members), err
+	case rdbTypeSetListpack:
+		members, err := rr.readEncoded(listpackEntries)
+		return nil, newSet(members), err
+	case rdbTypeHashZiplist:
+		pairs, err := rr.readEncoded(ziplistEntries)
+		return nil, newHash(pairs), err
+	case rdbTypeHashListpack:
+		pairs, err := rr.readEncoded(listpackEntries)
+		return nil, newHash(pairs), err
+	case rdbTypeZsetZiplist, rdbTypeZsetListpack:
+		decode := listpackEntries
+		if valueType == rdbTypeZsetZiplist {
+			decode = ziplistEntries
+		}
+		pairs, err := rr.readEncoded(decode)
+		if err != nil {
+			return nil, nil, err
+		}
+		zset, err := newSortedSet(pairs)
+		return nil, zset, err
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		list, err := rr.readQuicklist(valueType)
+		return nil, list, err
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		stream, err := rr.readStream(valueType)
+		return nil, stream, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported rdb value type: %d", valueType)
+	}
+}
+
+// Reads a length followed by that many groups of strings
+func (rr *rdbReader) readStrings(groupSize int) ([]string, error) {
+	length, err := rr.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+
+	elements := []string{}
+	for i := uint64(0); i < length*uint64(groupSize); i++ {
+		element, err := rr.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, string(element))
+	}
+	return elements, nil
+}
+
+// Reads a string holding a compact encoding (ziplist, listpack, intset or zipmap) and decodes it
+func (rr *rdbReader) readEncoded(decode func([]byte) ([]string, error)) ([]string, error) {
+	blob, err := rr.readString()
+	if err != nil {
+		return nil, err
+	}
+	return decode(blob)
+}
+
+func (rr *rdbReader) readZset(valueType byte) (SortedSet, error) {
+	length, err := rr.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+
+	zset := SortedSet{}
+	for i := uint64(0); i < length; i++ {
+		member, err := rr.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if valueType == rdbTypeZset2 {
+			buf, err := rr.readFull(8)
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(buf))
+		} else if score, err = rr.readStringDouble(); err != nil {
+			return nil, err
+		}
+		zset[string(member)] = score
+	}
+	return zset, nil
+}
+
+// Old sorted set scores are a length byte followed by the score as text, with 253-255 for nan and infinities
+func (rr *rdbReader) readStringDouble() (float64, error) {
+	length, err := rr.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	buf, err := rr.readFull(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+/*
+* A quicklist is a number of nodes, each a ziplist (type 14) or, for quicklist 2 (type 18), a
+* container type followed by either a listpack or a single plain element
+ */
+func (rr *rdbReader) readQuicklist(valueType byte) (List, error) {
+	nodes, err := rr.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+
+	list := List{}
+	for i := uint64(0); i < nodes; i++ {
+		container := uint64(quicklistNodePacked)
+		if valueType == rdbTypeListQuicklist2 {
+			if container, err = rr.readPlainLength(); err != nil {
+				return nil, err
+			}
+		}
+
+		blob, err := rr.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case container == quicklistNodePlain:
+			list = append(list, string(blob))
+		case valueType == rdbTypeListQuicklist:
+			elements, err := ziplistEntries(blob)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elements...)
+		default:
+			elements, err := listpackEntries(blob)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elements...)
+		}
+	}
+	return list, nil
+}
+
+/*
+* Streams are stored as listpacks indexed by the ID of their first entry (the master entry), followed
+* by the stream metadata and the consumer groups with their pending entries and consumers
+ */
+func (rr *rdbReader) readStream(valueType byte) (*Stream, error) {
+	nodes, err := rr.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &Stream{Entries: []StreamEntry{}}
+	for i := uint64(0); i < nodes; i++ {
+		masterKey, err := rr.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(masterKey) != 16 {
+			return nil, fmt.Errorf("invalid stream node key length: %d", len(masterKey))
+		}
+
+		lp, err := rr.readEncoded(listpackEntries)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := decodeStreamListpack(decodeStreamID(masterKey), lp)
+		if err != nil {
+			return nil, err
+		}
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	// Number of entries, the entries were counted while reading the listpacks
+	length, err := rr.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	if stream.LastID, err = rr.readStreamID(); err != nil {
+		return nil, err
+	}
+
+	stream.EntriesAdded = length
+	if valueType >= rdbTypeStreamListpacks2 {
+		// The first ID is always the ID of the first entry
+		if _, err := rr.readStreamID(); err != nil {
+			return nil, err
+		}
+		if stream.MaxDeletedID, err = rr.readStreamID(); err != nil {
+			return nil, err
+		}
+		if stream.EntriesAdded, err = rr.readPlainLength(); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := rr.readPlainLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		group, err := rr.readStreamGroup(valueType)
+		if err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+func (rr *rdbReader) readStreamGroup(valueType byte) (StreamGroup, error) {
+	name, err := rr.readString()
+	if err != nil {
+		return StreamGroup{}, err
+	}
+	group := StreamGroup{Name: string(name), EntriesRead: -1}
+	if group.LastID, err = rr.readStreamID(); err != nil {
+		return group, err
+	}
+	if valueType >= rdbTypeStreamListpacks2 {
+		entriesRead, err := rr.readPlainLength()
+		if err != nil {
+			return group, err
+		}
+		group.EntriesRead = int64(entriesRead)
+	}
+
+	// Pending entries list of the group: raw ID, delivery time and delivery count
+	pending, err := rr.readPlainLength()
+	if err != nil {
+		return group, err
+	}
+	index := map[StreamID]int{}
+	for i := uint64(0); i < pending; i++ {
+		id, err := rr.readFull(16)
+		if err != nil {
+			return group, err
+		}
+		deliveryTime, err := rr.readMillisecondTime()
+		if err != nil {
+			return group, err
+		}
+		deliveryCount, err := rr.readPlainLength()
+		if err != nil {
+			return group, err
+		}
+
+		entry := StreamPendingEntry{ID: decodeStreamID(id), DeliveryTime: deliveryTime, DeliveryCount: deliveryCount}
+		index[entry.ID] = len(group.Pending)
+		group.Pending = append(group.Pending, entry)
+	}
+
+	// Consumers with the IDs of the pending entries they own
+	consumers, err := rr.readPlainLength()
+	if err != nil {
+		return group, err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		name, err := rr.readString()
+		if err != nil {
+			return group, err
+		}
+		consumer := StreamConsumer{Name: string(name)}
+		if consumer.SeenTime, err = rr.readMillisecondTime(); err != nil {
+			return group, err
+		}
+		consumer.ActiveTime = consumer.SeenTime
+		if valueType >= rdbTypeStreamListpacks3 {
+			if consumer.ActiveTime, err = rr.readMillisecondTime(); err != nil {
+				return group, err
+			}
+		}
+
+		owned, err := rr.readPlainLength()
+		if err != nil {
+			return group, err
+		}
+		for j := uint64(0); j < owned; j++ {
+			id, err := rr.readFull(16)
+			if err != nil {
+				return group, err
+			}
+			pos, ok := index[decodeStreamID(id)]
+			if !ok {
+				return group, fmt.Errorf("stream consumer %s owns an entry missing from the group PEL", consumer.Name)
+			}
+			group.Pending[pos].Consumer = consumer.Name
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+func (rr *rdbReader) readStreamID() (StreamID, error) {
+	ms, err := rr.readPlainLength()
+	if err != nil {
+		return StreamID{}, err
+	}
+	seq, err := rr.readPlainLength()
+	if err != nil {
+		return StreamID{}, err
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (rr *rdbReader) readMillisecondTime() (time.Time, error) {
+	buf, err := rr.readFull(8)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(binary.LittleEndian.Uint64(buf))), nil
+}
+
+/*
+* A stream listpack starts with the master entry: the entry count, the deleted count, the master
+* fields and a 0 terminator. Every entry then has flags, its ID relative to the master ID, its
+* fields (or only values if it has the master fields) and the number of elements it used
+ */
+func decodeStreamListpack(master StreamID, lp []string) ([]StreamEntry, error) {
+	pos := 0
+	next := func() (string, error) {
+		if pos >= len(lp) {
+			return "", fmt.Errorf("stream listpack is truncated")
+		}
+		pos++
+		return lp[pos-1], nil
+	}
+	nextInt := func() (int64, error) {
+		element, err := next()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(element, 10, 64)
+	}
+
+	// Skip the entry and deleted entry counts
+	for i := 0; i < 2; i++ {
+		if _, err := nextInt(); err != nil {
+			return nil, err
+		}
+	}
+	numMasterFields, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+	masterFields := []string{}
+	for i := int64(0); i < numMasterFields; i++ {
+		field, err := next()
+		if err != nil {
+			return nil, err
+		}
+		masterFields = append(masterFields, field)
+	}
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+
+	entries := []StreamEntry{}
+	for pos < len(lp) {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+		entry := StreamEntry{ID: StreamID{Ms: master.Ms + uint64(msDiff), Seq: master.Seq + uint64(seqDiff)}}
+
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range masterFields {
+				val, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, field, val)
+			}
+		} else {
+			numFields, err := nextInt()
+			if err != nil {
+				return nil, err
+			}
+			for i := int64(0); i < numFields*2; i++ {
+				element, err := next()
+				if err != nil {
+					return nil, err
+				}
+				entry.Fields = append(entry.Fields, element)
+			}
+		}
+
+		// lp-count, only needed to iterate the listpack backwards
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+		if flags&streamItemFlagDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Stream IDs in node keys and PELs are 16 big endian bytes so they sort correctly
+func decodeStreamID(raw []byte) StreamID {
+	return StreamID{Ms: binary.BigEndian.Uint64(raw), Seq: binary.BigEndian.Uint64(raw[8:])}
+}
+
+func encodeStreamID(id StreamID) []byte {
+	raw := make([]byte, 16)
+	binary.BigEndian.PutUint64(raw, id.Ms)
+	binary.BigEndian.PutUint64(raw[8:], id.Seq)
+	return raw
+}
+
+func newSet(members []string) Set {
+	set := Set{}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return set
+}
+
+func newHash(pairs []string) Hash {
+	hash := Hash{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		hash[pairs[i]] = pairs[i+1]
+	}
+	return hash
+}
+
+// Compact sorted sets store member and score pairs, with the score as an integer or as text
+func newSortedSet(pairs []string) (SortedSet, error) {
+	zset := SortedSet{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sorted set score: %s", pairs[i+1])
+		}
+		zset[pairs[i]] = score
+	}
+	return zset, nil
+}
@@ -0,0 +1,253 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Empty RDB file written by Redis 7.2, its aux fields use integer encoded strings
+const emptyRdbHex = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
+
+func TestParseRdbEmpty(t *testing.T) {
+	content, _ := hex.DecodeString(emptyRdbHex)
+
+	rdb, err := ParseRdb(content)
+	if err != nil {
+		t.Fatalf("Failed to parse RDB: %v", err)
+	}
+
+	expected := map[string]string{"redis-ver": "7.2.0", "redis-bits": "64", "ctime": "1706821741", "used-mem": "1098928", "aof-base": "0"}
+	for key, val := range expected {
+		if rdb.Metadata[key] != val {
+			t.Errorf("Metadata[%q] = %q, expected %q", key, rdb.Metadata[key], val)
+		}
+	}
+	if len(rdb.Database) != 0 {
+		t.Errorf("Expected no keys, got %d", len(rdb.Database))
+	}
+}
+
+func TestParseRdbChecksumMismatch(t *testing.T) {
+	content, _ := hex.DecodeString(emptyRdbHex)
+	content[len(content)-1] ^= 0xff
+
+	if _, err := ParseRdb(content); !errors.Is(err, ErrRdbChecksum) {
+		t.Fatalf("Expected checksum error, got %v", err)
+	}
+}
+
+func TestWriteRdbRoundTrip(t *testing.T) {
+	expiration := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	snapshot := &Snapshot{
+		Entries: []Entry{
+			{Key: "foo", Value: []byte("bar")},
+			{Key: "counter", Value: []byte("12345"), Expiration: &expiration},
+			{Key: strings.Repeat("k", 300), Value: []byte(strings.Repeat("v", 70000))},
+		},
+		CreatedAt: time.Now(),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRdb(&buf, snapshot); err != nil {
+		t.Fatalf("Failed to write RDB: %v", err)
+	}
+
+	rdb, err := ParseRdb(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse RDB: %v", err)
+	}
+
+	if len(rdb.Database) != len(snapshot.Entries) {
+		t.Fatalf("Expected %d keys, got %d", len(snapshot.Entries), len(rdb.Database))
+	}
+	for _, entry := range snapshot.Entries {
+		parsed, ok := rdb.Database[entry.Key]
+		if !ok || parsed.Value != string(entry.Value) {
+			t.Errorf("Key %.10q did not round trip", entry.Key)
+			continue
+		}
+		if entry.Expiration == nil && parsed.Expiration != nil {
+			t.Errorf("Key %.10q should not expire", entry.Key)
+		}
+		if entry.Expiration != nil && (parsed.Expiration == nil || *parsed.Expiration != uint64(entry.Expiration.UnixMilli())) {
+			t.Errorf("Key %.10q has wrong expiration", entry.Key)
+		}
+	}
+}
+
+func TestParseRdbEncodings(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("REDIS0011")
+
+	// Database 1 with seconds expiry, integer encoded and LZF compressed values
+	buf.Write([]byte{rdbOpCodeDbSubsection, 0x01, rdbOpCodeHashTableSize, 0x03, 0x01})
+	buf.Write([]byte{rdbOpCodeExpiry, 0x00, 0x00, 0x00, 0x00})
+	binary.LittleEndian.PutUint32(buf.Bytes()[buf.Len()-4:], 1700000000)
+	buf.Write([]byte{rdbTypeString, 0x03, 'i', '1', '6', 0xc1, 0x18, 0xfc})
+	buf.Write([]byte{rdbTypeString, 0x03, 'i', '3', '2', 0xc2, 0x40, 0xe2, 0x01, 0x00})
+	// "ab" literal followed by a back reference of 8 bytes at offset 1
+	buf.Write([]byte{rdbTypeString, 0x03, 'l', 'z', 'f', 0xc3, 0x05, 0x0a, 0x01, 'a', 'b', 0xc0, 0x01})
+
+	// Database 0 with a negative 8 bit integer
+	buf.Write([]byte{rdbOpCodeDbSubsection, 0x00})
+	buf.Write([]byte{rdbTypeString, 0x02, 'i', '8', 0xc0, 0xf6})
+
+	// A checksum of 0 disables verification
+	buf.Write([]byte{rdbOpCodeEnd, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	rdb, err := ParseRdb(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse RDB: %v", err)
+	}
+
+	expected := map[string]string{"i16": "-1000", "i32": "123456", "lzf": "ababababab"}
+	for key, val := range expected {
+		if rdb.Databases[1][key].Value != val {
+			t.Errorf("Value of %s = %q, expected %q", key, rdb.Databases[1][key].Value, val)
+		}
+	}
+	if exp := rdb.Databases[1]["i16"].Expiration; exp == nil || *exp != 1700000000000 {
+		t.Errorf("Expected i16 to expire at 1700000000000, got %v", exp)
+	}
+	if rdb.Database["i8"].Value != "-10" {
+		t.Errorf("Value of i8 = %q, expected -10", rdb.Database["i8"].Value)
+	}
+}
+
+func TestParseRdbUnknownValueType(t *testing.T) {
+	content := []byte("REDIS0011")
+	content = append(content, rdbOpCodeDbSubsection, 0x00, 0x42, 0x01, 'k', 0x01, 'v', rdbOpCodeEnd, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	if _, err := ParseRdb(content); err == nil {
+		t.Fatalf("Expected an error for an unknown value type")
+	}
+}
+
+func TestListpackRoundTrip(t *testing.T) {
+	elements := []string{"0", "127", "128", "-1", "4095", "-4096", "32767", "-8388608", "2147483647", "-9223372036854775808",
+		"", "hello", "007", strings.Repeat("x", 63), strings.Repeat("y", 200), strings.Repeat("z", 5000)}
+
+	decoded, err := listpackEntries(encodeListpack(elements))
+	if err != nil {
+		t.Fatalf("Failed to decode listpack: %v", err)
+	}
+	if strings.Join(decoded, ",") != strings.Join(elements, ",") {
+		t.Fatalf("Listpack did not round trip: %v", decoded)
+	}
+}
+
+func TestParseRdbCompactEncodings(t *testing.T) {
+	var buf bytes.Buffer
+	rw := &rdbWriter{w: bufio.NewWriter(&buf)}
+	rw.write([]byte("REDIS0011"))
+	rw.write([]byte{rdbOpCodeDbSubsection, 0x00})
+
+	// Ziplist with a string, an 8 bit integer and an immediate integer
+	ziplist := []byte{0, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0x00, 0x01, 'a', 0x03, 0xfe, 0x9c, 0x03, 0xf4, 0xff}
+	rw.write([]byte{rdbTypeListZiplist})
+	rw.writeString("ziplist")
+	rw.writeString(string(ziplist))
+
+	// Intset of 16 bit integers
+	intset := []byte{2, 0, 0, 0, 2, 0, 0, 0, 0xff, 0xff, 0x10, 0x00}
+	rw.write([]byte{rdbTypeSetIntset})
+	rw.writeString("intset")
+	rw.writeString(string(intset))
+
+	// Zipmap with one field and a free byte after the value
+	zipmap := []byte{1, 1, 'f', 2, 1, 'v', '1', 0, 0xff}
+	rw.write([]byte{rdbTypeHashZipmap})
+	rw.writeString("zipmap")
+	rw.writeString(string(zipmap))
+
+	rw.write([]byte{rdbTypeZsetListpack})
+	rw.writeString("zset")
+	rw.writeString(string(encodeListpack([]string{"a", "1", "b", "2.5"})))
+
+	rw.write([]byte{rdbTypeListQuicklist2})
+	rw.writeString("quicklist")
+	rw.writeLength(2)
+	rw.writeLength(quicklistNodePacked)
+	rw.writeString(string(encodeListpack([]string{"x", "y"})))
+	rw.writeLength(quicklistNodePlain)
+	rw.writeString("plain")
+
+	rw.write([]byte{rdbOpCodeEnd})
+	rw.write(make([]byte, 8))
+	rw.w.Flush()
+
+	rdb, err := ParseRdb(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse RDB: %v", err)
+	}
+
+	db := rdb.Database
+	if list := db["ziplist"].Object.(List); strings.Join(list, ",") != "a,-100,3" {
+		t.Errorf("Unexpected ziplist list: %v", list)
+	}
+	if set := db["intset"].Object.(Set); len(set) != 2 || set["-1"] != struct{}{} || set["16"] != struct{}{} {
+		t.Errorf("Unexpected intset set: %v", set)
+	}
+	if hash := db["zipmap"].Object.(Hash); len(hash) != 1 || hash["f"] != "v1" {
+		t.Errorf("Unexpected zipmap hash: %v", hash)
+	}
+	if zset := db["zset"].Object.(SortedSet); len(zset) != 2 || zset["a"] != 1 || zset["b"] != 2.5 {
+		t.Errorf("Unexpected listpack sorted set: %v", zset)
+	}
+	if list := db["quicklist"].Object.(List); strings.Join(list, ",") != "x,y,plain" {
+		t.Errorf("Unexpected quicklist list: %v", list)
+	}
+}
+
+func TestWriteRdbRoundTripTypes(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	stream := &Stream{
+		Entries: []StreamEntry{
+			{ID: StreamID{Ms: 1, Seq: 0}, Fields: []string{"temp", "20"}},
+			{ID: StreamID{Ms: 1, Seq: 1}, Fields: []string{"temp", "21"}},
+			{ID: StreamID{Ms: 2, Seq: 0}, Fields: []string{"humidity", "50", "temp", "22"}},
+		},
+		LastID:       StreamID{Ms: 3, Seq: 0},
+		MaxDeletedID: StreamID{Ms: 3, Seq: 0},
+		EntriesAdded: 4,
+		Groups: []StreamGroup{{
+			Name:        "workers",
+			LastID:      StreamID{Ms: 1, Seq: 1},
+			EntriesRead: 2,
+			Pending:     []StreamPendingEntry{{ID: StreamID{Ms: 1, Seq: 1}, Consumer: "alice", DeliveryTime: now, DeliveryCount: 3}},
+			Consumers:   []StreamConsumer{{Name: "alice", SeenTime: now, ActiveTime: now}},
+		}},
+	}
+	snapshot := &Snapshot{
+		Entries: []Entry{
+			{Key: "list", Object: List{"a", "b", "a"}},
+			{Key: "set", Object: Set{"x": {}, "y": {}}},
+			{Key: "hash", Object: Hash{"f": "v"}},
+			{Key: "zset", Object: SortedSet{"m": -1.5, "n": 3}},
+			{Key: "stream", Object: stream},
+		},
+		CreatedAt: now,
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRdb(&buf, snapshot); err != nil {
+		t.Fatalf("Failed to write RDB: %v", err)
+	}
+	rdb, err := ParseRdb(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse RDB: %v", err)
+	}
+
+	for _, entry := range snapshot.Entries {
+		if !reflect.DeepEqual(rdb.Database[entry.Key].Object, entry.Object) {
+			t.Errorf("Key %s did not round trip: %#v", entry.Key, rdb.Database[entry.Key].Object)
+		}
+	}
+}
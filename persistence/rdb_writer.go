@@ -0,0 +1,332 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const rdbVersion = 11
+
+// Version of Redis this server presents itself as in the RDB aux fields
+const RedisVersion = "7.2.0"
+
+// A key and its value as stored at the time a snapshot was taken
+type Entry struct {
+	Key   string
+	Value []byte
+	// Value of a non string key, one of List, Set, Hash, SortedSet or *Stream
+	Object     any
+	Expiration *time.Time
+}
+
+// Point in time copy of the store used to write RDB files while commands keep modifying the store
+type Snapshot struct {
+	Entries    []Entry
+	UsedMemory int64
+	CreatedAt  time.Time
+	// Value of the dirty counter when the snapshot was taken
+	Dirty int64
+	// Whether the snapshot is written as the RDB preamble of an AOF
+	AofBase bool
+}
+
+/*
+* Takes a consistent snapshot of every key. Values are never modified in place (a write always
+* stores a new value) so copying the references under the lock is enough
+ */
+func (s *Store) Snapshot() *Snapshot {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	now := time.Now()
+	snapshot := &Snapshot{
+		Entries:    make([]Entry, 0, len(s.data)),
+		UsedMemory: s.usedMemory,
+		CreatedAt:  now,
+		Dirty:      s.dirty,
+	}
+	for key, val := range s.data {
+		// Keys that already expired but were not removed yet are not worth saving
+		if val.expiration != nil && now.After(*val.expiration) {
+			continue
+		}
+		snapshot.Entries = append(snapshot.Entries, Entry{Key: key, Value: val.val, Object: val.obj, Expiration: val.expiration})
+	}
+	return snapshot
+}
+
+// rdbWriter writes RDB encoded data while keeping a running CRC64 of everything written
+type rdbWriter struct {
+	w   *bufio.Writer
+	crc uint64
+}
+
+/*
+* Serializes the snapshot in the RDB format:
+* header, aux fields, database selector, resize db, key/values (with 0xFC expirations), EOF and CRC64 checksum
+ */
+func WriteRdb(w io.Writer, snapshot *Snapshot) error {
+	rw := &rdbWriter{w: bufio.NewWriter(w)}
+
+	rw.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+
+	rw.writeAux("redis-ver", RedisVersion)
+	rw.writeAux("redis-bits", "64")
+	rw.writeAux("ctime", strconv.FormatInt(snapshot.CreatedAt.Unix(), 10))
+	rw.writeAux("used-mem", strconv.FormatInt(snapshot.UsedMemory, 10))
+	aofBase := "0"
+	if snapshot.AofBase {
+		aofBase = "1"
+	}
+	rw.writeAux("aof-base", aofBase)
+
+	if len(snapshot.Entries) > 0 {
+		expires := 0
+		for _, entry := range snapshot.Entries {
+			if entry.Expiration != nil {
+				expires++
+			}
+		}
+
+		rw.write([]byte{rdbOpCodeDbSubsection})
+		rw.writeLength(0)
+		rw.write([]byte{rdbOpCodeHashTableSize})
+		rw.writeLength(uint64(len(snapshot.Entries)))
+		rw.writeLength(uint64(expires))
+
+		for _, entry := range snapshot.Entries {
+			if entry.Expiration != nil {
+				timestamp := make([]byte, 8)
+				binary.LittleEndian.PutUint64(timestamp, uint64(entry.Expiration.UnixMilli()))
+				rw.write([]byte{rdbOpCodeExpiryMs})
+				rw.write(timestamp)
+			}
+			rw.writeObject(entry)
+		}
+	}
+
+	rw.write([]byte{rdbOpCodeEnd})
+
+	// The checksum covers everything up to and including the EOF opcode
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, rw.crc)
+	if _, err := rw.w.Write(checksum); err != nil {
+		return err
+	}
+
+	return rw.w.Flush()
+}
+
+func (rw *rdbWriter) write(data []byte) {
+	rw.crc = crc64(rw.crc, data)
+	// Errors are sticky in bufio.Writer and returned by Flush
+	_, _ = rw.w.Write(data)
+}
+
+func (rw *rdbWriter) writeAux(key string, val string) {
+	rw.write([]byte{rdbOpCodeMetaData})
+	rw.writeString(key)
+	rw.writeString(val)
+}
+
+func (rw *rdbWriter) writeString(str string) {
+	rw.writeLength(uint64(len(str)))
+	rw.write([]byte(str))
+}
+
+/*
+* Length encoding: the two most significant bits of the first byte tell how the length is stored
+*   00xxxxxx          - 6 bit length
+*   01xxxxxx xxxxxxxx - 14 bit length
+*   10000000 + 4 bytes - 32 bit big endian length
+*   10000001 + 8 bytes - 64 bit big endian length
+ */
+func (rw *rdbWriter) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		rw.write([]byte{byte(length)})
+	case length < 1<<14:
+		rw.write([]byte{byte(length>>8) | 0x40, byte(length)})
+	case length <= 1<<32-1:
+		buf := make([]byte, 5)
+		buf[0] = 0x80
+		binary.BigEndian.PutUint32(buf[1:], uint32(length))
+		rw.write(buf)
+	default:
+		buf := make([]byte, 9)
+		buf[0] = 0x81
+		binary.BigEndian.PutUint64(buf[1:], length)
+		rw.write(buf)
+	}
+}
+
+/*
+* Writes the value type, the key and the value. Collections use the plain encodings every Redis
+* version can load, streams use listpacks since there is no other encoding for them
+ */
+func (rw *rdbWriter) writeObject(entry Entry) {
+	rw.write([]byte{rdbValueType(entry.Object)})
+	rw.writeString(entry.Key)
+	rw.writeValue(entry)
+}
+
+func rdbValueType(obj any) byte {
+	switch obj.(type) {
+	case List:
+		return rdbTypeList
+	case Set:
+		return rdbTypeSet
+	case Hash:
+		return rdbTypeHash
+	case SortedSet:
+		return rdbTypeZset2
+	case *Stream:
+		return rdbTypeStreamListpacks3
+	default:
+		return rdbTypeString
+	}
+}
+
+// Writes the value in the encoding of its rdbValueType
+func (rw *rdbWriter) writeValue(entry Entry) {
+	switch obj := entry.Object.(type) {
+	case List:
+		rw.writeLength(uint64(len(obj)))
+		for _, element := range obj {
+			rw.writeString(element)
+		}
+	case Set:
+		rw.writeLength(uint64(len(obj)))
+		for member := range obj {
+			rw.writeString(member)
+		}
+	case Hash:
+		rw.writeLength(uint64(len(obj)))
+		for field, val := range obj {
+			rw.writeString(field)
+			rw.writeString(val)
+		}
+	case SortedSet:
+		rw.writeLength(uint64(len(obj)))
+		score := make([]byte, 8)
+		for _, member := range obj.Ordered() {
+			rw.writeString(member.Member)
+			binary.LittleEndian.PutUint64(score, math.Float64bits(member.Score))
+			rw.write(score)
+		}
+	case *Stream:
+		rw.writeStream(obj)
+	default:
+		rw.writeString(string(entry.Value))
+	}
+}
+
+// Entries per stream listpack node, the default stream-node-max-entries of Redis
+const streamNodeMaxEntries = 100
+
+func (rw *rdbWriter) writeStream(stream *Stream) {
+	nodes := (len(stream.Entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	rw.writeLength(uint64(nodes))
+	for start := 0; start < len(stream.Entries); start += streamNodeMaxEntries {
+		entries := stream.Entries[start:min(start+streamNodeMaxEntries, len(stream.Entries))]
+		rw.writeString(string(encodeStreamID(entries[0].ID)))
+		rw.writeString(string(encodeStreamListpack(entries)))
+	}
+
+	firstID := StreamID{}
+	if len(stream.Entries) > 0 {
+		firstID = stream.Entries[0].ID
+	}
+	rw.writeLength(uint64(len(stream.Entries)))
+	rw.writeStreamID(stream.LastID)
+	rw.writeStreamID(firstID)
+	rw.writeStreamID(stream.MaxDeletedID)
+	rw.writeLength(stream.EntriesAdded)
+
+	rw.writeLength(uint64(len(stream.Groups)))
+	for _, group := range stream.Groups {
+		rw.writeString(group.Name)
+		rw.writeStreamID(group.LastID)
+		rw.writeLength(uint64(group.EntriesRead))
+
+		rw.writeLength(uint64(len(group.Pending)))
+		for _, pending := range group.Pending {
+			rw.write(encodeStreamID(pending.ID))
+			rw.writeMillisecondTime(pending.DeliveryTime)
+			rw.writeLength(pending.DeliveryCount)
+		}
+
+		rw.writeLength(uint64(len(group.Consumers)))
+		for _, consumer := range group.Consumers {
+			rw.writeString(consumer.Name)
+			rw.writeMillisecondTime(consumer.SeenTime)
+			rw.writeMillisecondTime(consumer.ActiveTime)
+
+			owned := []StreamID{}
+			for _, pending := range group.Pending {
+				if pending.Consumer == consumer.Name {
+					owned = append(owned, pending.ID)
+				}
+			}
+			rw.writeLength(uint64(len(owned)))
+			for _, id := range owned {
+				rw.write(encodeStreamID(id))
+			}
+		}
+	}
+}
+
+func (rw *rdbWriter) writeStreamID(id StreamID) {
+	rw.writeLength(id.Ms)
+	rw.writeLength(id.Seq)
+}
+
+func (rw *rdbWriter) writeMillisecondTime(t time.Time) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(t.UnixMilli()))
+	rw.write(buf)
+}
+
+/*
+* Encodes stream entries as a listpack node, the first entry is the master entry. Entries with the
+* same fields as the master entry only store their values
+ */
+func encodeStreamListpack(entries []StreamEntry) []byte {
+	master := entries[0]
+	masterFields := []string{}
+	for i := 0; i < len(master.Fields); i += 2 {
+		masterFields = append(masterFields, master.Fields[i])
+	}
+
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	elements := []string{itoa(int64(len(entries))), "0", itoa(int64(len(masterFields)))}
+	elements = append(elements, masterFields...)
+	elements = append(elements, "0")
+
+	for _, entry := range entries {
+		sameFields := len(entry.Fields) == len(master.Fields)
+		for i := 0; sameFields && i < len(entry.Fields); i += 2 {
+			sameFields = entry.Fields[i] == master.Fields[i]
+		}
+
+		numFields := int64(len(entry.Fields) / 2)
+		msDiff, seqDiff := int64(entry.ID.Ms-master.ID.Ms), int64(entry.ID.Seq-master.ID.Seq)
+		if sameFields {
+			elements = append(elements, itoa(streamItemFlagSameFields), itoa(msDiff), itoa(seqDiff))
+			for i := 1; i < len(entry.Fields); i += 2 {
+				elements = append(elements, entry.Fields[i])
+			}
+			elements = append(elements, itoa(numFields+3))
+		} else {
+			elements = append(elements, "0", itoa(msDiff), itoa(seqDiff), itoa(numFields))
+			elements = append(elements, entry.Fields...)
+			elements = append(elements, itoa(2*numFields+4))
+		}
+	}
+	return encodeListpack(elements)
+}
@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"testing"
@@ -133,10 +134,11 @@ func TestParseRdbFile(t *testing.T) {
 	}
 
 	// Define database with keys and expirations
+	expiry := binary.LittleEndian.Uint64([]byte{0x15, 0x72, 0xE7, 0x07, 0x8F, 0x01, 0x00, 0x00}) // Expiry timestamp
 	db := database{
 		"foobar": {
 			Value:      "bazqux",
-			Expiration: &[]byte{0x15, 0x72, 0xE7, 0x07, 0x8F, 0x01, 0x00, 0x00}, // Expiry timestamp
+			Expiration: &expiry,
 		},
 		"foo": {
 			Value:      "bar",
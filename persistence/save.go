@@ -0,0 +1,262 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Save points used when none are configured, same as Redis
+const DefaultSavePoints = "3600 1 300 100 60 10000"
+
+var ErrSaveInProgress = errors.New("ERR Background save already in progress")
+
+// A snapshot is saved automatically once at least Changes writes happened in the last Seconds
+type SavePoint struct {
+	Seconds int
+	Changes int64
+}
+
+/*
+* Saver writes snapshots of the store to the RDB file, either synchronously (SAVE) or in
+* the background (BGSAVE and save points) while the store keeps serving commands
+ */
+type Saver struct {
+	store      *Store
+	dir        string
+	fileName   string
+	savePoints []SavePoint
+
+	inProgress bool
+	lastSave   time.Time
+	lastErr    error
+	mu         sync.Mutex
+}
+
+func NewSaver(store *Store) *Saver {
+	return &Saver{
+		store:    store,
+		fileName: "dump.rdb",
+		lastSave: time.Now(),
+		mu:       sync.Mutex{},
+	}
+}
+
+/*
+* Parses save points in the "<seconds> <changes> [<seconds> <changes> ...]" format of the
+* save config. An empty string disables automatic saving
+ */
+func ParseSavePoints(config string) ([]SavePoint, error) {
+	fields := strings.Fields(config)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save points: %s", config)
+	}
+
+	savePoints := []SavePoint{}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid save point seconds: %s", fields[i])
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes <= 0 {
+			return nil, fmt.Errorf("invalid save point changes: %s", fields[i+1])
+		}
+		savePoints = append(savePoints, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return savePoints, nil
+}
+
+func (sv *Saver) SetSavePoints(savePoints []SavePoint) {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	sv.savePoints = savePoints
+}
+
+func (sv *Saver) SetDir(dir string) {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	sv.dir = dir
+}
+
+func (sv *Saver) SetFileName(fileName string) {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	sv.fileName = fileName
+}
+
+// Path of the RDB file
+func (sv *Saver) Path() string {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	return filepath.Join(sv.dir, sv.fileName)
+}
+
+// Time of the last successful save
+func (sv *Saver) LastSave() time.Time {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	return sv.lastSave
+}
+
+func (sv *Saver) InProgress() bool {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	return sv.inProgress
+}
+
+// Error of the last save, nil if it succeeded
+func (sv *Saver) LastError() error {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	return sv.lastErr
+}
+
+// Saves the dataset and only returns once the file is written (SAVE)
+func (sv *Saver) Save() error {
+	if err := sv.start(); err != nil {
+		return err
+	}
+
+	return sv.save(sv.store.Snapshot())
+}
+
+/*
+* Saves a snapshot taken by the caller and returns once it is written, used by disk based
+* replication which needs the RDB file to match the offset the replica starts from
+ */
+func (sv *Saver) SaveSnapshot(snapshot *Snapshot) error {
+	if err := sv.start(); err != nil {
+		return err
+	}
+
+	return sv.save(snapshot)
+}
+
+/*
+* Takes a snapshot and writes it in a seperate goroutine (BGSAVE). Commands keep running
+* while the file is written, changes made after the snapshot was taken are not part of it
+ */
+func (sv *Saver) BackgroundSave() error {
+	if err := sv.start(); err != nil {
+		return err
+	}
+
+	snapshot := sv.store.Snapshot()
+	go func() {
+		if err := sv.save(snapshot); err != nil {
+			slog.Error("Background save failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+/*
+* Checks the save points every second and starts a background save when one of them is reached
+ */
+func (sv *Saver) SavePointLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sv.mu.Lock()
+		savePoints, lastSave, inProgress := sv.savePoints, sv.lastSave, sv.inProgress
+		sv.mu.Unlock()
+
+		if inProgress {
+			continue
+		}
+
+		dirty := sv.store.Dirty()
+		for _, savePoint := range savePoints {
+			if dirty >= savePoint.Changes && time.Since(lastSave) >= time.Duration(savePoint.Seconds)*time.Second {
+				slog.Info("Save point reached, saving", "changes", dirty, "seconds", savePoint.Seconds)
+				if err := sv.BackgroundSave(); err != nil {
+					slog.Error("Could not start background save", "err", err)
+				}
+				break
+			}
+		}
+	}
+}
+
+func (sv *Saver) start() error {
+	defer sv.mu.Unlock()
+
+	sv.mu.Lock()
+	if sv.inProgress {
+		return ErrSaveInProgress
+	}
+	sv.inProgress = true
+	return nil
+}
+
+func (sv *Saver) save(snapshot *Snapshot) error {
+	path := sv.Path()
+	err := writeRdbFile(path, snapshot)
+
+	sv.mu.Lock()
+	sv.inProgress = false
+	sv.lastErr = err
+	if err == nil {
+		sv.lastSave = time.Now()
+	}
+	sv.mu.Unlock()
+
+	if err == nil {
+		sv.store.SavedSnapshot(snapshot)
+		slog.Info("DB saved on disk", "path", path, "keys", len(snapshot.Entries))
+	}
+	return err
+}
+
+/*
+* Writes the snapshot to a temporary file in the same directory and renames it over the RDB file
+* once it is synced, so a crash while saving never leaves a truncated RDB file behind
+ */
+func writeRdbFile(path string, snapshot *Snapshot) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return WriteRdb(w, snapshot)
+	})
+}
+
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf("temp-%d-*%s", os.Getpid(), filepath.Ext(path)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Temp files are only readable by their owner, saved files get the usual permissions
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
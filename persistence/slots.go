@@ -0,0 +1,63 @@
+package persistence
+
+import "github.com/jason-gill00/redis-from-scratch/cluster"
+
+/*
+* Keeps track of the keys of every hash slot, so a cluster node can count and list the keys of a
+* slot without scanning the whole keyspace. Only maintained once enabled, on cluster nodes
+ */
+func (s *Store) IndexSlots() {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	s.slotKeys = map[int]map[string]bool{}
+	for key := range s.data {
+		s.indexKey(key)
+	}
+}
+
+// Must be called with the lock held
+func (s *Store) indexKey(key string) {
+	if s.slotKeys == nil {
+		return
+	}
+	slot := cluster.KeySlot(key)
+	if s.slotKeys[slot] == nil {
+		s.slotKeys[slot] = map[string]bool{}
+	}
+	s.slotKeys[slot][key] = true
+}
+
+// Must be called with the lock held
+func (s *Store) unindexKey(key string) {
+	if s.slotKeys == nil {
+		return
+	}
+	slot := cluster.KeySlot(key)
+	delete(s.slotKeys[slot], key)
+	if len(s.slotKeys[slot]) == 0 {
+		delete(s.slotKeys, slot)
+	}
+}
+
+func (s *Store) CountKeysInSlot(slot int) int {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return len(s.slotKeys[slot])
+}
+
+// Returns up to count keys of the slot
+func (s *Store) KeysInSlot(slot int, count int) []string {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	keys := []string{}
+	for key := range s.slotKeys[slot] {
+		if len(keys) >= count {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -1,53 +1,404 @@
 package persistence
 
 import (
+	"log/slog"
 	"sync"
 	"time"
 )
 
+const (
+	// How often the active expiry cycle runs
+	ActiveExpireInterval = 100 * time.Millisecond
+	// Number of keys with an expiration sampled on every iteration of the active expiry cycle
+	activeExpireSampleSize = 20
+)
+
 type value struct {
 	expiration *time.Time
-	val        []byte
+	// Value of a string key
+	val []byte
+	// Value of any other type, one of List, Set, Hash, SortedSet or *Stream
+	obj any
+	// Last time the key was read or written, used by the LRU eviction policies
+	lastAccess time.Time
+	// Logarithmic access frequency counter and the last time it was decremented, used by the LFU policies
+	lfuCounter   uint8
+	lfuDecayTime time.Time
 }
 
 type Store struct {
-	data map[string]value
-	mu   sync.Mutex
+	data map[string]*value
+	// Keys that have an expiration set, sampled by active expiry and the volatile eviction policies
+	expires   map[string]bool
+	listeners []func(KeyspaceEvent)
+
+	// Estimated memory used by the keys and values
+	usedMemory     int64
+	peakMemory     int64
+	maxMemory      int64
+	evictionPolicy EvictionPolicy
+	evictionPool   []evictionCandidate
+	samples        int
+	evictedKeys    int64
+
+	// Number of changes since the last successful save
+	dirty int64
+
+	// Off on replicas, which only expire keys lazily and get deletions from their master
+	activeExpire bool
+
+	// Keys of every hash slot, nil unless the server runs in cluster mode
+	slotKeys map[int]map[string]bool
+
+	mu sync.Mutex
 }
 
 func NewStore() *Store {
 	return &Store{
-		data: map[string]value{},
-		mu:   sync.Mutex{},
+		data:           map[string]*value{},
+		expires:        map[string]bool{},
+		evictionPolicy: NoEviction,
+		samples:        defaultMaxMemorySamples,
+		activeExpire:   true,
+		mu:             sync.Mutex{},
 	}
 }
 
-func (s *Store) Set(key string, val []byte, expiration *time.Time) {
+/*
+* Registers a listener that is called for every keyspace event emitted by the store.
+* Listeners are called after the store lock is released so they can safely read from the store
+ */
+func (s *Store) OnKeyspaceEvent(listener func(KeyspaceEvent)) {
 	defer s.mu.Unlock()
 
 	s.mu.Lock()
-	s.data[key] = value{
-		expiration: expiration,
-		val:        val,
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *Store) Set(key string, val []byte, expiration *time.Time) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	old, exists := s.data[key]
+
+	newVal := newValue(val, expiration)
+	if exists {
+		// An overwritten key keeps its access frequency
+		newVal.lfuCounter, newVal.lfuDecayTime = old.lfuCounter, old.lfuDecayTime
+	}
+	s.setEntry(key, newVal)
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	if !exists {
+		s.notify(EventNew, "new", key)
+	}
+	s.notify(EventString, "set", key)
+	if expiration != nil {
+		s.notify(EventGeneric, "expire", key)
 	}
 }
 
 func (s *Store) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	val, ok := s.data[key]
+	if ok {
+		val.touch()
+	}
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	if !ok {
+		s.notify(EventKeyMiss, "keymiss", key)
+		return []byte{}, false
+	}
+
+	return val.val, true
+}
+
+/*
+* Stores a value of one of the non string types (List, Set, Hash, SortedSet or *Stream),
+* replacing whatever the key held before
+ */
+func (s *Store) SetObject(key string, obj any, expiration *time.Time) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	_, exists := s.data[key]
+
+	newVal := newValue(nil, expiration)
+	newVal.obj = obj
+	s.setEntry(key, newVal)
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	if !exists {
+		s.notify(EventNew, "new", key)
+	}
+	s.notify(EventGeneric, "restore", key)
+}
+
+// Returns the object stored at a non string key. Reading it counts as an access
+func (s *Store) GetObject(key string) (any, bool) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	val, ok := s.data[key]
+	if ok {
+		val.touch()
+	}
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	if !ok {
+		return nil, false
+	}
+	return val.obj, true
+}
+
+// Returns the type of the value stored at key without counting it as an access
+func (s *Store) Type(key string) (ObjectType, bool) {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	val, ok := s.data[key]
+	s.mu.Unlock()
+
+	if expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	if !ok {
+		return TypeString, false
+	}
+	return objectType(val.obj), true
+}
+
+// Deletes the given keys and returns how many of them existed
+func (s *Store) Delete(keys ...string) int {
+	expired := []string{}
+	deleted := []string{}
+
+	s.mu.Lock()
+	for _, key := range keys {
+		if s.expireIfNeeded(key) {
+			expired = append(expired, key)
+		}
+		if _, ok := s.data[key]; ok {
+			s.deleteEntry(key)
+			deleted = append(deleted, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, key := range expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	for _, key := range deleted {
+		s.notify(EventGeneric, "del", key)
+	}
+	return len(deleted)
+}
+
+/*
+* Sets the expiration of an existing key. Returns false if the key does not exist.
+* An expiration in the past deletes the key right away
+ */
+func (s *Store) Expire(key string, expiration time.Time) bool {
+	s.mu.Lock()
+	expired := s.expireIfNeeded(key)
+	val, ok := s.data[key]
+	if !ok {
+		s.mu.Unlock()
+
+		if expired {
+			s.notify(EventExpired, "expired", key)
+		}
+		return false
+	}
+
+	if !expiration.After(time.Now()) {
+		s.deleteEntry(key)
+		s.mu.Unlock()
+
+		s.notify(EventGeneric, "del", key)
+		return true
+	}
+
+	updated := *val
+	updated.expiration = &expiration
+	s.setEntry(key, &updated)
+	s.mu.Unlock()
+
+	s.notify(EventGeneric, "expire", key)
+	return true
+}
+
+func (s *Store) ActiveExpire() bool {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return s.activeExpire
+}
+
+func (s *Store) SetActiveExpire(enabled bool) {
 	defer s.mu.Unlock()
 
 	s.mu.Lock()
-	if val, ok := s.data[key]; ok {
-		// Check if the value is expired
-		if val.expiration != nil && time.Now().After(*val.expiration) {
-			// Delete the value if its expired
-			delete(s.data, key)
+	s.activeExpire = enabled
+}
+
+/*
+* Samples keys that have an expiration and deletes the ones that expired, so keys that are never
+* read again still get removed (and their "expired" event is still emitted). If more than a quarter
+* of the sample was expired it samples again right away. Meant to run every ActiveExpireInterval
+ */
+func (s *Store) ActiveExpireCycle() {
+	if !s.ActiveExpire() {
+		return
+	}
+	expired := s.activeExpireCycle()
+	for expired > activeExpireSampleSize/4 {
+		expired = s.activeExpireCycle()
+	}
+}
+
+func (s *Store) activeExpireCycle() int {
+	expired := []string{}
+	now := time.Now()
 
-			return []byte{}, false
+	s.mu.Lock()
+	sampled := 0
+	for key := range s.expires {
+		if now.After(*s.data[key].expiration) {
+			s.deleteEntry(key)
+			expired = append(expired, key)
+		}
+		sampled++
+		if sampled == activeExpireSampleSize {
+			break
 		}
+	}
+	s.mu.Unlock()
+
+	for _, key := range expired {
+		s.notify(EventExpired, "expired", key)
+	}
+	return len(expired)
+}
 
-		return val.val, true
+/*
+* Deletes the key if it is expired and reports whether it did. Must be called with the lock held,
+* the caller is responsible for emitting the "expired" event once the lock is released
+ */
+func (s *Store) expireIfNeeded(key string) bool {
+	val, ok := s.data[key]
+	if !ok || val.expiration == nil || !time.Now().After(*val.expiration) {
+		return false
 	}
 
-	// No value found
-	return []byte{}, false
+	s.deleteEntry(key)
+	return true
+}
+
+// Stores the value and keeps the memory usage and the expires index up to date. Must be called with the lock held
+func (s *Store) setEntry(key string, val *value) {
+	if old, ok := s.data[key]; ok {
+		s.usedMemory -= entrySize(key, old)
+	} else {
+		s.indexKey(key)
+	}
+
+	s.data[key] = val
+	s.dirty++
+	s.usedMemory += entrySize(key, val)
+	s.peakMemory = max(s.peakMemory, s.usedMemory)
+
+	if val.expiration != nil {
+		s.expires[key] = true
+	} else {
+		delete(s.expires, key)
+	}
+}
+
+// Removes the key and keeps the memory usage and the expires index up to date. Must be called with the lock held
+func (s *Store) deleteEntry(key string) {
+	if old, ok := s.data[key]; ok {
+		s.usedMemory -= entrySize(key, old)
+	}
+
+	delete(s.data, key)
+	delete(s.expires, key)
+	s.unindexKey(key)
+	s.dirty++
+}
+
+func (s *Store) notify(class EventClass, event string, key string) {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(KeyspaceEvent{Class: class, Event: event, Key: key})
+	}
+}
+
+/*
+* Removes every key, used when a replica replaces its dataset with the one of its master. No
+* keyspace events are emitted for the removed keys
+ */
+func (s *Store) Flush() {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	s.dirty += int64(len(s.data))
+	s.data = map[string]*value{}
+	s.expires = map[string]bool{}
+	s.evictionPool = nil
+	s.usedMemory = 0
+	if s.slotKeys != nil {
+		s.slotKeys = map[int]map[string]bool{}
+	}
+}
+
+// Adds the keys of database 0 of an RDB file to the store, the server only has database 0
+func (s *Store) Load(rdb *RdbFile) {
+	for index, db := range rdb.Databases {
+		if index != 0 && len(db) > 0 {
+			slog.Warn("Skipping keys of database other than 0 in RDB file", "db", index, "keys", len(db))
+		}
+	}
+	for key, value := range rdb.Database {
+		var expiration *time.Time
+		if value.Expiration != nil {
+			exp := time.UnixMilli(int64(*value.Expiration)).UTC()
+			expiration = &exp
+		}
+
+		if value.Object != nil {
+			s.SetObject(key, value.Object, expiration)
+		} else {
+			s.Set(key, []byte(value.Value), expiration)
+		}
+	}
+}
+
+// Number of changes since the last successful save
+func (s *Store) Dirty() int64 {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	return s.dirty
+}
+
+// Called once a snapshot is saved, changes made while it was being written still count as dirty
+func (s *Store) SavedSnapshot(snapshot *Snapshot) {
+	defer s.mu.Unlock()
+
+	s.mu.Lock()
+	s.dirty -= snapshot.Dirty
 }
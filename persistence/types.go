@@ -0,0 +1,237 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type ObjectType int
+
+const (
+	TypeString ObjectType = iota
+	TypeList
+	TypeSet
+	TypeZset
+	TypeHash
+	TypeStream
+)
+
+const (
+	// Collections with more elements, or with a larger element, use their big encoding in Redis
+	compactMaxEntries = 128
+	compactMaxValue   = 64
+	// Sets of integers up to this size are stored as an intset in Redis
+	intsetMaxEntries = 512
+)
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Values of the non string types. Which one a key holds is decided by the Go type of its object
+type (
+	List      []string
+	Set       map[string]struct{}
+	Hash      map[string]string
+	SortedSet map[string]float64
+)
+
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+type StreamEntry struct {
+	ID StreamID
+	// Field/value pairs in the order they were added
+	Fields []string
+}
+
+type Stream struct {
+	Entries      []StreamEntry
+	LastID       StreamID
+	MaxDeletedID StreamID
+	// Number of entries ever added to the stream, including deleted ones
+	EntriesAdded uint64
+	Groups       []StreamGroup
+}
+
+type StreamGroup struct {
+	Name   string
+	LastID StreamID
+	// Logical read counter of the group, -1 when it is unknown
+	EntriesRead int64
+	// Entries delivered to a consumer of the group but not acknowledged yet
+	Pending   []StreamPendingEntry
+	Consumers []StreamConsumer
+}
+
+type StreamPendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount uint64
+}
+
+type StreamConsumer struct {
+	Name       string
+	SeenTime   time.Time
+	ActiveTime time.Time
+}
+
+// A sorted set member with its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+func (t ObjectType) String() string {
+	switch t {
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZset:
+		return "zset"
+	case TypeHash:
+		return "hash"
+	case TypeStream:
+		return "stream"
+	default:
+		return "string"
+	}
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// Members ordered by score, then lexicographically like Redis does for equal scores
+func (z SortedSet) Ordered() []ScoredMember {
+	members := make([]ScoredMember, 0, len(z))
+	for member, score := range z {
+		members = append(members, ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+	return members
+}
+
+// Returns the type of a store object, nil is a plain string
+func objectType(obj any) ObjectType {
+	switch obj.(type) {
+	case List:
+		return TypeList
+	case Set:
+		return TypeSet
+	case SortedSet:
+		return TypeZset
+	case Hash:
+		return TypeHash
+	case *Stream:
+		return TypeStream
+	default:
+		return TypeString
+	}
+}
+
+// Returns the encoding Redis would use for the object
+func objectEncoding(obj any) string {
+	switch o := obj.(type) {
+	case List:
+		if len(o) <= compactMaxEntries && maxLen(o...) <= compactMaxValue {
+			return "listpack"
+		}
+		return "quicklist"
+	case Set:
+		members := make([]string, 0, len(o))
+		integers := true
+		for member := range o {
+			members = append(members, member)
+			if _, err := strconv.ParseInt(member, 10, 64); err != nil {
+				integers = false
+			}
+		}
+		if integers && len(o) <= intsetMaxEntries {
+			return "intset"
+		}
+		if len(o) <= compactMaxEntries && maxLen(members...) <= compactMaxValue {
+			return "listpack"
+		}
+		return "hashtable"
+	case Hash:
+		if len(o) > compactMaxEntries {
+			return "hashtable"
+		}
+		for field, val := range o {
+			if len(field) > compactMaxValue || len(val) > compactMaxValue {
+				return "hashtable"
+			}
+		}
+		return "listpack"
+	case SortedSet:
+		if len(o) > compactMaxEntries {
+			return "skiplist"
+		}
+		for member := range o {
+			if len(member) > compactMaxValue {
+				return "skiplist"
+			}
+		}
+		return "listpack"
+	case *Stream:
+		return "stream"
+	default:
+		return ""
+	}
+}
+
+// Estimated memory used by the elements of an object, on top of the entry overhead
+func objectSize(obj any) int {
+	// Every element of a collection pays for its own header
+	const elementOverhead = 16
+
+	size := 0
+	switch o := obj.(type) {
+	case List:
+		for _, element := range o {
+			size += len(element) + elementOverhead
+		}
+	case Set:
+		for member := range o {
+			size += len(member) + elementOverhead
+		}
+	case Hash:
+		for field, val := range o {
+			size += len(field) + len(val) + elementOverhead
+		}
+	case SortedSet:
+		for member := range o {
+			size += len(member) + 8 + elementOverhead
+		}
+	case *Stream:
+		for _, entry := range o.Entries {
+			size += 16 + elementOverhead
+			for _, field := range entry.Fields {
+				size += len(field)
+			}
+		}
+		for _, group := range o.Groups {
+			size += len(group.Name) + len(group.Pending)*(32+elementOverhead) + len(group.Consumers)*elementOverhead
+		}
+	}
+	return size
+}
+
+func maxLen(values ...string) int {
+	longest := 0
+	for _, val := range values {
+		longest = max(longest, len(val))
+	}
+	return longest
+}
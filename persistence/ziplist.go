@@ -0,0 +1,183 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	ziplistHeaderSize = 10
+	ziplistEnd        = 0xff
+	zipmapBigLen      = 254
+	zipmapEnd         = 0xff
+)
+
+/*
+* Decodes a ziplist, the compact encoding used before listpacks (RDB types 10, 12, 13 and 14).
+* The header holds the total size, the offset of the last entry and the entry count. Every entry
+* is the length of the previous entry, an encoding and the data. Integers are returned in their
+* decimal string form
+ */
+func ziplistEntries(zl []byte) ([]string, error) {
+	if len(zl) < ziplistHeaderSize+1 {
+		return nil, fmt.Errorf("invalid ziplist header")
+	}
+
+	entries := []string{}
+	for pos := ziplistHeaderSize; pos < len(zl); {
+		if zl[pos] == ziplistEnd {
+			return entries, nil
+		}
+
+		// The previous entry length is 1 byte, or 0xfe followed by 4 bytes
+		if zl[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(zl) {
+			break
+		}
+
+		b := zl[pos]
+		var entry string
+		var header, size int
+		switch b >> 6 {
+		case 0: // 00pppppp - string of up to 63 bytes
+			header = 1
+			size = header + int(b&0x3f)
+		case 1: // 01pppppp qqqqqqqq - 14 bit big endian string length
+			if pos+2 > len(zl) {
+				return nil, fmt.Errorf("ziplist entry out of bounds")
+			}
+			header = 2
+			size = header + (int(b&0x3f)<<8 | int(zl[pos+1]))
+		case 2: // 10000000 + 4 bytes - 32 bit big endian string length
+			if pos+5 > len(zl) {
+				return nil, fmt.Errorf("ziplist entry out of bounds")
+			}
+			header = 5
+			size = header + int(binary.BigEndian.Uint32(zl[pos+1:]))
+		default:
+			var width int
+			switch b {
+			case 0xc0:
+				width = 2
+			case 0xd0:
+				width = 4
+			case 0xe0:
+				width = 8
+			case 0xf0:
+				width = 3
+			case 0xfe:
+				width = 1
+			default:
+				// 1111xxxx - integer between 0 and 12 stored in the encoding itself
+				if b < 0xf1 || b > 0xfd {
+					return nil, fmt.Errorf("invalid ziplist encoding: %#x", b)
+				}
+				entry = strconv.Itoa(int(b&0x0f) - 1)
+			}
+			if pos+1+width > len(zl) {
+				return nil, fmt.Errorf("ziplist entry out of bounds")
+			}
+			if width > 0 {
+				entry = strconv.FormatInt(littleEndianInt(zl[pos+1:pos+1+width]), 10)
+			}
+			size = 1 + width
+		}
+
+		if pos+size > len(zl) {
+			return nil, fmt.Errorf("ziplist entry out of bounds")
+		}
+		if header > 0 {
+			entry = string(zl[pos+header : pos+size])
+		}
+		entries = append(entries, entry)
+		pos += size
+	}
+
+	return nil, fmt.Errorf("ziplist is missing its end byte")
+}
+
+/*
+* Decodes an intset (RDB type 11): the byte width of every integer (2, 4 or 8), the number
+* of integers and the sorted little endian integers
+ */
+func intsetEntries(is []byte) ([]string, error) {
+	if len(is) < 8 {
+		return nil, fmt.Errorf("invalid intset header")
+	}
+
+	width := int(binary.LittleEndian.Uint32(is))
+	length := int(binary.LittleEndian.Uint32(is[4:]))
+	if (width != 2 && width != 4 && width != 8) || 8+width*length > len(is) {
+		return nil, fmt.Errorf("invalid intset encoding")
+	}
+
+	entries := make([]string, 0, length)
+	for i := 0; i < length; i++ {
+		start := 8 + i*width
+		entries = append(entries, strconv.FormatInt(littleEndianInt(is[start:start+width]), 10))
+	}
+	return entries, nil
+}
+
+/*
+* Decodes a zipmap (RDB type 9), the hash encoding used before Redis 2.6: a count byte followed by
+* key and value pairs. Lengths are 1 byte, or 254 followed by 4 bytes, and every value is followed
+* by a number of free bytes that are skipped
+ */
+func zipmapEntries(zm []byte) ([]string, error) {
+	if len(zm) < 2 {
+		return nil, fmt.Errorf("invalid zipmap header")
+	}
+
+	entries := []string{}
+	readLength := func(pos int) (int, int, error) {
+		if pos >= len(zm) {
+			return 0, 0, fmt.Errorf("zipmap length out of bounds")
+		}
+		if zm[pos] < zipmapBigLen {
+			return int(zm[pos]), pos + 1, nil
+		}
+		if pos+5 > len(zm) {
+			return 0, 0, fmt.Errorf("zipmap length out of bounds")
+		}
+		return int(binary.LittleEndian.Uint32(zm[pos+1:])), pos + 5, nil
+	}
+
+	for pos := 1; pos < len(zm); {
+		if zm[pos] == zipmapEnd {
+			return entries, nil
+		}
+
+		var keyLen, valLen int
+		var err error
+		keyLen, pos, err = readLength(pos)
+		if err != nil {
+			return nil, err
+		}
+		if pos+keyLen >= len(zm) {
+			return nil, fmt.Errorf("zipmap key out of bounds")
+		}
+		key := string(zm[pos : pos+keyLen])
+		pos += keyLen
+
+		valLen, pos, err = readLength(pos)
+		if err != nil {
+			return nil, err
+		}
+		if pos+1+valLen > len(zm) {
+			return nil, fmt.Errorf("zipmap value out of bounds")
+		}
+		free := int(zm[pos])
+		val := string(zm[pos+1 : pos+1+valLen])
+
+		entries = append(entries, key, val)
+		pos += 1 + valLen + free
+	}
+
+	return nil, fmt.Errorf("zipmap is missing its end byte")
+}
@@ -0,0 +1,99 @@
+package pubsub
+
+/*
+* Reports whether str matches the glob-style pattern. Supports the same syntax as Redis:
+*   ?      matches any single character
+*   *      matches any sequence of characters (including none)
+*   [abc]  matches one of the characters, [^abc] negates the set and [a-z] is a range
+*   \x     escapes x so it is matched literally
+ */
+func MatchPattern(pattern string, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if MatchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			matched, rest := matchSet(pattern[1:], str[0])
+			if !matched {
+				return false
+			}
+			str = str[1:]
+			pattern = rest
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(str) == 0
+}
+
+// Matches c against the set that starts right after a "[" and returns the pattern remaining after the closing "]"
+func matchSet(pattern string, c byte) (bool, string) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+
+	// Skip the closing bracket
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return matched != negate, pattern
+}
@@ -0,0 +1,49 @@
+package pubsub
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		matches bool
+	}{
+		{"news", "news", true},
+		{"news", "newsx", false},
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"news.*", "news.tech", true},
+		{"news.*", "news.", true},
+		{"news.*", "news", false},
+		{"*.tech", "news.tech", true},
+		{"a**b", "axxb", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"???", "abc", true},
+		{"???", "ab", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[\\]]llo", "h]llo", true},
+		{"[", "a", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"\\?x", "?x", true},
+		{"a\\[b", "a[b", true},
+		{"\\", "\\", true},
+	}
+
+	for _, test := range tests {
+		if got := MatchPattern(test.pattern, test.str); got != test.matches {
+			t.Errorf("MatchPattern(%q, %q) was incorrect. Expected: %t, Received: %t", test.pattern, test.str, test.matches, got)
+		}
+	}
+}
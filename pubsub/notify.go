@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+)
+
+const (
+	notifyKeyspace = 1 << 16 // K - publish to __keyspace@<db>__:<key>
+	notifyKeyevent = 1 << 17 // E - publish to __keyevent@<db>__:<event>
+
+	// A is an alias for every event class except key misses and new keys
+	notifyAll = int(persistence.EventGeneric | persistence.EventString | persistence.EventList |
+		persistence.EventSet | persistence.EventHash | persistence.EventZset | persistence.EventExpired |
+		persistence.EventEvicted | persistence.EventStream)
+)
+
+// Flags of the notify-keyspace-events config, in the order they are printed
+var keyspaceEventFlags = []struct {
+	char  byte
+	class int
+}{
+	{'g', int(persistence.EventGeneric)},
+	{'$', int(persistence.EventString)},
+	{'l', int(persistence.EventList)},
+	{'s', int(persistence.EventSet)},
+	{'h', int(persistence.EventHash)},
+	{'z', int(persistence.EventZset)},
+	{'x', int(persistence.EventExpired)},
+	{'e', int(persistence.EventEvicted)},
+	{'t', int(persistence.EventStream)},
+	{'m', int(persistence.EventKeyMiss)},
+	{'n', int(persistence.EventNew)},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+/*
+* Parses a notify-keyspace-events class string (e.g "Ex" or "KA") and enables the matching
+* notifications. An empty string disables notifications
+ */
+func (p *PubSub) SetKeyspaceEvents(classes string) error {
+	flags, err := parseKeyspaceEvents(classes)
+	if err != nil {
+		return err
+	}
+
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	p.keyspaceEvents = flags
+	return nil
+}
+
+// Returns the normalized notify-keyspace-events class string
+func (p *PubSub) KeyspaceEvents() string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	return formatKeyspaceEvents(p.keyspaceEvents)
+}
+
+/*
+* Publishes a keyspace event emitted by the store if its class is enabled. The keyspace channel
+* receives the event name and the keyevent channel receives the key name
+ */
+func (p *PubSub) NotifyKeyspaceEvent(event persistence.KeyspaceEvent) {
+	p.mu.Lock()
+	flags := p.keyspaceEvents
+	p.mu.Unlock()
+
+	if flags&int(event.Class) == 0 {
+		return
+	}
+
+	// Only the default database exists
+	if flags&notifyKeyspace != 0 {
+		p.Publish(fmt.Sprintf("__keyspace@0__:%s", event.Key), event.Event)
+	}
+	if flags&notifyKeyevent != 0 {
+		p.Publish(fmt.Sprintf("__keyevent@0__:%s", event.Event), event.Key)
+	}
+}
+
+func parseKeyspaceEvents(classes string) (int, error) {
+	flags := 0
+
+	for i := 0; i < len(classes); i++ {
+		if classes[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+
+		found := false
+		for _, flag := range keyspaceEventFlags {
+			if flag.char == classes[i] {
+				flags |= flag.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid keyspace event class: %c", classes[i])
+		}
+	}
+
+	return flags, nil
+}
+
+func formatKeyspaceEvents(flags int) string {
+	classes := ""
+
+	if flags&notifyAll == notifyAll {
+		classes += "A"
+	}
+	for _, flag := range keyspaceEventFlags {
+		if flags&notifyAll == notifyAll && flag.class&notifyAll != 0 {
+			continue
+		}
+		if flags&flag.class != 0 {
+			classes += string(flag.char)
+		}
+	}
+
+	return classes
+}
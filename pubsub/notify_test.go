@@ -0,0 +1,49 @@
+package pubsub
+
+import "testing"
+
+func TestKeyspaceEventsFlags(t *testing.T) {
+	tests := map[string]string{
+		"":            "",
+		"Ex":          "xE",
+		"KA":          "AK",
+		"KEA":         "AKE",
+		"AKE":         "AKE",
+		"g$lshzxetKE": "AKE",
+		"Kg$":         "g$K",
+		"Kn":          "nK",
+		"Em":          "mE",
+		"AKEnm":       "AmnKE",
+		"xxEE":        "xE",
+	}
+
+	for classes, expected := range tests {
+		flags, err := parseKeyspaceEvents(classes)
+		if err != nil {
+			t.Errorf("parseKeyspaceEvents(%q) returned error: %s", classes, err.Error())
+			continue
+		}
+		if got := formatKeyspaceEvents(flags); got != expected {
+			t.Errorf("Flags of %q were incorrect. Expected: %q, Received: %q", classes, expected, got)
+		}
+	}
+
+	for _, classes := range []string{"KW", "a", "E ", "k"} {
+		if _, err := parseKeyspaceEvents(classes); err == nil {
+			t.Errorf("parseKeyspaceEvents(%q) should reject the invalid class", classes)
+		}
+	}
+}
+
+func TestSetKeyspaceEvents(t *testing.T) {
+	p := NewPubSub()
+	if err := p.SetKeyspaceEvents("Ex"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.SetKeyspaceEvents("Exy"); err == nil {
+		t.Errorf("Expected an invalid class to be rejected")
+	}
+	if got := p.KeyspaceEvents(); got != "xE" {
+		t.Errorf("A rejected value should keep the previous one, Received: %q", got)
+	}
+}
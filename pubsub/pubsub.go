@@ -0,0 +1,372 @@
+package pubsub
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Kinds of subscriptions a connection can hold. They are also the first element of the
+// push message a subscriber receives when it subscribes/unsubscribes
+const (
+	kindChannel      = "subscribe"
+	kindPattern      = "psubscribe"
+	kindShardChannel = "ssubscribe"
+)
+
+type subscriptions struct {
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
+}
+
+/*
+* PubSub keeps track of which connections are subscribed to which channels and delivers
+* published messages to them. Shard channels live in a seperate namespace from global
+* channels, a SPUBLISH is never delivered to a SUBSCRIBE subscriber and vice versa
+ */
+type PubSub struct {
+	channels      map[string]map[*client.Client]bool
+	patterns      map[string]map[*client.Client]bool
+	shardChannels map[string]map[*client.Client]bool
+	clients       map[*client.Client]*subscriptions
+	// Enabled notify-keyspace-events classes
+	keyspaceEvents int
+	mu             sync.Mutex
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels:      map[string]map[*client.Client]bool{},
+		patterns:      map[string]map[*client.Client]bool{},
+		shardChannels: map[string]map[*client.Client]bool{},
+		clients:       map[*client.Client]*subscriptions{},
+		mu:            sync.Mutex{},
+	}
+}
+
+// Subscribe the connection to the given channels. Returns the serialized confirmation for every channel
+func (p *PubSub) Subscribe(c *client.Client, channels []string) string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	response := ""
+	for _, channel := range channels {
+		p.add(p.channels, c, channel, kindChannel)
+		response += subscriptionReply(c, kindChannel, channel, p.globalCount(c))
+	}
+	return response
+}
+
+// Unsubscribe the connection from the given channels, or from every channel if none are given
+func (p *PubSub) Unsubscribe(c *client.Client, channels []string) string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	if len(channels) == 0 {
+		channels = p.subscribed(c, kindChannel)
+	}
+	if len(channels) == 0 {
+		return subscriptionReply(c, "unsubscribe", "", p.globalCount(c))
+	}
+
+	response := ""
+	for _, channel := range channels {
+		p.remove(p.channels, c, channel, kindChannel)
+		response += subscriptionReply(c, "unsubscribe", channel, p.globalCount(c))
+	}
+	return response
+}
+
+// PSubscribe subscribes the connection to every channel matching the given glob-style patterns
+func (p *PubSub) PSubscribe(c *client.Client, patterns []string) string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	response := ""
+	for _, pattern := range patterns {
+		p.add(p.patterns, c, pattern, kindPattern)
+		response += subscriptionReply(c, kindPattern, pattern, p.globalCount(c))
+	}
+	return response
+}
+
+// PUnsubscribe removes the given patterns, or every pattern if none are given
+func (p *PubSub) PUnsubscribe(c *client.Client, patterns []string) string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	if len(patterns) == 0 {
+		patterns = p.subscribed(c, kindPattern)
+	}
+	if len(patterns) == 0 {
+		return subscriptionReply(c, "punsubscribe", "", p.globalCount(c))
+	}
+
+	response := ""
+	for _, pattern := range patterns {
+		p.remove(p.patterns, c, pattern, kindPattern)
+		response += subscriptionReply(c, "punsubscribe", pattern, p.globalCount(c))
+	}
+	return response
+}
+
+// SSubscribe subscribes the connection to the given shard channels
+func (p *PubSub) SSubscribe(c *client.Client, channels []string) string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	response := ""
+	for _, channel := range channels {
+		p.add(p.shardChannels, c, channel, kindShardChannel)
+		response += subscriptionReply(c, kindShardChannel, channel, p.shardCount(c))
+	}
+	return response
+}
+
+// SUnsubscribe removes the given shard channels, or every shard channel if none are given
+func (p *PubSub) SUnsubscribe(c *client.Client, channels []string) string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	if len(channels) == 0 {
+		channels = p.subscribed(c, kindShardChannel)
+	}
+	if len(channels) == 0 {
+		return subscriptionReply(c, "sunsubscribe", "", p.shardCount(c))
+	}
+
+	response := ""
+	for _, channel := range channels {
+		p.remove(p.shardChannels, c, channel, kindShardChannel)
+		response += subscriptionReply(c, "sunsubscribe", channel, p.shardCount(c))
+	}
+	return response
+}
+
+/*
+* Delivers the message to every connection subscribed to the channel, either directly or
+* through a matching pattern. Returns the number of connections that received the message
+ */
+func (p *PubSub) Publish(channel string, message string) int {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	receivers := 0
+	for c := range p.channels[channel] {
+		write(c, []string{"message", channel, message})
+		receivers++
+	}
+
+	for pattern, clients := range p.patterns {
+		if !MatchPattern(pattern, channel) {
+			continue
+		}
+		for c := range clients {
+			write(c, []string{"pmessage", pattern, channel, message})
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// SPublish delivers the message to the connections subscribed to the shard channel
+func (p *PubSub) SPublish(channel string, message string) int {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	receivers := 0
+	for c := range p.shardChannels[channel] {
+		write(c, []string{"smessage", channel, message})
+		receivers++
+	}
+
+	return receivers
+}
+
+// Returns the total number of channels, patterns and shard channels the connection is subscribed to
+func (p *PubSub) SubscriptionCount(c *client.Client) int {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	return p.globalCount(c) + p.shardCount(c)
+}
+
+// Whether the connection is subscribed to the channel itself, patterns aside
+func (p *PubSub) IsSubscribed(c *client.Client, channel string) bool {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	return p.channels[channel][c]
+}
+
+// Returns the active channels (or shard channels) with at least one subscriber that match the pattern
+func (p *PubSub) ActiveChannels(pattern string, shard bool) []string {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	registry := p.channels
+	if shard {
+		registry = p.shardChannels
+	}
+
+	channels := []string{}
+	for channel := range registry {
+		if pattern == "" || MatchPattern(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// Returns the number of subscribers of the channel (or shard channel)
+func (p *PubSub) NumSub(channel string, shard bool) int {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	if shard {
+		return len(p.shardChannels[channel])
+	}
+	return len(p.channels[channel])
+}
+
+// Returns the number of unique patterns subscribed to by all connections
+func (p *PubSub) NumPat() int {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	return len(p.patterns)
+}
+
+// RemoveClient drops every subscription held by a client that disconnected
+func (p *PubSub) RemoveClient(c *client.Client) {
+	defer p.mu.Unlock()
+
+	p.mu.Lock()
+	subs, ok := p.clients[c]
+	if !ok {
+		return
+	}
+	for channel := range subs.channels {
+		p.remove(p.channels, c, channel, kindChannel)
+	}
+	for pattern := range subs.patterns {
+		p.remove(p.patterns, c, pattern, kindPattern)
+	}
+	for channel := range subs.shardChannels {
+		p.remove(p.shardChannels, c, channel, kindShardChannel)
+	}
+}
+
+func (p *PubSub) add(registry map[string]map[*client.Client]bool, c *client.Client, name string, kind string) {
+	if _, ok := registry[name]; !ok {
+		registry[name] = map[*client.Client]bool{}
+	}
+	registry[name][c] = true
+
+	subs, ok := p.clients[c]
+	if !ok {
+		subs = &subscriptions{
+			channels:      map[string]bool{},
+			patterns:      map[string]bool{},
+			shardChannels: map[string]bool{},
+		}
+		p.clients[c] = subs
+	}
+	subs.of(kind)[name] = true
+}
+
+func (p *PubSub) remove(registry map[string]map[*client.Client]bool, c *client.Client, name string, kind string) {
+	if clients, ok := registry[name]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(registry, name)
+		}
+	}
+
+	subs, ok := p.clients[c]
+	if !ok {
+		return
+	}
+	delete(subs.of(kind), name)
+	if len(subs.channels)+len(subs.patterns)+len(subs.shardChannels) == 0 {
+		delete(p.clients, c)
+	}
+}
+
+func (p *PubSub) subscribed(c *client.Client, kind string) []string {
+	subs, ok := p.clients[c]
+	if !ok {
+		return nil
+	}
+
+	names := []string{}
+	for name := range subs.of(kind) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *PubSub) globalCount(c *client.Client) int {
+	if subs, ok := p.clients[c]; ok {
+		return len(subs.channels) + len(subs.patterns)
+	}
+	return 0
+}
+
+func (p *PubSub) shardCount(c *client.Client) int {
+	if subs, ok := p.clients[c]; ok {
+		return len(subs.shardChannels)
+	}
+	return 0
+}
+
+func (s *subscriptions) of(kind string) map[string]bool {
+	switch kind {
+	case kindPattern:
+		return s.patterns
+	case kindShardChannel:
+		return s.shardChannels
+	default:
+		return s.channels
+	}
+}
+
+// Serializes the [kind, name, count] array sent to a subscriber. An empty name is sent as a null bulk string
+func subscriptionReply(c *client.Client, kind string, name string, count int) string {
+	serializedName := resp.RESPSerializeBulkString(name)
+	if name == "" {
+		serializedName = resp.RESPNil
+	}
+	return serializeMessage(c, []string{
+		resp.RESPSerializeBulkString(kind),
+		serializedName,
+		resp.RESPSerializeInteger(count),
+	})
+}
+
+// RESP3 clients receive pub/sub messages as out of band push messages, RESP2 clients as plain arrays
+func serializeMessage(c *client.Client, elements []string) string {
+	if c.Protocol >= 3 {
+		return resp.RESPSerializePush(elements)
+	}
+	return resp.RESPSerializeRawArray(elements)
+}
+
+func write(c *client.Client, elements []string) {
+	serialized := []string{}
+	for _, elem := range elements {
+		serialized = append(serialized, resp.RESPSerializeBulkString(elem))
+	}
+
+	err := c.Write(serializeMessage(c, serialized))
+	if err != nil {
+		slog.Error("Error encountered when writing message to subscriber", "err", err)
+	}
+}
@@ -0,0 +1,63 @@
+package replication
+
+/*
+* Circular buffer with the most recent bytes propagated to replicas. A replica that lost its
+* connection can continue from its offset as long as the bytes it missed are still in it
+ */
+type Backlog struct {
+	buf []byte
+	// Where the next byte is written
+	idx int
+	// Number of valid bytes, at most len(buf)
+	histlen int
+}
+
+func NewBacklog(size int) *Backlog {
+	return &Backlog{buf: make([]byte, size)}
+}
+
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+// Number of bytes in the backlog
+func (b *Backlog) Len() int {
+	return b.histlen
+}
+
+// Appends data, overwriting the oldest bytes once the backlog is full
+func (b *Backlog) Feed(data []byte) {
+	if len(b.buf) == 0 {
+		return
+	}
+	// Only the tail of data larger than the backlog is kept
+	if len(data) > len(b.buf) {
+		data = data[len(data)-len(b.buf):]
+	}
+
+	for len(data) > 0 {
+		n := copy(b.buf[b.idx:], data)
+		data = data[n:]
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+	}
+}
+
+// The last n bytes of the backlog, n is at most Len()
+func (b *Backlog) Tail(n int) []byte {
+	out := make([]byte, 0, n)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		return append(out, b.buf[start:start+n]...)
+	}
+	out = append(out, b.buf[start:]...)
+	return append(out, b.buf[:n-(len(b.buf)-start)]...)
+}
+
+// Changes the size of the backlog keeping as many of the most recent bytes as fit
+func (b *Backlog) Resize(size int) {
+	kept := b.Tail(min(b.histlen, size))
+	b.buf = make([]byte, size)
+	b.idx, b.histlen = 0, 0
+	b.Feed(kept)
+}
@@ -0,0 +1,93 @@
+package replication
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// States of the link of a replica with its master, like repl_state in Redis
+const (
+	LinkConnect    = "connect"    // Not connected, waiting to connect (again)
+	LinkConnecting = "connecting" // Opening the connection
+	LinkHandshake  = "handshake"  // Sending PING and REPLCONF
+	LinkTransfer   = "transfer"   // Sent PSYNC, receiving the reply and the RDB of a full resync
+	LinkConnected  = "connected"  // Applying the replication stream
+)
+
+/*
+* State of the link of a replica with its master. It is changed by the goroutine connecting to
+* the master and read by INFO, hence the lock
+ */
+type MasterLink struct {
+	state string
+	// Last time anything was received from the master
+	lastIO time.Time
+	// Since when the link is not connected
+	downSince time.Time
+
+	mu sync.Mutex
+}
+
+func NewMasterLink() *MasterLink {
+	return &MasterLink{state: LinkConnect, downSince: time.Now()}
+}
+
+func (l *MasterLink) State() string {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	return l.state
+}
+
+func (l *MasterLink) SetState(state string) {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	if state == LinkConnected {
+		l.lastIO = time.Now()
+	} else if l.state == LinkConnected {
+		l.downSince = time.Now()
+	}
+	l.state = state
+}
+
+// Records that something was received from the master
+func (l *MasterLink) Touch() {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	l.lastIO = time.Now()
+}
+
+// Time since anything was received from the master
+func (l *MasterLink) Idle() time.Duration {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	return time.Since(l.lastIO)
+}
+
+// Fields of INFO replication describing the link of a replica with its master
+func (l *MasterLink) Info() [][2]string {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	status, lastIO, syncInProgress := "down", "-1", "0"
+	if l.state == LinkConnected {
+		status, lastIO = "up", strconv.FormatInt(int64(time.Since(l.lastIO).Seconds()), 10)
+	}
+	if l.state == LinkTransfer {
+		syncInProgress = "1"
+	}
+
+	fields := [][2]string{
+		{"master_link_status", status},
+		{"master_last_io_seconds_ago", lastIO},
+		{"master_sync_in_progress", syncInProgress},
+	}
+	if l.state != LinkConnected {
+		fields = append(fields, [2]string{"master_link_down_since_seconds", strconv.FormatInt(int64(time.Since(l.downSince).Seconds()), 10)})
+	}
+	return fields
+}
@@ -0,0 +1,204 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Default repl-backlog-size, same as Redis
+const DefaultBacklogSize = 1024 * 1024
+
+/*
+* Replication state of the server: the replication id identifies the history of the dataset and
+* the offset counts the bytes of that history propagated to replicas so far. replid2 is the id the
+* server had before, a replica of the old history can continue up to secondReplIDOffset
+ */
+type Replication struct {
+	replID             string
+	replID2            string
+	secondReplIDOffset int64
+	// master_repl_offset
+	offset int64
+
+	// Created once the first replica attaches, until then nothing needs to be kept
+	backlog     *Backlog
+	backlogSize int
+
+	mu sync.Mutex
+}
+
+func NewReplication() *Replication {
+	return &Replication{
+		replID:             NewReplID(),
+		replID2:            "0000000000000000000000000000000000000000",
+		secondReplIDOffset: -1,
+		backlogSize:        DefaultBacklogSize,
+	}
+}
+
+// Random 40 character replication id
+func NewReplID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("could not generate replication id: %s", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+func (r *Replication) ReplID() string {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	return r.replID
+}
+
+func (r *Replication) Offset() int64 {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	return r.offset
+}
+
+func (r *Replication) SetBacklogSize(size int) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	r.backlogSize = size
+	if r.backlog != nil {
+		r.backlog.Resize(size)
+	}
+}
+
+/*
+* Adopts the history of a master after a full resynchronization: the dataset is now the one of
+* the master at offset, and the backlog starts over from there
+ */
+func (r *Replication) ResetHistory(replID string, offset int64) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	r.replID, r.offset = replID, offset
+	r.replID2, r.secondReplIDOffset = "0000000000000000000000000000000000000000", -1
+	r.backlog = NewBacklog(r.backlogSize)
+}
+
+/*
+* Continues the history of a master after a partial resynchronization. If the master has a new
+* replication id (it was promoted since) the current one becomes replid2, so replicas of this
+* server can still continue from it up to the current offset
+ */
+func (r *Replication) ContinueHistory(replID string) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	if r.backlog == nil {
+		r.backlog = NewBacklog(r.backlogSize)
+	}
+	if replID != r.replID {
+		r.shiftReplID(replID)
+	}
+}
+
+/*
+* Starts a new history when a replica is promoted to master. The id of the history it followed
+* becomes replid2, so the other replicas of its old master can continue from it
+ */
+func (r *Replication) Promote() {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	r.shiftReplID(NewReplID())
+}
+
+func (r *Replication) shiftReplID(replID string) {
+	r.replID2, r.secondReplIDOffset = r.replID, r.offset+1
+	r.replID = replID
+}
+
+/*
+* Records bytes propagated to replicas. Like Redis the offset only advances once there is a
+* backlog, before any replica attached there is nobody to keep the offset for
+ */
+func (r *Replication) Feed(data string) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	if r.backlog == nil {
+		return
+	}
+	r.backlog.Feed([]byte(data))
+	r.offset += int64(len(data))
+}
+
+/*
+* Handles PSYNC replid offset, where offset is the first byte the replica is missing. If the replica
+* follows our history and the bytes it misses are in the backlog it continues from there (the reply
+* includes them), otherwise it needs a full resync starting at the current offset
+ */
+func (r *Replication) Psync(replID string, offset string) (response string, fullResync bool) {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	if r.backlog == nil {
+		r.backlog = NewBacklog(r.backlogSize)
+	}
+
+	if data, ok := r.partialResync(replID, offset); ok {
+		return fmt.Sprintf("+CONTINUE %s\r\n%s", r.replID, data), false
+	}
+	return fmt.Sprintf("+FULLRESYNC %s %d\r\n", r.replID, r.offset), true
+}
+
+func (r *Replication) partialResync(replID string, offset string) ([]byte, bool) {
+	psyncOffset, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	if replID != r.replID && (replID != r.replID2 || psyncOffset > r.secondReplIDOffset) {
+		return nil, false
+	}
+
+	// The backlog holds the bytes from firstByte up to the current offset
+	firstByte := r.offset - int64(r.backlog.Len()) + 1
+	if psyncOffset < firstByte || psyncOffset > r.offset+1 {
+		return nil, false
+	}
+	return r.backlog.Tail(int(r.offset + 1 - psyncOffset)), true
+}
+
+// Memory allocated for the backlog, there is none until the first replica attached
+func (r *Replication) BacklogMemory() int {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	if r.backlog == nil {
+		return 0
+	}
+	return r.backlog.Size()
+}
+
+// Fields of INFO replication describing the replication history and the backlog
+func (r *Replication) Info() [][2]string {
+	defer r.mu.Unlock()
+
+	r.mu.Lock()
+	active, size, firstByte, histlen := "0", r.backlogSize, int64(0), 0
+	if r.backlog != nil {
+		active, size, histlen = "1", r.backlog.Size(), r.backlog.Len()
+		firstByte = r.offset - int64(histlen) + 1
+	}
+
+	return [][2]string{
+		{"master_replid", r.replID},
+		{"master_replid2", r.replID2},
+		{"master_repl_offset", strconv.FormatInt(r.offset, 10)},
+		{"second_repl_offset", strconv.FormatInt(r.secondReplIDOffset, 10)},
+		{"repl_backlog_active", active},
+		{"repl_backlog_size", strconv.Itoa(size)},
+		{"repl_backlog_first_byte_offset", strconv.FormatInt(firstByte, 10)},
+		{"repl_backlog_histlen", strconv.Itoa(histlen)},
+	}
+}
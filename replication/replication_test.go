@@ -0,0 +1,75 @@
+package replication
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBacklogWrapsAround(t *testing.T) {
+	b := NewBacklog(8)
+	b.Feed([]byte("abcdef"))
+	b.Feed([]byte("ghij"))
+
+	if b.Len() != 8 || string(b.Tail(8)) != "cdefghij" {
+		t.Fatalf("Unexpected backlog content: %q", b.Tail(b.Len()))
+	}
+	if string(b.Tail(3)) != "hij" {
+		t.Errorf("Unexpected tail: %q", b.Tail(3))
+	}
+
+	b.Resize(4)
+	if b.Len() != 4 || string(b.Tail(4)) != "ghij" {
+		t.Errorf("Unexpected backlog after resize: %q", b.Tail(b.Len()))
+	}
+}
+
+func TestPsync(t *testing.T) {
+	r := NewReplication()
+	r.SetBacklogSize(16)
+
+	response, full := r.Psync("?", "-1")
+	if !full || response != "+FULLRESYNC "+r.ReplID()+" 0\r\n" {
+		t.Fatalf("Expected a full resync, got %q", response)
+	}
+
+	r.Feed("0123456789")
+	r.Feed("abcdefghij")
+
+	response, full = r.Psync(r.ReplID(), "16")
+	if full || response != "+CONTINUE "+r.ReplID()+"\r\nfghij" {
+		t.Errorf("Expected to continue from the backlog, got %q", response)
+	}
+	response, full = r.Psync(r.ReplID(), "21")
+	if full || !strings.HasSuffix(response, "\r\n") {
+		t.Errorf("Expected to continue with nothing missing, got %q", response)
+	}
+
+	// Offset 2 was overwritten, and a different history always needs a full resync
+	if _, full = r.Psync(r.ReplID(), "2"); !full {
+		t.Errorf("Expected a full resync for an offset out of the backlog")
+	}
+	if _, full = r.Psync(NewReplID(), "16"); !full {
+		t.Errorf("Expected a full resync for an unknown replication id")
+	}
+}
+
+func TestContinueHistoryWithNewReplID(t *testing.T) {
+	r := NewReplication()
+	r.ResetHistory(NewReplID(), 100)
+	r.Feed("0123456789")
+
+	oldID, newID := r.ReplID(), NewReplID()
+	r.ContinueHistory(newID)
+	if r.ReplID() != newID || r.Offset() != 110 {
+		t.Fatalf("Unexpected history after switching id: %s %d", r.ReplID(), r.Offset())
+	}
+
+	// Replicas of the old history continue up to where it ended
+	if _, full := r.Psync(oldID, "106"); full {
+		t.Errorf("Expected to continue with the previous replication id")
+	}
+	r.Feed("abc")
+	if _, full := r.Psync(oldID, "112"); !full {
+		t.Errorf("Expected a full resync past the end of the previous history")
+	}
+}
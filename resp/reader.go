@@ -0,0 +1,126 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrProtocol = errors.New("protocol error")
+
+/*
+* Reads complete commands (arrays of bulk strings) from a stream. Unlike RESPDeserializeCommand it
+* never sees partial input: a command split over several reads is completed by the next read, and
+* the number of bytes every command took is reported
+ */
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// A reader with a buffer of the given size, commands larger than the buffer are still read whole
+func NewReaderSize(r io.Reader, size int) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, size)}
+}
+
+// The buffered reader, for callers that read data that is not a command (e.g. an RDB transfer)
+func (r *Reader) Buffered() *bufio.Reader {
+	return r.r
+}
+
+/*
+* Reads the next command and the number of bytes it took. Returns io.EOF if the stream ended
+* between two commands and io.ErrUnexpectedEOF if it ended in the middle of one
+ */
+func (r *Reader) ReadCommand() ([]string, int, error) {
+	return r.readCommand(nil)
+}
+
+/*
+* Reads the next command along with the exact bytes it was sent as, for streams whose byte
+* count matters (the replication stream) or that are forwarded as is
+ */
+func (r *Reader) ReadRawCommand() ([]string, []byte, error) {
+	raw := &bytes.Buffer{}
+	command, _, err := r.readCommand(raw)
+	return command, raw.Bytes(), err
+}
+
+func (r *Reader) readCommand(raw *bytes.Buffer) ([]string, int, error) {
+	line, err := r.readLine(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	read := len(line) + 2
+
+	if !strings.HasPrefix(line, RESPArray) {
+		return nil, read, fmt.Errorf("%w: expected '*', got '%.1s'", ErrProtocol, line)
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return nil, read, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	command := make([]string, 0, length)
+	for i := 0; i < length; i++ {
+		line, err := r.readLine(raw)
+		if err != nil {
+			return nil, read, unexpectedEOF(err)
+		}
+		read += len(line) + 2
+
+		if !strings.HasPrefix(line, RESPBulk) {
+			return nil, read, fmt.Errorf("%w: expected '$', got '%.1s'", ErrProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, read, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, read, unexpectedEOF(err)
+		}
+		if string(data[size:]) != "\r\n" {
+			return nil, read, fmt.Errorf("%w: bulk string is not terminated by CRLF", ErrProtocol)
+		}
+		if raw != nil {
+			raw.Write(data)
+		}
+		read += size + 2
+		command = append(command, string(data[:size]))
+	}
+
+	return command, read, nil
+}
+
+func (r *Reader) readLine(raw *bytes.Buffer) (string, error) {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("%w: line is not terminated by CRLF", ErrProtocol)
+	}
+	if raw != nil {
+		raw.WriteString(line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
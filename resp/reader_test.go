@@ -0,0 +1,41 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderReadCommand(t *testing.T) {
+	raw := "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5\r\na\r\nb!\r\n*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET"
+	reader := NewReader(strings.NewReader(raw))
+
+	command, n, err := reader.ReadCommand()
+	if err != nil || strings.Join(command, " ") != "SET foo a\r\nb!" || n != 33 {
+		t.Fatalf("Unexpected first command %q (%d bytes, err %v)", command, n, err)
+	}
+	command, n, err = reader.ReadCommand()
+	if err != nil || strings.Join(command, " ") != "PING" || n != 14 {
+		t.Fatalf("Unexpected second command %q (%d bytes, err %v)", command, n, err)
+	}
+	if _, _, err = reader.ReadCommand(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected unexpected EOF for a truncated command, got %v", err)
+	}
+}
+
+func TestReaderProtocolError(t *testing.T) {
+	reader := NewReader(strings.NewReader("*1\r\n$3\r\nfoobar\r\n"))
+	if _, _, err := reader.ReadCommand(); !errors.Is(err, ErrProtocol) {
+		t.Fatalf("Expected a protocol error, got %v", err)
+	}
+}
+
+func TestReaderReadRawCommand(t *testing.T) {
+	// Not how RESPSerializeRESPArray would write it, the raw bytes are kept as received
+	raw := "*2\r\n$04\r\nECHO\r\n$2\r\nhi\r\n"
+	command, data, err := NewReader(strings.NewReader(raw)).ReadRawCommand()
+	if err != nil || strings.Join(command, " ") != "ECHO hi" || string(data) != raw {
+		t.Fatalf("Unexpected command %q (raw %q, err %v)", command, data, err)
+	}
+}
@@ -9,9 +9,13 @@ import (
 
 // RESP Types
 const (
-	RESPArray  = "*"
-	RESPBulk   = "$"
-	RESPSimple = "+"
+	RESPArray   = "*"
+	RESPBulk    = "$"
+	RESPSimple  = "+"
+	RESPError   = "-"
+	RESPInteger = ":"
+	RESPMap     = "%"
+	RESPPush    = ">"
 )
 
 // Hardcoded serialized responses
@@ -66,6 +70,47 @@ func RESPSerializeBulkString(str string) string {
 	return fmt.Sprintf("$%d\r\n%s\r\n", len(str), str)
 }
 
+func RESPSerializeError(str string) string {
+	return fmt.Sprintf("-%s\r\n", str)
+}
+
+func RESPSerializeInteger(n int) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+// Wraps elements that are already serialized (integers, nested arrays, nil etc.) in a RESP array
+func RESPSerializeRawArray(elements []string) string {
+	str := fmt.Sprintf("*%d\r\n", len(elements))
+
+	for _, elem := range elements {
+		str += elem
+	}
+
+	return str
+}
+
+// RESP3 map of already serialized key/value elements, the number of elements must be even
+func RESPSerializeMap(elements []string) string {
+	str := fmt.Sprintf("%%%d\r\n", len(elements)/2)
+
+	for _, elem := range elements {
+		str += elem
+	}
+
+	return str
+}
+
+// RESP3 out of band push message made of already serialized elements
+func RESPSerializePush(elements []string) string {
+	str := fmt.Sprintf(">%d\r\n", len(elements))
+
+	for _, elem := range elements {
+		str += elem
+	}
+
+	return str
+}
+
 func RESPSerializeFile(str string) string {
 	return fmt.Sprintf("$%d\r\n%s", len(str), str)
 }
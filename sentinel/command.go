@@ -0,0 +1,334 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Number of arguments of the SENTINEL subcommands, the subcommand included
+var sentinelArity = map[string]int{
+	"GET-MASTER-ADDR-BY-NAME": 2,
+	"MASTERS":                 1,
+	"MASTER":                  2,
+	"REPLICAS":                2,
+	"SLAVES":                  2,
+	"SENTINELS":               2,
+	"IS-MASTER-DOWN-BY-ADDR":  5,
+	"CKQUORUM":                2,
+	"MYID":                    1,
+	"FAILOVER":                2,
+	"MONITOR":                 5,
+	"REMOVE":                  2,
+	"SET":                     4,
+}
+
+/*
+* SENTINEL <subcommand>: asking for the current master of a name, inspecting what the sentinel
+* knows, and changing the monitored masters at runtime. Other sentinels use it to ask whether a
+* master is down and to collect votes
+ */
+func (s *Sentinel) sentinelCommand(cmd []string) string {
+	if len(cmd) < 2 {
+		return resp.RESPSerializeError("ERR wrong number of arguments for 'sentinel' command")
+	}
+	sub := strings.ToUpper(cmd[1])
+	args := cmd[1:]
+	arity, ok := sentinelArity[sub]
+	if !ok {
+		return resp.RESPSerializeError(fmt.Sprintf("ERR Unknown sentinel subcommand '%s'", cmd[1]))
+	}
+	if len(args) != arity {
+		return resp.RESPSerializeError(fmt.Sprintf("ERR wrong number of arguments for 'sentinel|%s' command", strings.ToLower(sub)))
+	}
+
+	switch sub {
+	case "MONITOR":
+		return s.monitorCommand(args)
+	case "MASTERS":
+		masters := []string{}
+		for _, name := range s.masterNames() {
+			masters = append(masters, s.masterDetails(s.masters[name]))
+		}
+		return resp.RESPSerializeRawArray(masters)
+	case "MYID":
+		return resp.RESPSerializeBulkString(s.myID)
+	case "IS-MASTER-DOWN-BY-ADDR":
+		return s.isMasterDownByAddr(args)
+	}
+
+	m := s.masters[args[1]]
+	if m == nil {
+		if sub == "GET-MASTER-ADDR-BY-NAME" {
+			return resp.RESPNil
+		}
+		return resp.RESPSerializeError("ERR No such master with that name")
+	}
+
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME":
+		host, port, _ := net.SplitHostPort(currentAddr(m))
+		return resp.RESPSerializeRESPArray([]string{host, port})
+	case "MASTER":
+		return s.masterDetails(m)
+	case "REPLICAS", "SLAVES":
+		return instancesDetails(m, m.replicas)
+	case "SENTINELS":
+		return instancesDetails(m, m.sentinels)
+	case "CKQUORUM":
+		return s.ckquorum(m)
+	case "FAILOVER":
+		return s.forceFailover(m)
+	case "REMOVE":
+		s.remove(m)
+		return resp.RESPSerializeSimpleString("OK")
+	default:
+		return s.setCommand(m, args[2], args[3])
+	}
+}
+
+func (s *Sentinel) monitorCommand(args []string) string {
+	port, err := strconv.Atoi(args[3])
+	if err != nil || port <= 0 || port > 65535 {
+		return resp.RESPSerializeError("ERR Invalid port")
+	}
+	quorum, err := strconv.Atoi(args[4])
+	if err != nil {
+		return resp.RESPSerializeError("ERR value is not an integer or out of range")
+	}
+	if err := s.Monitor(args[1], args[2], args[3], quorum); err != nil {
+		return resp.RESPSerializeError("ERR " + err.Error())
+	}
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// Stops monitoring a master, its replicas and the other sentinels that monitor it are forgotten too
+func (s *Sentinel) remove(m *master) {
+	s.event("-monitor", m, m.instance, "")
+	m.close()
+	for _, r := range m.replicas {
+		r.close()
+	}
+	for _, other := range m.sentinels {
+		other.close()
+	}
+	delete(s.masters, m.name)
+}
+
+/*
+* SENTINEL IS-MASTER-DOWN-BY-ADDR ip port current-epoch runid. Replies whether this sentinel
+* sees the master down, and when runid is not * votes for it as the failover leader of the epoch
+ */
+func (s *Sentinel) isMasterDownByAddr(args []string) string {
+	epoch, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return resp.RESPSerializeError("ERR value is not an integer or out of range")
+	}
+
+	addr := net.JoinHostPort(args[1], args[2])
+	down, leader, leaderEpoch := 0, "*", int64(0)
+	for _, m := range s.masters {
+		if m.addr != addr {
+			continue
+		}
+		if m.sdown {
+			down = 1
+		}
+		if args[4] != "*" {
+			leader, leaderEpoch = s.vote(m, args[4], epoch)
+		}
+		break
+	}
+
+	return resp.RESPSerializeRawArray([]string{
+		resp.RESPSerializeInteger(down),
+		resp.RESPSerializeBulkString(leader),
+		resp.RESPSerializeInteger(int(leaderEpoch)),
+	})
+}
+
+// Whether the sentinels that are up can reach the quorum and authorize a failover
+func (s *Sentinel) ckquorum(m *master) string {
+	voters, usable := len(m.sentinels)+1, 1
+	for _, other := range m.sentinels {
+		if !other.sdown {
+			usable++
+		}
+	}
+
+	if usable < m.quorum {
+		return resp.RESPSerializeError(fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable))
+	}
+	if usable < voters/2+1 {
+		return resp.RESPSerializeError(fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable))
+	}
+	return resp.RESPSerializeSimpleString(fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable))
+}
+
+// SENTINEL FAILOVER starts a failover right away, without asking the other sentinels
+func (s *Sentinel) forceFailover(m *master) string {
+	if m.failoverState != failoverNone {
+		return resp.RESPSerializeError("INPROG Failover already in progress")
+	}
+	if selectReplica(m.replicas) == nil {
+		return resp.RESPSerializeError("NOGOODSLAVE No suitable replica to promote")
+	}
+
+	s.startFailover(m)
+	m.forceFailover = true
+	m.failoverStart = time.Now()
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+// SENTINEL SET name option value, for the options that can be set per master
+func (s *Sentinel) setCommand(m *master, option string, value string) string {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return resp.RESPSerializeError(fmt.Sprintf("ERR Invalid argument '%s' for SENTINEL SET '%s'", value, option))
+	}
+
+	switch strings.ToLower(option) {
+	case "down-after-milliseconds":
+		m.downAfter = time.Duration(n) * time.Millisecond
+	case "failover-timeout":
+		m.failoverTimeout = time.Duration(n) * time.Millisecond
+	case "quorum":
+		m.quorum = n
+	case "parallel-syncs":
+		m.parallelSyncs = n
+	default:
+		return resp.RESPSerializeError(fmt.Sprintf("ERR Invalid argument '%s' for SENTINEL SET", option))
+	}
+	s.event("+set", m, m.instance, fmt.Sprintf("%s %s", option, value))
+	return resp.RESPSerializeSimpleString("OK")
+}
+
+func (s *Sentinel) masterNames() []string {
+	names := []string{}
+	for name := range s.masters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+/*
+* Fields of an instance as flat name, value pairs like Redis Sentinel replies. The flags tell
+* its kind and what the sentinel thinks of it: s_down, o_down, disconnected, promoted...
+ */
+func instanceFields(m *master, inst *instance) []string {
+	flags := []string{inst.kind}
+	if inst.sdown {
+		flags = append(flags, "s_down")
+	}
+	if inst == m.instance && m.odown {
+		flags = append(flags, "o_down")
+	}
+	if !inst.link.connected() {
+		flags = append(flags, "disconnected")
+	}
+	if inst == m.instance && m.failoverState != failoverNone {
+		flags = append(flags, "failover_in_progress")
+	}
+	if inst == m.promoted {
+		flags = append(flags, "promoted")
+	}
+	if inst.reconfSent && !inst.reconfDone {
+		flags = append(flags, "reconf_sent")
+	}
+
+	lastPingSent := int64(0)
+	if !inst.pingSent.IsZero() {
+		lastPingSent = time.Since(inst.pingSent).Milliseconds()
+	}
+	fields := []string{
+		"name", inst.addr,
+		"ip", inst.host(),
+		"port", inst.port(),
+		"runid", inst.runID,
+		"flags", strings.Join(flags, ","),
+		"link-pending-commands", strconv.Itoa(inst.link.pending),
+		"last-ping-sent", strconv.FormatInt(lastPingSent, 10),
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(inst.lastAvail).Milliseconds(), 10),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+	}
+	if inst.kind != kindSentinel {
+		fields = append(fields, "role-reported", inst.role)
+	}
+	if inst.kind == kindReplica {
+		linkStatus := "err"
+		if inst.masterLinkUp {
+			linkStatus = "ok"
+		}
+		fields = append(fields,
+			"master-link-status", linkStatus,
+			"master-host", inst.masterHost,
+			"master-port", inst.masterPort,
+			"slave-priority", strconv.Itoa(inst.priority),
+			"slave-repl-offset", strconv.FormatInt(inst.replOffset, 10),
+		)
+	}
+	if inst.kind == kindSentinel {
+		fields = append(fields, "last-hello-message", strconv.FormatInt(time.Since(inst.lastHello).Milliseconds(), 10))
+	}
+	return fields
+}
+
+func (s *Sentinel) masterDetails(m *master) string {
+	fields := instanceFields(m, m.instance)
+	fields[1] = m.name
+	fields = append(fields,
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		"parallel-syncs", strconv.Itoa(m.parallelSyncs),
+	)
+	if m.failoverState != failoverNone {
+		fields = append(fields, "failover-state", m.failoverState)
+	}
+	return resp.RESPSerializeRESPArray(fields)
+}
+
+func instancesDetails(m *master, instances map[string]*instance) string {
+	keys := []string{}
+	for key := range instances {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	details := []string{}
+	for _, key := range keys {
+		details = append(details, resp.RESPSerializeRESPArray(instanceFields(m, instances[key])))
+	}
+	return resp.RESPSerializeRawArray(details)
+}
+
+// INFO of a sentinel: only its own section, with one line per monitored master
+func (s *Sentinel) infoCommand() string {
+	info := fmt.Sprintf("# Sentinel\r\nsentinel_masters:%d\r\n", len(s.masters))
+	for i, name := range s.masterNames() {
+		m := s.masters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		}
+		info += fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, name, status, currentAddr(m), len(m.replicas), len(m.sentinels)+1)
+	}
+	return resp.RESPSerializeBulkString(info)
+}
+
+// ROLE of a sentinel lists the names of the masters it monitors
+func (s *Sentinel) roleCommand() string {
+	return resp.RESPSerializeRawArray([]string{
+		resp.RESPSerializeBulkString("sentinel"),
+		resp.RESPSerializeRESPArray(s.masterNames()),
+	})
+}
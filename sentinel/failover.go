@@ -0,0 +1,314 @@
+package sentinel
+
+import (
+	"log/slog"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States of a failover, in the order it goes through them
+const (
+	failoverNone             = "none"
+	failoverWaitStart        = "wait_start"
+	failoverSelectSlave      = "select_slave"
+	failoverSendSlaveofNoOne = "send_slaveof_noone"
+	failoverWaitPromotion    = "wait_promotion"
+	failoverReconfSlaves     = "reconf_slaves"
+)
+
+// Sentinels starting a failover at the same time would split the votes, so they start at random times within it
+const maxDesync = time.Second
+
+// A failover is attempted once the master is objectively down, and not again before twice failover-timeout
+func (s *Sentinel) shouldStartFailover(m *master) bool {
+	if !m.odown || m.failoverState != failoverNone {
+		return false
+	}
+	return time.Since(m.failoverStart) >= 2*m.failoverTimeout
+}
+
+/*
+* Starts a failover in a new epoch. This sentinel then asks the others for their vote, the one
+* elected leader of the epoch by a majority runs the failover
+ */
+func (s *Sentinel) startFailover(m *master) {
+	s.updateEpoch(s.currentEpoch + 1)
+	m.failoverEpoch = s.currentEpoch
+	m.failoverStart = time.Now().Add(time.Duration(rand.Int63n(int64(maxDesync))))
+	s.setFailoverState(m, failoverWaitStart, m.instance, "+try-failover")
+}
+
+func (s *Sentinel) setFailoverState(m *master, state string, inst *instance, event string) {
+	m.failoverState = state
+	m.failoverStateChange = time.Now()
+	s.event(event, m, inst, "")
+}
+
+func (s *Sentinel) abortFailover(m *master, reason string) {
+	s.event(reason, m, m.instance, "")
+	m.failoverState = failoverNone
+	m.failoverStateChange = time.Now()
+	m.forceFailover = false
+	m.promoted = nil
+	for _, r := range m.replicas {
+		r.reconfSent, r.reconfDone = false, false
+	}
+}
+
+/*
+* Gives the vote of this sentinel for the failover of m in epoch to runID, unless it already
+* voted in that epoch. Returns the leader voted for and its epoch. Voting for another sentinel
+* delays the failovers this sentinel may start, leaving the leader time to run it
+ */
+func (s *Sentinel) vote(m *master, runID string, epoch int64) (string, int64) {
+	s.updateEpoch(epoch)
+
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader, m.leaderEpoch = runID, s.currentEpoch
+		s.event("+vote-for-leader", m, m.instance, runID+" "+strconv.FormatInt(m.leaderEpoch, 10))
+		if runID != s.myID {
+			m.failoverStart = time.Now().Add(time.Duration(rand.Int63n(int64(maxDesync))))
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+/*
+* Counts the votes of the epoch: those other sentinels reported, plus the vote of this sentinel
+* which goes to the sentinel with most votes so far, or to itself. The leader needs both the
+* majority of the sentinels and the quorum of the master
+ */
+func (s *Sentinel) getLeader(m *master, epoch int64) string {
+	votes := map[string]int{}
+	for _, other := range m.sentinels {
+		if other.leader != "" && other.leaderEpoch == s.currentEpoch {
+			votes[other.leader]++
+		}
+	}
+
+	winner, maxVotes := "", 0
+	for runID, count := range votes {
+		if count > maxVotes || (count == maxVotes && runID < winner) {
+			winner, maxVotes = runID, count
+		}
+	}
+	if winner == "" {
+		winner = s.myID
+	}
+	myVote, _ := s.vote(m, winner, epoch)
+	votes[myVote]++
+	if votes[myVote] > maxVotes {
+		winner, maxVotes = myVote, votes[myVote]
+	}
+
+	voters := len(m.sentinels) + 1
+	if maxVotes < voters/2+1 || maxVotes < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+// Moves the failover of a master forward, every step waits for the previous one to complete
+func (s *Sentinel) failoverStateMachine(m *master) {
+	switch m.failoverState {
+	case failoverWaitStart:
+		s.failoverWaitStart(m)
+	case failoverSelectSlave:
+		s.failoverSelectSlave(m)
+	case failoverSendSlaveofNoOne:
+		s.failoverSendSlaveofNoOne(m)
+	case failoverWaitPromotion:
+		if time.Since(m.failoverStateChange) > m.failoverTimeout {
+			s.abortFailover(m, "-failover-abort-slave-timeout")
+		}
+	case failoverReconfSlaves:
+		s.failoverReconfSlaves(m)
+	}
+}
+
+func (s *Sentinel) failoverWaitStart(m *master) {
+	if time.Now().Before(m.failoverStart) {
+		return
+	}
+	if !m.forceFailover && s.getLeader(m, m.failoverEpoch) != s.myID {
+		if time.Since(m.failoverStart) > m.failoverTimeout {
+			s.abortFailover(m, "-failover-abort-not-elected")
+		}
+		return
+	}
+
+	s.event("+elected-leader", m, m.instance, "")
+	s.setFailoverState(m, failoverSelectSlave, m.instance, "+failover-state-select-slave")
+}
+
+func (s *Sentinel) failoverSelectSlave(m *master) {
+	promoted := selectReplica(m.replicas)
+	if promoted == nil {
+		s.abortFailover(m, "-failover-abort-no-good-slave")
+		return
+	}
+
+	m.promoted = promoted
+	s.event("+selected-slave", m, promoted, "")
+	s.setFailoverState(m, failoverSendSlaveofNoOne, promoted, "+failover-state-send-slaveof-noone")
+}
+
+/*
+* Picks the replica to promote among those that are up and recently replied: the lowest
+* replica priority first (0 means never), then the one that replicated the most, then the
+* lowest address so every sentinel picks the same one
+ */
+func selectReplica(replicas map[string]*instance) *instance {
+	candidates := []*instance{}
+	for _, r := range replicas {
+		if r.sdown || time.Since(r.lastAvail) > 5*pingPeriod || r.role != "slave" || r.priority == 0 {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	slices.SortFunc(candidates, func(a *instance, b *instance) int {
+		if a.priority != b.priority {
+			return a.priority - b.priority
+		}
+		if a.replOffset != b.replOffset {
+			return int(b.replOffset - a.replOffset)
+		}
+		return strings.Compare(a.addr, b.addr)
+	})
+	return candidates[0]
+}
+
+// The promoted replica is told to become a master, INFO tells once it did
+func (s *Sentinel) failoverSendSlaveofNoOne(m *master) {
+	if m.promoted.sdown {
+		if time.Since(m.failoverStateChange) > m.failoverTimeout {
+			s.abortFailover(m, "-failover-abort-slave-timeout")
+		}
+		return
+	}
+
+	promoted := m.promoted
+	s.send(promoted, func(r reply, err error) {
+		if err != nil || r.isError() {
+			slog.Warn("Failed to promote the replica", "addr", promoted.addr, "err", err, "reply", r.str)
+		}
+	}, "REPLICAOF", "NO", "ONE")
+	s.setFailoverState(m, failoverWaitPromotion, promoted, "+failover-state-wait-promotion")
+}
+
+/*
+* The promoted replica reported it is a master: the failover succeeded and its configuration is
+* announced right away in the failover epoch, other sentinels switch to it from the hellos
+ */
+func (s *Sentinel) replicaPromoted(m *master) {
+	m.configEpoch = m.failoverEpoch
+	s.event("+promoted-slave", m, m.promoted, "")
+	s.setFailoverState(m, failoverReconfSlaves, m.instance, "+failover-state-reconf-slaves")
+
+	m.lastHello = time.Time{}
+	for _, r := range m.replicas {
+		r.lastHello = time.Time{}
+	}
+}
+
+/*
+* Points the other replicas to the promoted one, at most parallel-syncs at a time since they
+* cannot serve reads while they resync. The failover ends once they all follow it, or after
+* failover-timeout in which case the remaining ones are all sent the command at once
+ */
+func (s *Sentinel) failoverReconfSlaves(m *master) {
+	inProgress := 0
+	for _, r := range m.replicas {
+		if r.reconfSent && !r.reconfDone {
+			inProgress++
+		}
+	}
+
+	timeout := time.Since(m.failoverStateChange) > m.failoverTimeout
+	done := true
+	for _, r := range m.replicas {
+		if r == m.promoted || r.reconfDone || r.sdown {
+			continue
+		}
+		done = false
+		if r.reconfSent || (inProgress >= m.parallelSyncs && !timeout) {
+			continue
+		}
+
+		s.send(r, nil, "REPLICAOF", m.promoted.host(), m.promoted.port())
+		r.reconfSent = true
+		inProgress++
+		s.event("+slave-reconf-sent", m, r, "")
+	}
+
+	if !done && !timeout {
+		return
+	}
+	if timeout {
+		s.event("+failover-end-for-timeout", m, m.instance, "")
+	}
+	s.event("+failover-end", m, m.instance, "")
+	s.switchMaster(m, m.promoted.addr)
+}
+
+// Once the promoted replica became a master its address is the one given to clients and other sentinels
+func currentAddr(m *master) string {
+	if m.failoverState == failoverReconfSlaves {
+		return m.promoted.addr
+	}
+	return m.addr
+}
+
+// A replica reconfigured by the failover reports the promoted replica as its master with the link up
+func (s *Sentinel) checkReconfDone(m *master, r *instance) {
+	if !r.reconfSent || r.reconfDone || m.promoted == nil {
+		return
+	}
+	if net.JoinHostPort(r.masterHost, r.masterPort) == m.promoted.addr && r.masterLinkUp {
+		r.reconfDone = true
+		s.event("+slave-reconf-done", m, r, "")
+	}
+}
+
+/*
+* Makes addr the master of m, after a failover or because another sentinel announced it. The
+* replicas are the known ones plus the old master, which is converted once it comes back
+ */
+func (s *Sentinel) switchMaster(m *master, addr string) {
+	old := m.instance
+	current := newInstance(kindMaster, addr)
+	msg := strings.Join([]string{m.name, old.host(), old.port(), current.host(), current.port()}, " ")
+	slog.Info("Sentinel event", "event", "+switch-master", "msg", msg)
+	s.server.PubSub.Publish("+switch-master", msg)
+
+	replicas := map[string]*instance{}
+	for replicaAddr, r := range m.replicas {
+		r.close()
+		if replicaAddr != addr {
+			replicas[replicaAddr] = newInstance(kindReplica, replicaAddr)
+		}
+	}
+	if old.addr != addr {
+		replicas[old.addr] = newInstance(kindReplica, old.addr)
+	}
+	old.close()
+
+	m.instance = current
+	m.replicas = replicas
+	m.odown = false
+	m.failoverState = failoverNone
+	m.failoverStateChange = time.Now()
+	m.forceFailover = false
+	m.promoted = nil
+	for _, r := range m.replicas {
+		s.event("+slave", m, r, "")
+	}
+}
@@ -0,0 +1,287 @@
+package sentinel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Kinds of monitored instances, as they appear in events and SENTINEL replies
+const (
+	kindMaster   = "master"
+	kindReplica  = "slave"
+	kindSentinel = "sentinel"
+)
+
+/*
+* A server the sentinel talks to: a monitored master, one of its replicas or another sentinel
+* monitoring the same master. Only the sentinel loop touches it, replies of the instance are
+* handed to the loop
+ */
+type instance struct {
+	kind  string
+	addr  string
+	runID string
+	link  *link
+
+	// Last PING sent and last valid reply. pingSent is when the oldest PING without a valid reply
+	// was sent, an instance is down once it is older than down-after
+	lastPing  time.Time
+	lastAvail time.Time
+	pingSent  time.Time
+	// A PING is waiting for its reply
+	pinging bool
+	// Last INFO reply, last hello published to it (masters and replicas) or received from it (sentinels)
+	lastInfo  time.Time
+	lastHello time.Time
+	sdown     bool
+	// Subscribed to the hello channel of the instance
+	subscribed bool
+
+	// Role reported by INFO and since when
+	role      string
+	roleSince time.Time
+	// Replication state reported by a replica
+	masterHost   string
+	masterPort   string
+	masterLinkUp bool
+	replOffset   int64
+	priority     int
+	// A replica that was told to follow another master, and that it does during a failover
+	reconfSent bool
+	reconfDone bool
+
+	// Answers of another sentinel to is-master-down-by-addr: whether it sees the master down and
+	// the leader it voted for
+	masterDown      bool
+	masterDownReply time.Time
+	lastAsk         time.Time
+	leader          string
+	leaderEpoch     int64
+
+	// Closed once the instance is forgotten, stops its background connections
+	done chan struct{}
+}
+
+func newInstance(kind string, addr string) *instance {
+	return &instance{
+		kind:      kind,
+		addr:      addr,
+		link:      &link{addr: addr},
+		lastAvail: time.Now(),
+		priority:  defaultReplicaPriority,
+		done:      make(chan struct{}),
+	}
+}
+
+func (i *instance) host() string {
+	host, _, _ := net.SplitHostPort(i.addr)
+	return host
+}
+
+func (i *instance) port() string {
+	_, port, _ := net.SplitHostPort(i.addr)
+	return port
+}
+
+// Closes the connections of an instance that is not monitored anymore
+func (i *instance) close() {
+	close(i.done)
+	i.link.close()
+}
+
+func (i *instance) closed() bool {
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// A master monitored by the sentinel, with what it learnt about its replicas and other sentinels
+type master struct {
+	*instance
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	parallelSyncs   int
+	// Epoch of the failover that made the current address the master
+	configEpoch int64
+
+	// Keyed by address, other sentinels by run id
+	replicas  map[string]*instance
+	sentinels map[string]*instance
+
+	odown bool
+	// Vote this sentinel gave for a failover of this master and its epoch
+	leader      string
+	leaderEpoch int64
+
+	failoverState       string
+	failoverEpoch       int64
+	failoverStart       time.Time
+	failoverStateChange time.Time
+	// SENTINEL FAILOVER skips the agreement with other sentinels
+	forceFailover bool
+	promoted      *instance
+}
+
+func newMaster(name string, addr string, quorum int, settings Settings) *master {
+	return &master{
+		instance:        newInstance(kindMaster, addr),
+		name:            name,
+		quorum:          quorum,
+		downAfter:       settings.DownAfter,
+		failoverTimeout: settings.FailoverTimeout,
+		parallelSyncs:   settings.ParallelSyncs,
+		replicas:        map[string]*instance{},
+		sentinels:       map[string]*instance{},
+		failoverState:   failoverNone,
+	}
+}
+
+/*
+* Connection used to send commands to an instance. Commands are sent one at a time by background
+* goroutines, the connection is opened on demand and dropped after an error
+ */
+type link struct {
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+	// Commands sent and not replied yet, only touched by the sentinel loop
+	pending int
+
+	mu sync.Mutex
+}
+
+// Sends a command and reads its reply, failing if the instance does not reply within timeout
+func (l *link) call(args []string, timeout time.Duration) (reply, error) {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, timeout)
+		if err != nil {
+			return reply{}, err
+		}
+		l.conn, l.reader = conn, bufio.NewReader(conn)
+	}
+
+	l.conn.SetDeadline(time.Now().Add(timeout))
+	r, err := l.roundTrip(args)
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	return r, err
+}
+
+func (l *link) roundTrip(args []string) (reply, error) {
+	if _, err := l.conn.Write([]byte(resp.RESPSerializeRESPArray(args))); err != nil {
+		return reply{}, err
+	}
+	return readReply(l.reader)
+}
+
+// IP this sentinel uses to reach the instance, the one announced to other sentinels
+func (l *link) localIP() string {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	if l.conn == nil {
+		return ""
+	}
+	host, _, _ := net.SplitHostPort(l.conn.LocalAddr().String())
+	return host
+}
+
+func (l *link) connected() bool {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	return l.conn != nil
+}
+
+func (l *link) close() {
+	defer l.mu.Unlock()
+
+	l.mu.Lock()
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// A reply of an instance: a simple string, error, integer, bulk string (nil if null) or array
+type reply struct {
+	kind  byte
+	str   string
+	null  bool
+	elems []reply
+}
+
+func (r reply) isError() bool {
+	return r.kind == resp.RESPError[0]
+}
+
+func (r reply) integer() int64 {
+	n, _ := strconv.ParseInt(r.str, 10, 64)
+	return n
+}
+
+func readReply(r *bufio.Reader) (reply, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return reply{}, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return reply{}, fmt.Errorf("empty reply")
+	}
+
+	rep := reply{kind: line[0], str: line[1:]}
+	switch line[0] {
+	case '+', '-', ':':
+		return rep, nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return reply{}, fmt.Errorf("invalid bulk length: %s", line)
+		}
+		if length < 0 {
+			rep.str, rep.null = "", true
+			return rep, nil
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return reply{}, err
+		}
+		rep.str = string(data[:length])
+		return rep, nil
+	case '*', '>':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return reply{}, fmt.Errorf("invalid array length: %s", line)
+		}
+		rep.str, rep.null = "", count < 0
+		for range max(count, 0) {
+			elem, err := readReply(r)
+			if err != nil {
+				return reply{}, err
+			}
+			rep.elems = append(rep.elems, elem)
+		}
+		return rep, nil
+	default:
+		return reply{}, fmt.Errorf("unexpected reply: %s", line)
+	}
+}
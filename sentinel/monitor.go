@@ -0,0 +1,374 @@
+package sentinel
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// Periodic work of the sentinel: monitoring every instance, then the failure detection and failover of every master
+func (s *Sentinel) cron() {
+	for _, m := range s.masters {
+		s.monitorInstance(m, m.instance)
+		for _, r := range m.replicas {
+			s.monitorInstance(m, r)
+		}
+		for _, other := range m.sentinels {
+			s.monitorInstance(m, other)
+		}
+
+		s.checkObjectivelyDown(m)
+		if s.shouldStartFailover(m) {
+			s.startFailover(m)
+		}
+		s.failoverStateMachine(m)
+		s.askMasterStateToOtherSentinels(m)
+	}
+}
+
+/*
+* PINGs an instance every second and marks it subjectively down when it has not replied for
+* down-after. Masters and replicas are also sent INFO, to discover replicas and follow their
+* role, and the hello of this sentinel
+ */
+func (s *Sentinel) monitorInstance(m *master, inst *instance) {
+	now := time.Now()
+
+	// One PING at a time, a PING that failed is sent again but the instance stays unreachable since the first
+	if !inst.pinging && now.Sub(inst.lastPing) >= min(pingPeriod, m.downAfter) {
+		inst.lastPing, inst.pinging = now, true
+		if inst.pingSent.IsZero() {
+			inst.pingSent = now
+		}
+		s.send(inst, func(r reply, err error) { pingReplied(inst, r, err) }, "PING")
+	}
+
+	if inst.kind != kindSentinel {
+		if !inst.subscribed {
+			s.subscribeHello(inst)
+		}
+
+		period := infoPeriod
+		// Replicas are followed closely while their master is down or being failed over
+		if inst.kind == kindReplica && (m.sdown || m.failoverState != failoverNone) {
+			period = time.Second
+		}
+		if now.Sub(inst.lastInfo) >= period && inst.link.pending < 2 {
+			inst.lastInfo = now
+			s.send(inst, func(r reply, err error) {
+				if err == nil && !r.isError() {
+					s.refreshFromInfo(m, inst, r.str)
+				}
+			}, "INFO", "replication")
+		}
+
+		if now.Sub(inst.lastHello) >= helloPeriod && inst.link.pending < 2 {
+			inst.lastHello = now
+			s.sendHello(m, inst)
+		}
+	}
+
+	s.checkSubjectivelyDown(m, inst, now)
+}
+
+// A valid reply to a PING means the instance is up, whatever other PINGs are still pending
+func pingReplied(inst *instance, r reply, err error) {
+	inst.pinging = false
+	// Servers of this project reply to PING with a one element array
+	if len(r.elems) == 1 {
+		r = r.elems[0]
+	}
+	// A server loading its dataset or cut from its master is still up
+	if err == nil && (r.str == "PONG" || strings.HasPrefix(r.str, "LOADING") || strings.HasPrefix(r.str, "MASTERDOWN")) {
+		inst.lastAvail, inst.pingSent = time.Now(), time.Time{}
+	}
+}
+
+/*
+* An instance is subjectively down once its oldest PING without a valid reply is older than
+* down-after. Like Redis only PINGs count, an INFO or a hello waiting for its reply does not
+ */
+func (s *Sentinel) checkSubjectivelyDown(m *master, inst *instance, now time.Time) {
+	sdown := !inst.pingSent.IsZero() && now.Sub(inst.pingSent) > m.downAfter
+	if sdown && !inst.sdown {
+		inst.sdown = true
+		s.event("+sdown", m, inst, "")
+	} else if !sdown && inst.sdown {
+		inst.sdown = false
+		s.event("-sdown", m, inst, "")
+	}
+}
+
+/*
+* Follows the replication fields of INFO: a master lists its replicas, which are monitored from
+* then on, a replica reports the master it follows. This is also how the sentinel learns that a
+* replica it promoted became a master and that replicas point to the master they should
+ */
+func (s *Sentinel) refreshFromInfo(m *master, inst *instance, info string) {
+	fields, replicas := parseInfo(info)
+
+	if role := fields["role"]; role != inst.role {
+		inst.role, inst.roleSince = role, time.Now()
+	}
+	if inst.role == "slave" {
+		inst.masterHost, inst.masterPort = fields["master_host"], fields["master_port"]
+		inst.masterLinkUp = fields["master_link_status"] == "up"
+		inst.replOffset, _ = strconv.ParseInt(fields["slave_repl_offset"], 10, 64)
+		if priority, err := strconv.Atoi(fields["slave_priority"]); err == nil {
+			inst.priority = priority
+		}
+	}
+
+	if inst == m.instance && inst.role == "master" {
+		for _, addr := range replicas {
+			if _, ok := m.replicas[addr]; !ok && addr != m.addr {
+				r := newInstance(kindReplica, addr)
+				m.replicas[addr] = r
+				s.event("+slave", m, r, "")
+			}
+		}
+	}
+
+	if inst.kind != kindReplica {
+		return
+	}
+
+	// The replica chosen by the failover reports it is a master now
+	if inst == m.promoted && m.failoverState == failoverWaitPromotion && inst.role == "master" {
+		s.replicaPromoted(m)
+		return
+	}
+
+	if m.failoverState == failoverReconfSlaves {
+		s.checkReconfDone(m, inst)
+	}
+	if m.failoverState != failoverNone || m.sdown {
+		return
+	}
+
+	// A replica that still (or again) acts as a master, typically the old master coming back after a failover
+	if inst.role == "master" && time.Since(inst.roleSince) > convertPeriod {
+		s.event("+convert-to-slave", m, inst, "")
+		s.send(inst, nil, "REPLICAOF", m.host(), m.port())
+		inst.roleSince = time.Now()
+		return
+	}
+
+	// A replica following another master than the one it should
+	if inst.role == "slave" && inst.masterHost != "" && net.JoinHostPort(inst.masterHost, inst.masterPort) != m.addr && !inst.reconfSent {
+		s.event("+fix-slave-config", m, inst, "")
+		s.send(inst, nil, "REPLICAOF", m.host(), m.port())
+		inst.reconfSent = true
+	} else if inst.masterLinkUp {
+		inst.reconfSent = false
+	}
+}
+
+/*
+* Parses an INFO reply into its fields, along with the ip:port of the replicas a master lists
+* as slaveN:ip=...,port=...
+ */
+func parseInfo(info string) (map[string]string, []string) {
+	fields := map[string]string{}
+	replicas := []string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		fields[key] = val
+
+		if !strings.HasPrefix(key, "slave") || strings.Trim(key[len("slave"):], "0123456789") != "" || len(key) == len("slave") {
+			continue
+		}
+		attrs := map[string]string{}
+		for _, attr := range strings.Split(val, ",") {
+			if k, v, ok := strings.Cut(attr, "="); ok {
+				attrs[k] = v
+			}
+		}
+		if attrs["ip"] != "" && attrs["port"] != "" {
+			replicas = append(replicas, net.JoinHostPort(attrs["ip"], attrs["port"]))
+		}
+	}
+	return fields, replicas
+}
+
+/*
+* Publishes the hello of this sentinel on the hello channel of an instance. Every sentinel
+* subscribes to it, that is how sentinels find each other and learn about failovers:
+* ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch
+ */
+func (s *Sentinel) sendHello(m *master, inst *instance) {
+	ip := inst.link.localIP()
+	if ip == "" {
+		return
+	}
+	masterHost, masterPort, _ := net.SplitHostPort(currentAddr(m))
+	hello := strings.Join([]string{
+		ip, s.port, s.myID, strconv.FormatInt(s.currentEpoch, 10),
+		m.name, masterHost, masterPort, strconv.FormatInt(m.configEpoch, 10),
+	}, ",")
+	s.send(inst, nil, "PUBLISH", helloChannel, hello)
+}
+
+/*
+* Subscribes to the hello channel of an instance on a dedicated connection, the hellos received
+* are processed on the sentinel loop. The subscription is made again after an error
+ */
+func (s *Sentinel) subscribeHello(inst *instance) {
+	inst.subscribed = true
+	go func() {
+		err := s.readHellos(inst)
+		slog.Debug("Hello subscription ended", "addr", inst.addr, "err", err)
+		s.events <- func() { inst.subscribed = false }
+	}()
+}
+
+func (s *Sentinel) readHellos(inst *instance) error {
+	conn, err := net.DialTimeout("tcp", inst.addr, replyTimeout)
+	if err != nil {
+		// Not retried right away, the cron subscribes again on its next run
+		time.Sleep(time.Second)
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-inst.done
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	if _, err := conn.Write([]byte(resp.RESPSerializeRESPArray([]string{"SUBSCRIBE", helloChannel}))); err != nil {
+		return err
+	}
+	for {
+		r, err := readReply(reader)
+		if err != nil {
+			return err
+		}
+		if len(r.elems) == 3 && r.elems[0].str == "message" {
+			hello := r.elems[2].str
+			s.events <- func() { s.processHello(hello) }
+		}
+	}
+}
+
+/*
+* Processes the hello of another sentinel: it is added to the sentinels of the master if it is
+* new, and if it announces a configuration of the master with a higher epoch (it ran a failover)
+* this sentinel switches to the master it announces
+ */
+func (s *Sentinel) processHello(hello string) {
+	parts := strings.Split(hello, ",")
+	if len(parts) != 8 || parts[2] == s.myID {
+		return
+	}
+	ip, port, runID, masterName, masterIP, masterPort := parts[0], parts[1], parts[2], parts[4], parts[5], parts[6]
+	epoch, err1 := strconv.ParseInt(parts[3], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(parts[7], 10, 64)
+	m := s.masters[masterName]
+	if m == nil || err1 != nil || err2 != nil {
+		return
+	}
+
+	other := m.sentinels[runID]
+	if other == nil {
+		// A sentinel restarted with a new run id replaces the old one at the same address
+		addr := net.JoinHostPort(ip, port)
+		for id, known := range m.sentinels {
+			if known.addr == addr {
+				known.close()
+				delete(m.sentinels, id)
+			}
+		}
+		other = newInstance(kindSentinel, addr)
+		other.runID = runID
+		m.sentinels[runID] = other
+		s.event("+sentinel", m, other, "")
+	}
+	other.lastHello = time.Now()
+
+	s.updateEpoch(epoch)
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if addr := net.JoinHostPort(masterIP, masterPort); addr != m.addr {
+			s.event("+config-update-from", m, other, "")
+			s.switchMaster(m, addr)
+		}
+	}
+}
+
+func (s *Sentinel) updateEpoch(epoch int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		slog.Info("Sentinel event", "event", "+new-epoch", "msg", epoch)
+		s.server.PubSub.Publish("+new-epoch", strconv.FormatInt(epoch, 10))
+	}
+}
+
+/*
+* A master is objectively down when enough sentinels, this one included, see it subjectively
+* down to reach its quorum
+ */
+func (s *Sentinel) checkObjectivelyDown(m *master) {
+	odown := m.sdown && s.countDownVotes(m) >= m.quorum
+
+	if odown && !m.odown {
+		m.odown = true
+		s.event("+odown", m, m.instance, fmt.Sprintf("#quorum %d/%d", s.countDownVotes(m), m.quorum))
+	} else if !odown && m.odown {
+		m.odown = false
+		s.event("-odown", m, m.instance, "")
+	}
+}
+
+// Sentinels that see the master down, this one included
+func (s *Sentinel) countDownVotes(m *master) int {
+	votes := 1
+	for _, other := range m.sentinels {
+		if other.masterDown && time.Since(other.masterDownReply) < masterDownValidity {
+			votes++
+		}
+	}
+	return votes
+}
+
+/*
+* While the master is subjectively down the other sentinels are asked whether they see it down
+* too. During a failover the request carries the run id of this sentinel, asking for its vote
+ */
+func (s *Sentinel) askMasterStateToOtherSentinels(m *master) {
+	if !m.sdown {
+		return
+	}
+
+	runID := "*"
+	if m.failoverState != failoverNone {
+		runID = s.myID
+	}
+	for _, other := range m.sentinels {
+		if other.link.pending > 0 || time.Since(other.lastAsk) < askPeriod {
+			continue
+		}
+		other.lastAsk = time.Now()
+		s.send(other, func(r reply, err error) {
+			if err != nil || len(r.elems) != 3 {
+				return
+			}
+			other.masterDown = r.elems[0].integer() == 1
+			other.masterDownReply = time.Now()
+			if leader := r.elems[1].str; leader != "*" {
+				other.leader, other.leaderEpoch = leader, r.elems[2].integer()
+			}
+		}, SENTINEL, "is-master-down-by-addr", m.host(), m.port(), strconv.FormatInt(s.currentEpoch, 10), runID)
+	}
+}
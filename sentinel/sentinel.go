@@ -0,0 +1,225 @@
+package sentinel
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/command"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/replication"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+const (
+	SENTINEL = "SENTINEL"
+
+	// Channel of the monitored instances sentinels announce themselves and their configuration on
+	helloChannel = "__sentinel__:hello"
+
+	cronInterval  = 100 * time.Millisecond
+	pingPeriod    = time.Second
+	infoPeriod    = 10 * time.Second
+	helloPeriod   = 2 * time.Second
+	askPeriod     = time.Second
+	replyTimeout  = time.Second
+	convertPeriod = 4 * time.Second
+	// A sentinel whose is-master-down-by-addr answer is older than this does not count for ODOWN
+	masterDownValidity = 5 * time.Second
+
+	defaultReplicaPriority = 100
+)
+
+// Settings of a monitored master, set with the sentinel flags and SENTINEL SET
+type Settings struct {
+	DownAfter       time.Duration
+	FailoverTimeout time.Duration
+	ParallelSyncs   int
+}
+
+// Defaults of Redis Sentinel
+var DefaultSettings = Settings{
+	DownAfter:       30 * time.Second,
+	FailoverTimeout: 3 * time.Minute,
+	ParallelSyncs:   1,
+}
+
+/*
+* A sentinel monitors masters and their replicas, agrees with the other sentinels monitoring them
+* that a master is down and promotes one of its replicas. Clients ask it for the address of the
+* current master and can subscribe to its events (+switch-master and friends)
+ */
+type Sentinel struct {
+	addr string
+	port string
+	// Run id announced to other sentinels, votes are given to run ids
+	myID string
+	// Highest epoch seen, every failover attempt starts a new one
+	currentEpoch int64
+	masters      map[string]*master
+	settings     Settings
+
+	// Handles the commands of clients that are not sentinel specific: PING, pub/sub, HELLO, CLIENT
+	server    *command.Server
+	msgChan   chan client.ClientMsg
+	closeChan chan *client.Client
+	// Replies of instances and hellos, run on the sentinel loop
+	events chan func()
+}
+
+func NewSentinel(port string, settings Settings) *Sentinel {
+	store := persistence.NewStore()
+	return &Sentinel{
+		addr:      fmt.Sprintf("0.0.0.0:%s", port),
+		port:      port,
+		myID:      replication.NewReplID(),
+		masters:   map[string]*master{},
+		settings:  settings,
+		server:    command.NewServer(store, map[string]string{}, map[string]string{}),
+		msgChan:   make(chan client.ClientMsg),
+		closeChan: make(chan *client.Client),
+		events:    make(chan func()),
+	}
+}
+
+// Starts monitoring a master, "name host port quorum" like the sentinel monitor directive
+func (s *Sentinel) Monitor(name string, host string, port string, quorum int) error {
+	if _, ok := s.masters[name]; ok {
+		return fmt.Errorf("Duplicated master name")
+	}
+	if quorum <= 0 {
+		return fmt.Errorf("Quorum must be 1 or greater")
+	}
+	m := newMaster(name, net.JoinHostPort(host, port), quorum, s.settings)
+	s.masters[name] = m
+	s.event("+monitor", m, m.instance, fmt.Sprintf("quorum %d", quorum))
+	return nil
+}
+
+func (s *Sentinel) Start() {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		fmt.Printf("Failed to bind to port %s\n", s.port)
+		os.Exit(1)
+	}
+	slog.Info("Sentinel ID", "id", s.myID)
+
+	go s.loop()
+	s.acceptLoop(l)
+}
+
+func (s *Sentinel) acceptLoop(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			slog.Error("error accepting connection", "err", err)
+			continue
+		}
+
+		client := client.NewClient(conn, s.msgChan, s.closeChan)
+		s.server.Clients.Add(client)
+
+		go client.ReadLoop()
+	}
+}
+
+func (s *Sentinel) loop() {
+	cron := time.NewTicker(cronInterval)
+	defer cron.Stop()
+
+	for {
+		select {
+		case clientMsg := <-s.msgChan:
+			s.write(s.processCommand(clientMsg.Command, clientMsg.Client), clientMsg.Conn)
+
+		case closeClient := <-s.closeChan:
+			s.server.RemoveClient(closeClient)
+			closeClient.Conn().Close()
+
+		case event := <-s.events:
+			event()
+
+		case <-cron.C:
+			s.cron()
+		}
+	}
+}
+
+/*
+* Sentinels only run the commands they need: SENTINEL, INFO, ROLE, and PING, pub/sub, HELLO and
+* CLIENT which work like on any server. A hello published to a sentinel is processed directly
+ */
+func (s *Sentinel) processCommand(cmd []string, c *client.Client) string {
+	if len(cmd) == 0 {
+		return ""
+	}
+
+	switch strings.ToUpper(cmd[0]) {
+	case SENTINEL:
+		return s.sentinelCommand(cmd)
+	case command.INFO:
+		return s.infoCommand()
+	case command.ROLE:
+		return s.roleCommand()
+	case command.PUBLISH:
+		if len(cmd) == 3 && cmd[1] == helloChannel {
+			s.processHello(cmd[2])
+			return resp.RESPSerializeInteger(1)
+		}
+	case command.PING, command.SUBSCRIBE, command.UNSUBSCRIBE, command.PSUBSCRIBE, command.PUNSUBSCRIBE, command.HELLO, command.CLIENT:
+		response, err := command.CacheCommandHandler(cmd, c, s.server)
+		if err != nil {
+			return resp.RESPSerializeError(err.Error())
+		}
+		return response
+	}
+	return resp.RESPSerializeError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", cmd[0], strings.Join(cmd[1:], " ")))
+}
+
+/*
+* Sends a command to an instance in the background. handle runs on the sentinel loop with the
+* reply, or the error if the instance could not be reached
+ */
+func (s *Sentinel) send(inst *instance, handle func(reply, error), args ...string) {
+	inst.link.pending++
+	go func() {
+		r, err := inst.link.call(args, replyTimeout)
+		s.events <- func() {
+			inst.link.pending--
+			// Replies of an instance forgotten meanwhile, after a switch of master, are stale
+			if handle != nil && !inst.closed() {
+				handle(r, err)
+			}
+		}
+	}()
+}
+
+/*
+* Logs an event and publishes it to the clients of the sentinel on a channel named after it.
+* Like Redis the message is "<kind> <name> <ip> <port>", followed by "@ <master> <ip> <port>"
+* for replicas and sentinels
+ */
+func (s *Sentinel) event(kind string, m *master, inst *instance, details string) {
+	msg := fmt.Sprintf("%s %s %s %s", inst.kind, m.name, inst.host(), inst.port())
+	if inst != m.instance {
+		msg = fmt.Sprintf("%s %s %s %s @ %s %s %s", inst.kind, inst.addr, inst.host(), inst.port(), m.name, m.host(), m.port())
+	}
+	if details != "" {
+		msg += " " + details
+	}
+	slog.Info("Sentinel event", "event", kind, "msg", msg)
+	s.server.PubSub.Publish(kind, msg)
+}
+
+func (s *Sentinel) write(response string, conn net.Conn) {
+	if response == "" {
+		return
+	}
+	if _, err := conn.Write([]byte(response)); err != nil {
+		fmt.Printf("Error encountered when writing response: %s", err.Error())
+	}
+}
@@ -0,0 +1,144 @@
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInfo(t *testing.T) {
+	info := "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=127.0.0.1,port=7002,state=online,offset=42,lag=0\r\n" +
+		"slave1:ip=127.0.0.1,port=7003,state=online,offset=42,lag=1\r\n" +
+		"master_repl_offset:42\r\n"
+
+	fields, replicas := parseInfo(info)
+	if fields["role"] != "master" || fields["master_repl_offset"] != "42" {
+		t.Errorf("Unexpected fields: %v", fields)
+	}
+	if !slices.Equal(replicas, []string{"127.0.0.1:7002", "127.0.0.1:7003"}) {
+		t.Errorf("Unexpected replicas: %v", replicas)
+	}
+}
+
+func TestSelectReplica(t *testing.T) {
+	replica := func(addr string, priority int, offset int64) *instance {
+		r := newInstance(kindReplica, addr)
+		r.role, r.priority, r.replOffset = "slave", priority, offset
+		return r
+	}
+	replicas := map[string]*instance{
+		"a:1": replica("a:1", 100, 10),
+		"b:1": replica("b:1", 100, 20),
+		"c:1": replica("c:1", 0, 30),
+		"d:1": replica("d:1", 100, 50),
+	}
+	replicas["d:1"].sdown = true
+
+	if selected := selectReplica(replicas); selected != replicas["b:1"] {
+		t.Errorf("Expected the replica with the highest offset, got %s", selected.addr)
+	}
+
+	replicas["a:1"].priority = 10
+	if selected := selectReplica(replicas); selected != replicas["a:1"] {
+		t.Errorf("Expected the replica with the lowest priority, got %s", selected.addr)
+	}
+
+	replicas["a:1"].lastAvail = time.Now().Add(-time.Minute)
+	replicas["b:1"].role = "master"
+	if selected := selectReplica(replicas); selected != nil {
+		t.Errorf("Expected no replica, got %s", selected.addr)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("*3\r\n:1\r\n$3\r\nabc\r\n*-1\r\n+OK\r\n"))
+
+	r, err := readReply(reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(r.elems) != 3 || r.elems[0].integer() != 1 || r.elems[1].str != "abc" || !r.elems[2].null {
+		t.Errorf("Unexpected reply: %+v", r)
+	}
+
+	r, err = readReply(reader)
+	if err != nil || r.str != "OK" || r.isError() {
+		t.Errorf("Unexpected reply: %+v, %v", r, err)
+	}
+}
+
+func TestSubjectivelyDown(t *testing.T) {
+	s := NewSentinel("0", DefaultSettings)
+	m := newMaster("mymaster", "127.0.0.1:6379", 2, Settings{DownAfter: time.Second})
+	now := time.Now()
+
+	// Only an unanswered PING counts, an instance that replied long ago and has no PING pending is up
+	m.lastAvail = now.Add(-time.Minute)
+	s.checkSubjectivelyDown(m, m.instance, now)
+	if m.sdown {
+		t.Errorf("Expected the master to be up without a PING pending")
+	}
+
+	m.pingSent = now.Add(-900 * time.Millisecond)
+	s.checkSubjectivelyDown(m, m.instance, now)
+	if m.sdown {
+		t.Errorf("Expected the master to be up before down-after")
+	}
+
+	m.pingSent = now.Add(-1100 * time.Millisecond)
+	s.checkSubjectivelyDown(m, m.instance, now)
+	if !m.sdown {
+		t.Errorf("Expected the master to be down after down-after")
+	}
+
+	// A failed PING keeps the time of the first one, a valid reply brings the master back
+	m.pinging = true
+	pingReplied(m.instance, reply{}, errors.New("timeout"))
+	if m.pinging || m.pingSent.IsZero() {
+		t.Errorf("Expected the failed PING to keep the master unreachable")
+	}
+	m.pinging = true
+	pingReplied(m.instance, reply{kind: '*', elems: []reply{{kind: '+', str: "PONG"}}}, nil)
+	s.checkSubjectivelyDown(m, m.instance, time.Now())
+	if m.sdown || !m.pingSent.IsZero() {
+		t.Errorf("Expected the master to be up after a PONG")
+	}
+}
+
+func TestObjectivelyDown(t *testing.T) {
+	s := NewSentinel("0", DefaultSettings)
+	m := newMaster("mymaster", "127.0.0.1:6379", 2, DefaultSettings)
+	other := newInstance(kindSentinel, "127.0.0.1:26380")
+	m.sentinels["other"] = other
+
+	m.sdown = true
+	s.checkObjectivelyDown(m)
+	if m.odown {
+		t.Errorf("Expected no ODOWN without the quorum")
+	}
+
+	other.masterDown, other.masterDownReply = true, time.Now()
+	s.checkObjectivelyDown(m)
+	if !m.odown {
+		t.Errorf("Expected ODOWN once the quorum agrees")
+	}
+
+	// Answers of other sentinels expire
+	other.masterDownReply = time.Now().Add(-masterDownValidity)
+	s.checkObjectivelyDown(m)
+	if m.odown {
+		t.Errorf("Expected ODOWN to end with a stale answer")
+	}
+
+	other.masterDownReply = time.Now()
+	s.checkObjectivelyDown(m)
+	m.sdown = false
+	s.checkObjectivelyDown(m)
+	if m.odown {
+		t.Errorf("Expected ODOWN to end once the master is up")
+	}
+}
@@ -6,11 +6,18 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/jason-gill00/redis-from-scratch/master"
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/cluster"
+	"github.com/jason-gill00/redis-from-scratch/command"
+	"github.com/jason-gill00/redis-from-scratch/node"
 	pers "github.com/jason-gill00/redis-from-scratch/persistence"
-	"github.com/jason-gill00/redis-from-scratch/replica"
+	"github.com/jason-gill00/redis-from-scratch/sentinel"
+	"github.com/jason-gill00/redis-from-scratch/tracking"
 )
 
 var _ = net.Listen
@@ -18,11 +25,81 @@ var _ = os.Exit
 
 var dir = flag.String("dir", "", "RDB file store directory")
 var dbFileName = flag.String("dbfilename", "dump.rdb", "RDB dump")
+var save = flag.String("save", pers.DefaultSavePoints, "Save points as \"<seconds> <changes> ...\", empty to disable automatic saving")
 var port = flag.String("port", "6379", "Port to listen on")
 var replicaOf = flag.String("replicaof", "", "Replicate to another redis server")
+var replBacklogSize = flag.String("repl-backlog-size", "1mb", "Size of the backlog replicas can partially resynchronize from")
+var replTimeout = flag.Int("repl-timeout", 60, "Seconds without data from the master (or a replica) before the link is considered down")
+var replPingReplicaPeriod = flag.Int("repl-ping-replica-period", 10, "Seconds between the PINGs a master sends to its replicas")
+var minReplicasToWrite = flag.Int("min-replicas-to-write", 0, "Reject writes unless at least this many replicas are connected with a lag of at most min-replicas-max-lag")
+var minReplicasMaxLag = flag.Int("min-replicas-max-lag", 10, "Seconds since the last acknowledgment of a replica that still count for min-replicas-to-write")
+var replicaReadOnly = flag.String("replica-read-only", "yes", "Reject writes from clients while the server is a replica (yes or no)")
+var replDisklessSync = flag.String("repl-diskless-sync", "yes", "Send the RDB to replicas straight from memory instead of saving it to disk first (yes or no)")
+var notifyKeyspaceEvents = flag.String("notify-keyspace-events", "", "Keyspace event classes to publish (e.g. KEA)")
+var trackingTableMaxKeys = flag.Int("tracking-table-max-keys", tracking.DefaultMaxKeys, "Max number of keys in the client side caching tracking table")
+var maxMemory = flag.String("maxmemory", "0", "Memory limit for the dataset (e.g. 100mb), 0 means no limit")
+var maxMemoryPolicy = flag.String("maxmemory-policy", string(pers.NoEviction), "How keys are evicted once maxmemory is reached")
+var maxMemorySamples = flag.Int("maxmemory-samples", 5, "Number of keys sampled by the LRU, LFU and TTL eviction policies")
+var appendOnly = flag.String("appendonly", "no", "Log every write to the append only file and replay it on startup (yes or no)")
+var appendFileName = flag.String("appendfilename", pers.DefaultAofFileName, "Append only file name")
+var appendDirName = flag.String("appenddirname", pers.DefaultAofDirName, "Directory holding the append only files and their manifest")
+var aofUseRdbPreamble = flag.String("aof-use-rdb-preamble", "yes", "Write the base of the append only file in RDB form (yes or no)")
+var autoAofRewritePercentage = flag.Int("auto-aof-rewrite-percentage", 100, "Rewrite the append only file once it grew by this percentage since the last rewrite, 0 to disable")
+var autoAofRewriteMinSize = flag.String("auto-aof-rewrite-min-size", "64mb", "Minimum size of the append only file for an automatic rewrite")
+var appendFsync = flag.String("appendfsync", string(pers.FsyncEverySec), "When the append only file is flushed to disk: always, everysec or no")
+var aofLoadTruncated = flag.String("aof-load-truncated", "yes", "Load an append only file that ends in the middle of a command (yes or no)")
+var clusterEnabled = flag.String("cluster-enabled", "no", "Run as a node of a Redis Cluster (yes or no)")
+var clusterConfigFile = flag.String("cluster-config-file", "nodes.conf", "File in dir where a cluster node persists its view of the cluster")
+var clusterNodeTimeout = flag.Int("cluster-node-timeout", int(cluster.DefaultNodeTimeout/time.Millisecond), "Milliseconds a cluster node may not answer before it is considered failing")
+var sentinelMode = flag.Bool("sentinel", false, "Run as a sentinel monitoring the masters given with -sentinel-monitor")
+var sentinelDownAfter = flag.Int("sentinel-down-after-milliseconds", 30000, "Milliseconds without a valid reply before a sentinel considers an instance down")
+var sentinelFailoverTimeout = flag.Int("sentinel-failover-timeout", 180000, "Milliseconds a sentinel gives a failover before aborting it, failovers of a master are at least twice that apart")
+var sentinelParallelSyncs = flag.Int("sentinel-parallel-syncs", 1, "Replicas a sentinel reconfigures at the same time after a failover")
 
-func readRdbFile(dir string, dbFileName string, store *pers.Store) {
-	parsedRdb, err := pers.ParseRdbFile(dir + "/" + dbFileName)
+// Masters given as repeated -sentinel-monitor "<name> <host> <port> <quorum>"
+type sentinelMonitors [][]string
+
+func (m *sentinelMonitors) String() string {
+	return fmt.Sprint(*m)
+}
+
+func (m *sentinelMonitors) Set(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return fmt.Errorf("expected \"<name> <host> <port> <quorum>\"")
+	}
+	*m = append(*m, fields)
+	return nil
+}
+
+var monitors sentinelMonitors
+
+func init() {
+	flag.Var(&monitors, "sentinel-monitor", "Master a sentinel monitors as \"<name> <host> <port> <quorum>\", repeatable")
+}
+
+// Runs the server as a sentinel instead of a data node
+func startSentinel() {
+	s := sentinel.NewSentinel(*port, sentinel.Settings{
+		DownAfter:       time.Duration(*sentinelDownAfter) * time.Millisecond,
+		FailoverTimeout: time.Duration(*sentinelFailoverTimeout) * time.Millisecond,
+		ParallelSyncs:   *sentinelParallelSyncs,
+	})
+	for _, m := range monitors {
+		quorum, err := strconv.Atoi(m[3])
+		if err == nil {
+			err = s.Monitor(m[0], m[1], m[2], quorum)
+		}
+		if err != nil {
+			fmt.Printf("Invalid sentinel monitor %q: %v\n", strings.Join(m, " "), err)
+			os.Exit(1)
+		}
+	}
+	s.Start()
+}
+
+func readRdbFile(path string, store *pers.Store) {
+	parsedRdb, err := pers.ParseRdbFile(path)
 	if err != nil {
 		// If file does not exist, just continue
 		if os.IsNotExist(err) {
@@ -33,46 +110,152 @@ func readRdbFile(dir string, dbFileName string, store *pers.Store) {
 		fmt.Printf("Encountered error parsing rdb: %s \n", err.Error())
 		return
 	}
-	for key, value := range parsedRdb.Database {
+	store.Load(parsedRdb)
+}
 
-		if value.Expiration == nil {
-			store.Set(key, []byte(value.Value), nil)
-			continue
-		} else {
-			expiration := time.Unix(int64(*value.Expiration)/1000, 0).UTC()
-			store.Set(key, []byte(value.Value), &expiration)
+/*
+* Rebuilds the dataset by running every command of the append only file. Commands between MULTI
+* and EXEC are only run once EXEC is read, a transaction cut off by a crash is dropped
+ */
+func replayAof(server *command.Server) error {
+	replayClient := client.NewClient(nil, nil, nil)
+	// Like the commands of a master, the AOF is applied even on a read only replica
+	replayClient.Master = true
+
+	commands := 0
+	var transaction [][]string
+	run := func(cmd []string) {
+		commands++
+		response, err := command.CacheCommandHandler(cmd, replayClient, server)
+		if err != nil || strings.HasPrefix(response, "-") {
+			slog.Warn("Command of the append only file failed", "command", cmd[0], "response", strings.TrimSpace(response), "err", err)
 		}
 	}
+
+	err := server.Aof.Load(func(rdb *pers.RdbFile) {
+		server.Store.Load(rdb)
+	}, func(cmd []string) {
+		switch {
+		case command.IsMulti(cmd):
+			transaction = [][]string{}
+		case transaction != nil && !command.IsExec(cmd):
+			transaction = append(transaction, cmd)
+		case transaction != nil:
+			for _, queued := range transaction {
+				run(queued)
+			}
+			transaction = nil
+		default:
+			run(cmd)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if transaction != nil {
+		slog.Warn("Ignoring the incomplete MULTI/EXEC transaction at the end of the append only file", "commands", len(transaction))
+	}
+
+	slog.Info("Loaded the append only file", "commands", commands)
+	return nil
 }
 
 func main() {
 	flag.Parse()
 
+	if *sentinelMode {
+		startSentinel()
+		return
+	}
+
 	config := map[string]string{
-		"dir":        *dir,
-		"dbFileName": *dbFileName,
+		"dir":                         *dir,
+		"dbfilename":                  *dbFileName,
+		"save":                        *save,
+		"repl-backlog-size":           *replBacklogSize,
+		"repl-diskless-sync":          *replDisklessSync,
+		"replica-read-only":           *replicaReadOnly,
+		"min-replicas-to-write":       strconv.Itoa(*minReplicasToWrite),
+		"min-replicas-max-lag":        strconv.Itoa(*minReplicasMaxLag),
+		"repl-timeout":                strconv.Itoa(*replTimeout),
+		"repl-ping-replica-period":    strconv.Itoa(*replPingReplicaPeriod),
+		"notify-keyspace-events":      *notifyKeyspaceEvents,
+		"tracking-table-max-keys":     strconv.Itoa(*trackingTableMaxKeys),
+		"maxmemory":                   *maxMemory,
+		"maxmemory-policy":            *maxMemoryPolicy,
+		"maxmemory-samples":           strconv.Itoa(*maxMemorySamples),
+		"appendonly":                  *appendOnly,
+		"appendfilename":              *appendFileName,
+		"appenddirname":               *appendDirName,
+		"aof-use-rdb-preamble":        *aofUseRdbPreamble,
+		"auto-aof-rewrite-percentage": strconv.Itoa(*autoAofRewritePercentage),
+		"auto-aof-rewrite-min-size":   *autoAofRewriteMinSize,
+		"appendfsync":                 *appendFsync,
+		"aof-load-truncated":          *aofLoadTruncated,
+		"cluster-enabled":             *clusterEnabled,
+		"cluster-config-file":         *clusterConfigFile,
+		"cluster-node-timeout":        strconv.Itoa(*clusterNodeTimeout),
 	}
 
 	store := pers.NewStore()
 
-	// If a rdb file is provided, read the database and store it in the server
-	if config["dir"] != "" && config["dbFileName"] != "" {
-		readRdbFile(config["dir"], config["dbFileName"], store)
-	}
-
 	replicationConfig := map[string]string{
 		"replicaof": *replicaOf,
 	}
 
-	// If the server is a replica, start the replica server
-	if replicationConfig["replicaof"] != "" {
-		replicationConfig["slave_repl_offset"] = "0"
-		replica := replica.NewReplica(replicationConfig, store, config, *port)
-		replica.Start()
-		return
+	server := command.NewServer(store, config, replicationConfig)
+	if *clusterEnabled == "yes" {
+		portNum, err := strconv.Atoi(*port)
+		if err != nil {
+			fmt.Printf("Invalid port: %s \n", *port)
+			os.Exit(1)
+		}
+		server.Cluster, err = cluster.Open(filepath.Join(*dir, *clusterConfigFile), portNum)
+		if err != nil {
+			fmt.Printf("Could not load the cluster configuration: %s \n", err.Error())
+			os.Exit(1)
+		}
+		store.IndexSlots()
 	}
+	for param, val := range config {
+		// The AOF is opened once the dataset is loaded
+		if param == "appendonly" {
+			continue
+		}
+		if err := server.ApplyConfig(param, val); err != nil {
+			fmt.Printf("Invalid %s: %s \n", param, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// The AOF has the most recent writes so it is preferred over the RDB file
+	if config["appendonly"] == "yes" && server.Aof.Exists() {
+		if err := replayAof(server); err != nil {
+			fmt.Printf("Encountered error loading the append only file: %s \n", err.Error())
+			os.Exit(1)
+		}
+	} else if config["dbfilename"] != "" {
+		// If a rdb file is provided, read the database and store it in the server
+		readRdbFile(filepath.Join(config["dir"], config["dbfilename"]), store)
+	}
+
+	if err := server.ApplyConfig("appendonly", config["appendonly"]); err != nil {
+		fmt.Printf("Invalid appendonly: %s \n", err.Error())
+		os.Exit(1)
+	}
+	go server.Aof.FsyncLoop()
+
+	// Publish keyspace notifications for every key the store modifies, expires or evicts
+	store.OnKeyspaceEvent(server.PubSub.NotifyKeyspaceEvent)
+
+	// Invalidate the keys cached by clients that enabled CLIENT TRACKING
+	store.OnKeyspaceEvent(server.Tracker.OnKeyspaceEvent)
+
+	// Loading the RDB file is not a change that needs to be saved again
+	store.SavedSnapshot(&pers.Snapshot{Dirty: store.Dirty()})
+	go server.Saver.SavePointLoop()
 
-	// If the server is a master, start the master server
-	master := master.NewMaster(replicationConfig, store, config, *port)
-	master.Start()
+	// A master, or a replica of -replicaof until REPLICAOF changes it
+	node := node.NewNode(server, *port)
+	node.Start()
 }
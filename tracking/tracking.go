@@ -0,0 +1,391 @@
+package tracking
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/pubsub"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// RESP2 clients receive invalidations as pub/sub messages on this channel (through redirection)
+const InvalidateChannel = "__redis__:invalidate"
+
+const DefaultMaxKeys = 1000000
+
+// Options of CLIENT TRACKING ON
+type Options struct {
+	Redirect int64
+	Bcast    bool
+	Prefixes []string
+	OptIn    bool
+	OptOut   bool
+	NoLoop   bool
+}
+
+type trackingClient struct {
+	client  *client.Client
+	options Options
+	// Set by CLIENT CACHING yes|no and only valid for the next command
+	caching    bool
+	cachingSet bool
+}
+
+/*
+* Tracker implements server assisted client side caching. In the default mode it remembers which
+* keys every tracking client has read and sends them an invalidation message once the key is
+* modified, expires or is evicted. In BCAST mode clients are notified about every modified key
+* matching one of their prefixes, no matter if they read it
+ */
+type Tracker struct {
+	clients map[int64]*trackingClient
+	// Key -> ids of the clients that might have the key cached
+	table map[string]map[int64]bool
+	// Prefix -> ids of the BCAST clients subscribed to it
+	prefixes map[string]map[int64]bool
+	// Keys modified by the current command that still need to be broadcasted to BCAST clients,
+	// mapped to the id of the client that modified them (for NOLOOP)
+	pendingBcast map[string]int64
+	maxKeys      int
+	// Client executing the current command, used to honour NOLOOP
+	current  *client.Client
+	registry *client.Registry
+	pubSub   *pubsub.PubSub
+	mu       sync.Mutex
+}
+
+func NewTracker(registry *client.Registry, pubSub *pubsub.PubSub) *Tracker {
+	return &Tracker{
+		clients:      map[int64]*trackingClient{},
+		table:        map[string]map[int64]bool{},
+		prefixes:     map[string]map[int64]bool{},
+		pendingBcast: map[string]int64{},
+		maxKeys:      DefaultMaxKeys,
+		registry:     registry,
+		pubSub:       pubSub,
+		mu:           sync.Mutex{},
+	}
+}
+
+// Enables tracking for the client, replacing any options it had before
+func (t *Tracker) Enable(c *client.Client, options Options) error {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	if options.OptIn && options.OptOut {
+		return fmt.Errorf("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if len(options.Prefixes) > 0 && !options.Bcast {
+		return fmt.Errorf("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if options.Bcast && (options.OptIn || options.OptOut) {
+		return fmt.Errorf("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if options.Redirect != 0 && options.Redirect != c.Id {
+		if _, ok := t.registry.Get(options.Redirect); !ok {
+			return fmt.Errorf("ERR The client ID you want redirect to does not exist")
+		}
+	}
+	if err := checkPrefixOverlap(options.Prefixes); err != nil {
+		return err
+	}
+
+	if existing, ok := t.clients[c.Id]; ok {
+		if existing.options.Bcast != options.Bcast || existing.options.OptIn != options.OptIn || existing.options.OptOut != options.OptOut {
+			return fmt.Errorf("ERR You can't switch BCAST, OPTIN or OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode")
+		}
+		t.removePrefixes(c.Id)
+	}
+
+	if options.Bcast && len(options.Prefixes) == 0 {
+		// No prefix means the client is notified about every key
+		options.Prefixes = []string{""}
+	}
+	for _, prefix := range options.Prefixes {
+		if _, ok := t.prefixes[prefix]; !ok {
+			t.prefixes[prefix] = map[int64]bool{}
+		}
+		t.prefixes[prefix][c.Id] = true
+	}
+
+	t.clients[c.Id] = &trackingClient{client: c, options: options}
+	return nil
+}
+
+/*
+* Disables tracking for the client. Keys it read stay in the table, they are lazily
+* dropped the next time they are invalidated
+ */
+func (t *Tracker) Disable(c *client.Client) {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	t.removePrefixes(c.Id)
+	delete(t.clients, c.Id)
+}
+
+// RemoveClient drops the tracking state of a client that disconnected
+func (t *Tracker) RemoveClient(c *client.Client) {
+	t.Disable(c)
+}
+
+// CLIENT CACHING yes|no, controls if the keys read by the next command are tracked
+func (t *Tracker) Caching(c *client.Client, yes bool) error {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	tc, ok := t.clients[c.Id]
+	if !ok || (!tc.options.OptIn && !tc.options.OptOut) {
+		return fmt.Errorf("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	if yes && !tc.options.OptIn {
+		return fmt.Errorf("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	}
+	if !yes && !tc.options.OptOut {
+		return fmt.Errorf("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	}
+
+	tc.caching = yes
+	tc.cachingSet = true
+	return nil
+}
+
+// Returns the options of a tracking client, or false if tracking is off
+func (t *Tracker) Info(c *client.Client) (Options, bool) {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	tc, ok := t.clients[c.Id]
+	if !ok {
+		return Options{}, false
+	}
+	return tc.options, true
+}
+
+func (t *Tracker) SetMaxKeys(maxKeys int) {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	t.maxKeys = maxKeys
+	t.enforceMaxKeys()
+}
+
+// Number of keys currently in the tracking table
+func (t *Tracker) Keys() int {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	return len(t.table)
+}
+
+// Marks the client as the one executing the current command
+func (t *Tracker) BeforeCommand(c *client.Client) {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	t.current = c
+}
+
+/*
+* Called after a command was executed. If it was a read only command the keys it read are
+* remembered for the client. The CLIENT CACHING flag only applies to a single command so it is reset
+ */
+func (t *Tracker) AfterCommand(c *client.Client, readKeys []string, resetCaching bool) {
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	t.current = nil
+	t.flushBcast()
+
+	tc, ok := t.clients[c.Id]
+	if !ok {
+		return
+	}
+
+	track := !tc.options.Bcast
+	if tc.options.OptIn {
+		track = track && tc.cachingSet && tc.caching
+	}
+	if tc.options.OptOut {
+		track = track && !(tc.cachingSet && !tc.caching)
+	}
+	if resetCaching {
+		tc.caching, tc.cachingSet = false, false
+	}
+	if !track {
+		return
+	}
+
+	for _, key := range readKeys {
+		if _, ok := t.table[key]; !ok {
+			t.table[key] = map[int64]bool{}
+		}
+		t.table[key][c.Id] = true
+	}
+	t.enforceMaxKeys()
+}
+
+// Invalidates tracked keys whenever the store modifies, expires or evicts them
+func (t *Tracker) OnKeyspaceEvent(event persistence.KeyspaceEvent) {
+	// Key misses don't modify anything and a new key always comes with the event of the command that created it
+	if event.Class == persistence.EventKeyMiss || event.Class == persistence.EventNew {
+		return
+	}
+
+	defer t.mu.Unlock()
+
+	t.mu.Lock()
+	// Keys that expire or get evicted were not modified by the current client, so NOLOOP does not apply
+	skipCurrent := event.Class != persistence.EventExpired && event.Class != persistence.EventEvicted
+	t.invalidate(event.Key, skipCurrent)
+
+	// Outside of a command (e.g active expiry) there is nothing to batch with
+	if t.current == nil {
+		t.flushBcast()
+	}
+}
+
+func (t *Tracker) invalidate(key string, skipCurrent bool) {
+	var modifiedBy int64
+	if skipCurrent && t.current != nil {
+		modifiedBy = t.current.Id
+	}
+
+	t.invalidateTrackedKey(key, modifiedBy)
+	if len(t.prefixes) > 0 {
+		t.pendingBcast[key] = modifiedBy
+	}
+}
+
+// Notifies the clients that read the key and removes it from the tracking table
+func (t *Tracker) invalidateTrackedKey(key string, modifiedBy int64) {
+	for id := range t.table[key] {
+		tc, ok := t.clients[id]
+		if !ok || tc.options.Bcast {
+			continue
+		}
+		if tc.options.NoLoop && id == modifiedBy {
+			continue
+		}
+		t.send(tc, []string{key})
+	}
+	delete(t.table, key)
+}
+
+/*
+* Sends the keys modified by the last command to the BCAST clients with a matching prefix.
+* Keys are batched so every client receives a single invalidation message, even if a key
+* was touched several times by the same command
+ */
+func (t *Tracker) flushBcast() {
+	if len(t.pendingBcast) == 0 {
+		return
+	}
+
+	keysByClient := map[int64][]string{}
+	for key, modifiedBy := range t.pendingBcast {
+		for prefix, ids := range t.prefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for id := range ids {
+				if t.clients[id].options.NoLoop && id == modifiedBy {
+					continue
+				}
+				keysByClient[id] = append(keysByClient[id], key)
+			}
+		}
+	}
+	t.pendingBcast = map[string]int64{}
+
+	for id, keys := range keysByClient {
+		sort.Strings(keys)
+		t.send(t.clients[id], keys)
+	}
+}
+
+/*
+* Evicts keys from the tracking table until it is within tracking-table-max-keys. The clients
+* of an evicted key are sent an invalidation since the server can't notify them anymore
+ */
+func (t *Tracker) enforceMaxKeys() {
+	if t.maxKeys <= 0 {
+		return
+	}
+	for key := range t.table {
+		if len(t.table) <= t.maxKeys {
+			return
+		}
+		t.invalidateTrackedKey(key, 0)
+	}
+}
+
+/*
+* Sends the invalidation to the client or to the client it redirects to. RESP3 clients get a
+* push message, RESP2 redirect targets get a message on the __redis__:invalidate channel
+ */
+func (t *Tracker) send(tc *trackingClient, keys []string) {
+	target := tc.client
+	if tc.options.Redirect != 0 {
+		redirectTarget, ok := t.registry.Get(tc.options.Redirect)
+		if !ok {
+			// The redirect client disconnected, let the tracking client know its cache can't be trusted
+			if tc.client.Protocol >= 3 {
+				write(tc.client, resp.RESPSerializePush([]string{resp.RESPSerializeBulkString("tracking-redir-broken"), resp.RESPSerializeInteger(int(tc.options.Redirect))}))
+			}
+			return
+		}
+		target = redirectTarget
+	}
+
+	serializedKeys := resp.RESPSerializeRESPArray(keys)
+	if target.Protocol >= 3 {
+		write(target, resp.RESPSerializePush([]string{resp.RESPSerializeBulkString("invalidate"), serializedKeys}))
+		return
+	}
+
+	// RESP2 has no push messages, the only way to deliver an invalidation is to a subscriber of the channel
+	if tc.options.Redirect != 0 && t.pubSub.IsSubscribed(target, InvalidateChannel) {
+		write(target, resp.RESPSerializeRawArray([]string{
+			resp.RESPSerializeBulkString("message"),
+			resp.RESPSerializeBulkString(InvalidateChannel),
+			serializedKeys,
+		}))
+	}
+}
+
+func (t *Tracker) removePrefixes(id int64) {
+	tc, ok := t.clients[id]
+	if !ok {
+		return
+	}
+	for _, prefix := range tc.options.Prefixes {
+		delete(t.prefixes[prefix], id)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+}
+
+// Prefixes of a client can't overlap, otherwise a key would be invalidated twice
+func checkPrefixOverlap(prefixes []string) error {
+	for i, a := range prefixes {
+		for _, b := range prefixes[i+1:] {
+			if strings.HasPrefix(a, b) || strings.HasPrefix(b, a) {
+				return fmt.Errorf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", a, b)
+			}
+		}
+	}
+	return nil
+}
+
+func write(c *client.Client, message string) {
+	if err := c.Write(message); err != nil {
+		slog.Error("Error encountered when writing invalidation", "err", err)
+	}
+}
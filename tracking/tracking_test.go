@@ -0,0 +1,225 @@
+package tracking
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/jason-gill00/redis-from-scratch/client"
+	"github.com/jason-gill00/redis-from-scratch/persistence"
+	"github.com/jason-gill00/redis-from-scratch/pubsub"
+	"github.com/jason-gill00/redis-from-scratch/resp"
+)
+
+// A connection that records what is written to it
+type recordConn struct {
+	net.Conn
+	written []string
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, string(b))
+	return len(b), nil
+}
+
+func newTestClient(t *Tracker, protocol int) (*client.Client, *recordConn) {
+	conn := &recordConn{}
+	c := client.NewClient(conn, nil, nil)
+	c.Protocol = protocol
+	t.registry.Add(c)
+	return c, conn
+}
+
+func newTestTracker() *Tracker {
+	return NewTracker(client.NewRegistry(), pubsub.NewPubSub())
+}
+
+func invalidation(keys ...string) string {
+	return resp.RESPSerializePush([]string{resp.RESPSerializeBulkString("invalidate"), resp.RESPSerializeRESPArray(keys)})
+}
+
+// Runs a command of the client that modifies the keys
+func modify(t *Tracker, c *client.Client, keys ...string) {
+	t.BeforeCommand(c)
+	for _, key := range keys {
+		t.OnKeyspaceEvent(persistence.KeyspaceEvent{Class: persistence.EventString, Event: "set", Key: key})
+	}
+	t.AfterCommand(c, nil, true)
+}
+
+// Runs a read only command of the client
+func read(t *Tracker, c *client.Client, keys ...string) {
+	t.BeforeCommand(c)
+	t.AfterCommand(c, keys, true)
+}
+
+func TestTracking(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		// Run by the tracking client, then the keys are modified by another client
+		run      func(tr *Tracker, c *client.Client)
+		modified []string
+		// The keys are modified by a single command instead of one command each
+		batch    bool
+		expected []string
+	}{
+		{
+			name:     "default mode invalidates the keys read",
+			run:      func(tr *Tracker, c *client.Client) { read(tr, c, "foo") },
+			modified: []string{"foo", "bar", "foo"},
+			expected: []string{invalidation("foo")},
+		},
+		{
+			name:     "default mode ignores keys that were not read",
+			run:      func(tr *Tracker, c *client.Client) {},
+			modified: []string{"foo"},
+			expected: nil,
+		},
+		{
+			name:     "bcast sends every key matching a prefix in one message",
+			options:  Options{Bcast: true, Prefixes: []string{"user:", "session:"}},
+			modified: []string{"user:2", "other", "session:1", "user:1"},
+			batch:    true,
+			expected: []string{invalidation("session:1", "user:1", "user:2")},
+		},
+		{
+			name:     "bcast without prefix sends every key",
+			options:  Options{Bcast: true},
+			modified: []string{"b", "a"},
+			batch:    true,
+			expected: []string{invalidation("a", "b")},
+		},
+		{
+			name:     "optin does not track reads by default",
+			options:  Options{OptIn: true},
+			run:      func(tr *Tracker, c *client.Client) { read(tr, c, "foo") },
+			modified: []string{"foo"},
+			expected: nil,
+		},
+		{
+			name:    "optin tracks the command after CLIENT CACHING yes",
+			options: Options{OptIn: true},
+			run: func(tr *Tracker, c *client.Client) {
+				tr.Caching(c, true)
+				read(tr, c, "foo")
+				read(tr, c, "bar")
+			},
+			modified: []string{"foo", "bar"},
+			expected: []string{invalidation("foo")},
+		},
+		{
+			name:    "optout skips the command after CLIENT CACHING no",
+			options: Options{OptOut: true},
+			run: func(tr *Tracker, c *client.Client) {
+				tr.Caching(c, false)
+				read(tr, c, "foo")
+				read(tr, c, "bar")
+			},
+			modified: []string{"foo", "bar"},
+			expected: []string{invalidation("bar")},
+		},
+		{
+			name:    "noloop skips the keys the client modified itself",
+			options: Options{NoLoop: true},
+			run: func(tr *Tracker, c *client.Client) {
+				read(tr, c, "foo", "bar")
+				modify(tr, c, "foo")
+			},
+			modified: []string{"foo", "bar"},
+			expected: []string{invalidation("bar")},
+		},
+		{
+			name:    "without noloop the client is told about its own writes",
+			options: Options{},
+			run: func(tr *Tracker, c *client.Client) {
+				read(tr, c, "foo")
+				modify(tr, c, "foo")
+			},
+			expected: []string{invalidation("foo")},
+		},
+		{
+			name:    "noloop applies to bcast",
+			options: Options{Bcast: true, NoLoop: true},
+			run: func(tr *Tracker, c *client.Client) {
+				modify(tr, c, "mine")
+			},
+			modified: []string{"theirs"},
+			expected: []string{invalidation("theirs")},
+		},
+	}
+
+	for _, test := range tests {
+		tr := newTestTracker()
+		c, conn := newTestClient(tr, 3)
+		other, _ := newTestClient(tr, 3)
+		if err := tr.Enable(c, test.options); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		if test.run != nil {
+			test.run(tr, c)
+		}
+		if test.batch {
+			modify(tr, other, test.modified...)
+		} else {
+			for _, key := range test.modified {
+				modify(tr, other, key)
+			}
+		}
+
+		if !reflect.DeepEqual(conn.written, test.expected) {
+			t.Errorf("%s: expected %q, received %q", test.name, test.expected, conn.written)
+		}
+	}
+}
+
+func TestTrackingMaxKeys(t *testing.T) {
+	tr := newTestTracker()
+	c, conn := newTestClient(tr, 3)
+	tr.Enable(c, Options{})
+	read(tr, c, "a", "b", "c")
+
+	// The keys evicted from the table are invalidated since they can't be tracked anymore
+	tr.SetMaxKeys(1)
+	if tr.Keys() != 1 || len(conn.written) != 2 {
+		t.Errorf("Expected 1 key left and 2 invalidations, Received: %d keys and %q", tr.Keys(), conn.written)
+	}
+
+	read(tr, c, "d")
+	if tr.Keys() != 1 || len(conn.written) != 3 {
+		t.Errorf("Expected 1 key left and 3 invalidations, Received: %d keys and %q", tr.Keys(), conn.written)
+	}
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	tr := newTestTracker()
+	c, _ := newTestClient(tr, 2)
+	target, targetConn := newTestClient(tr, 2)
+	other, _ := newTestClient(tr, 2)
+	if err := tr.Enable(c, Options{Redirect: target.Id}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A RESP2 target only receives invalidations once it subscribed to their channel
+	tr.pubSub.Subscribe(target, []string{"news"})
+	targetConn.written = nil
+	read(tr, c, "foo")
+	modify(tr, other, "foo")
+	if len(targetConn.written) != 0 {
+		t.Errorf("Expected no invalidation without a subscription to %s, Received: %q", InvalidateChannel, targetConn.written)
+	}
+
+	tr.pubSub.Subscribe(target, []string{InvalidateChannel})
+	targetConn.written = nil
+	read(tr, c, "foo")
+	modify(tr, other, "foo")
+	expected := resp.RESPSerializeRawArray([]string{
+		resp.RESPSerializeBulkString("message"),
+		resp.RESPSerializeBulkString(InvalidateChannel),
+		resp.RESPSerializeRESPArray([]string{"foo"}),
+	})
+	if !reflect.DeepEqual(targetConn.written, []string{expected}) {
+		t.Errorf("Expected %q, Received: %q", expected, targetConn.written)
+	}
+}